@@ -5,8 +5,10 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/spacemeshos/go-spacemesh/common"
 	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/state"
 )
 
 // Pretty returns an account logging string
@@ -18,6 +20,19 @@ func (a *Account) String() string {
 	return a.PubKey.String()
 }
 
+// Address returns the address of the account in the global state
+func (a *Account) Address() common.Address {
+	return state.PublicKeyToAddress(a.PubKey)
+}
+
+// SignTransaction signs the transaction as sent from the account. The account must be unlocked
+func (a *Account) SignTransaction(tx *state.Transaction) error {
+	if a.IsAccountLocked() {
+		return errors.New("account is locked")
+	}
+	return state.SignTransaction(tx, a.PrivKey)
+}
+
 // Log account info
 func (a *Account) Log() {
 
@@ -1,7 +1,10 @@
 package accounts
 
 import (
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/state"
 	"github.com/stretchr/testify/assert"
+	"math/big"
 	"testing"
 )
 
@@ -20,3 +23,22 @@ func TestLockAccount(t *testing.T) {
 	// assert
 	assert.True(t, account.IsAccountLocked(), "Expected account to be locked")
 }
+
+func TestAccount_SignTransaction(t *testing.T) {
+	const passphrase = "a-weak-passphrase-123"
+	account, err := NewAccount(passphrase)
+	if err != nil {
+		t.Fatalf("Failed to create an account")
+	}
+
+	recipient := common.BytesToAddress([]byte{0x01})
+	tx := &state.Transaction{AccountNonce: 1, Recipient: &recipient, Amount: big.NewInt(10), Price: big.NewInt(1)}
+	assert.NoError(t, account.SignTransaction(tx))
+
+	origin, err := tx.Origin()
+	assert.NoError(t, err)
+	assert.Equal(t, account.Address(), origin)
+
+	account.LockAccount(passphrase)
+	assert.Error(t, account.SignTransaction(&state.Transaction{Recipient: &recipient, Amount: big.NewInt(10)}))
+}
@@ -23,6 +23,8 @@ func init() {
 		"config", "c", config.BaseConfig.ConfigFile, "Set Load configuration from file")
 	RootCmd.PersistentFlags().StringVarP(&config.BaseConfig.DataDir, "datadir", "d",
 		config.BaseConfig.DataDir, "Specify data directory for spacemesh")
+	RootCmd.PersistentFlags().StringVar(&config.BaseConfig.GenesisFile, "genesis-file",
+		config.BaseConfig.GenesisFile, "Load the genesis state and network params from a json file")
 	/** ======================== P2P Flags ========================== **/
 	RootCmd.PersistentFlags().IntVar(&config.P2P.SecurityParam, "security-param",
 		config.P2P.SecurityParam, "Consensus protocol k security param")
@@ -80,6 +82,22 @@ func init() {
 	/**========================Consensus Flags ========================== **/
 	//todo: add this here
 
+	/**======================== State Flags ========================== **/
+	RootCmd.PersistentFlags().Uint64Var(&config.STATE.HistoryRetention, "history-retention",
+		config.STATE.HistoryRetention, "The number of layers the state can be reverted to, 0 keeps all layers")
+
+	/**======================== Mempool Flags ========================== **/
+	RootCmd.PersistentFlags().IntVar(&config.MEMPOOL.Size, "mempool-size",
+		config.MEMPOOL.Size, "The max number of pending transactions")
+	RootCmd.PersistentFlags().IntVar(&config.MEMPOOL.PriceBump, "mempool-price-bump",
+		config.MEMPOOL.PriceBump, "The min price increase in percent to replace a pending transaction")
+	RootCmd.PersistentFlags().Uint64Var(&config.MEMPOOL.MaxNonceGap, "mempool-max-nonce-gap",
+		config.MEMPOOL.MaxNonceGap, "The max number of nonces a transaction is ahead of its sender's state nonce")
+	RootCmd.PersistentFlags().IntVar(&config.MEMPOOL.MaxInvalidRelays, "max-invalid-relays",
+		config.MEMPOOL.MaxInvalidRelays, "The number of invalid transactions a peer relays before it is throttled")
+	RootCmd.PersistentFlags().DurationVar(&config.MEMPOOL.ThrottleDuration, "throttle-duration",
+		config.MEMPOOL.ThrottleDuration, "The time the transactions of a throttled peer are dropped")
+
 	RootCmd.AddCommand(VersionCmd)
 
 	// Bind Flags to config
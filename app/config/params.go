@@ -8,4 +8,5 @@ const (
 	AppCopyrightNotice    = "(c) 2017 The go-spacemesh Authors"
 	AccountsDirectoryName = "accounts"
 	LogDirectoryName      = "logs"
+	StateDirectoryName    = "state"
 )
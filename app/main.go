@@ -13,9 +13,11 @@ import (
 	"github.com/spacemeshos/go-spacemesh/api"
 	"github.com/spacemeshos/go-spacemesh/app/cmd"
 	cfg "github.com/spacemeshos/go-spacemesh/config"
+	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/spacemeshos/go-spacemesh/filesystem"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p"
+	"github.com/spacemeshos/go-spacemesh/state"
 	"github.com/spacemeshos/go-spacemesh/timesync"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -29,6 +31,8 @@ type SpacemeshApp struct {
 	NodeInitCallback chan bool
 	grpcAPIService   *api.SpaceMeshGrpcService
 	jsonAPIService   *api.JSONHTTPServer
+	stateDB          database.Database
+	state            *state.StateDB
 }
 
 // EntryPointCreated channel is used to announce that the main App instance was created
@@ -115,6 +119,14 @@ func EnsureCLIFlags(cmd *cobra.Command, appcfg *cfg.Config) {
 			ff = reflect.TypeOf(appcfg.CONSENSUS)
 			elem = reflect.ValueOf(&appcfg.CONSENSUS).Elem()
 			assignFields(ff, elem, name)
+
+			ff = reflect.TypeOf(appcfg.STATE)
+			elem = reflect.ValueOf(&appcfg.STATE).Elem()
+			assignFields(ff, elem, name)
+
+			ff = reflect.TypeOf(appcfg.MEMPOOL)
+			elem = reflect.ValueOf(&appcfg.MEMPOOL).Elem()
+			assignFields(ff, elem, name)
 		}
 	})
 }
@@ -221,15 +233,60 @@ func (app *SpacemeshApp) cleanup(cmd *cobra.Command, args []string) (err error)
 		app.grpcAPIService.StopService()
 	}
 
+	if app.stateDB != nil {
+		log.Info("Closing state database...")
+		app.stateDB.Close()
+	}
+
 	// add any other cleanup tasks here....
 	log.Info("App cleanup completed\n\n")
 
 	return nil
 }
 
+// setupGenesis opens the global state of the data directory, the genesis state is created on the first start
+func (app *SpacemeshApp) setupGenesis() error {
+	genesis := state.DefaultGenesis()
+	if app.Config.GenesisFile != "" {
+		var err error
+		if genesis, err = state.LoadGenesis(app.Config.GenesisFile); err != nil {
+			return err
+		}
+	}
+	// the network params are part of the genesis so all nodes apply the layers the same way, they can't be set
+	// by flags or the node config
+	retention := app.Config.STATE.HistoryRetention
+	app.Config.STATE = genesis.Config
+	app.Config.STATE.HistoryRetention = retention
+
+	stateDir, err := filesystem.GetStateDataDirectoryPath()
+	if err != nil {
+		return err
+	}
+
+	db, err := database.NewLDBDatabase(stateDir, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	app.state, err = state.SetupGenesis(db, genesis)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	app.stateDB = db
+
+	return nil
+}
+
 func (app *SpacemeshApp) startSpacemesh(cmd *cobra.Command, args []string) {
 	log.Info("Starting Spacemesh")
 
+	if err := app.setupGenesis(); err != nil {
+		log.Error("Error setting up the genesis state, err: %v", err)
+		panic("Error setting up the genesis state")
+	}
+
 	// start p2p services
 	log.Info("Initializing P2P services")
 	swarm, err := p2p.New(Ctx, app.Config.P2P)
@@ -6,7 +6,9 @@ import (
 	consensusConfig "github.com/spacemeshos/go-spacemesh/consensus/config"
 	"github.com/spacemeshos/go-spacemesh/filesystem"
 	"github.com/spacemeshos/go-spacemesh/log"
+	mempoolConfig "github.com/spacemeshos/go-spacemesh/mempool/config"
 	p2pConfig "github.com/spacemeshos/go-spacemesh/p2p/config"
+	stateConfig "github.com/spacemeshos/go-spacemesh/state/config"
 	"github.com/spf13/viper"
 	"path/filepath"
 )
@@ -34,6 +36,8 @@ type Config struct {
 	P2P        p2pConfig.Config       `mapstructure:"p2p"`
 	API        apiConfig.Config       `mapstructure:"api"`
 	CONSENSUS  consensusConfig.Config `mapstructure:"consensus"`
+	STATE      stateConfig.Config     `mapstructure:"state"`
+	MEMPOOL    mempoolConfig.Config   `mapstructure:"mempool"`
 }
 
 // BaseConfig defines the default configuration options for spacemesh app
@@ -47,6 +51,8 @@ type BaseConfig struct {
 	LogDir string `mapstructure:"log-dir"`
 
 	AccountDir string `mapstructure:"account-dir"`
+
+	GenesisFile string `mapstructure:"genesis-file"`
 }
 
 // DefaultConfig returns the default configuration for a spacemesh node
@@ -56,6 +62,8 @@ func DefaultConfig() Config {
 		P2P:        p2pConfig.DefaultConfig(),
 		API:        apiConfig.DefaultConfig(),
 		CONSENSUS:  consensusConfig.DefaultConfig(),
+		STATE:      stateConfig.DefaultConfig(),
+		MEMPOOL:    mempoolConfig.DefaultConfig(),
 	}
 }
 
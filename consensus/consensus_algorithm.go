@@ -61,9 +61,27 @@ type NetworkConnection interface {
 	RegisterProtocol(protocolName string) chan OpaqueMessage
 }
 
-// Timer is an interface to receive current time
+// Timer is an interface to receive current time and to wait for the end of rounds
 type Timer interface {
 	GetTime() time.Time
 
 	Since(t time.Time) time.Duration
+
+	// After returns a channel which receives the time once the duration passed
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealTimer is the Timer of the system clock
+type RealTimer struct{}
+
+func (RealTimer) GetTime() time.Time {
+	return time.Now()
+}
+
+func (RealTimer) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (RealTimer) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
 }
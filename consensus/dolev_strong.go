@@ -6,15 +6,30 @@ import (
 	"github.com/spacemeshos/go-spacemesh/log"
 	//"reflect"
 	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	dsCfg "github.com/spacemeshos/go-spacemesh/consensus/config"
 	"github.com/spacemeshos/go-spacemesh/crypto"
+	"sync"
 	"time"
 )
 
-// DolevStrongMultiInstanceConsensus is an implementation of a byzanteen agreement protocol
+const protocolName = "DolevStrong"
+
+// ProtocolName returns the name of the protocol carrying the messages of an agreement session
+func ProtocolName(sessionID []byte) string {
+	return protocolName + "/" + hex.EncodeToString(sessionID)
+}
+
+// DolevStrongMultiInstanceConsensus is an implementation of a byzanteen agreement protocol.
+// Every node may initiate an instance with its value in the same session, the instances of all initiators run
+// concurrently and each one of them agrees on a single value. Rounds start at the configured start time and
+// every round boundary is taken from the timer, so all the nodes of a session must share the start time
 type DolevStrongMultiInstanceConsensus struct {
+	sessionID       []byte
+	participants    map[string]struct{} // the nodes which may sign a relayed message
+	mutex           sync.Mutex          // guards the instances
 	activeInstances map[string]CAInstance
 	ingressChannel  chan OpaqueMessage
 	net             NetworkConnection
@@ -29,42 +44,48 @@ type DolevStrongMultiInstanceConsensus struct {
 
 // DolevStrongInstance is a struct holding state for a single instance of a dolev strong agreement protocol as a receiver
 type DolevStrongInstance struct {
-	value                   []byte
-	ds                      *DolevStrongMultiInstanceConsensus
-	abortProcessingInstance bool
+	values [][]byte // the distinct values extracted, more than one proves the initiator is faulty
+	ds     *DolevStrongMultiInstanceConsensus
 }
 
-// StartInstance starts an agreement protocol instance
+// StartInstance starts an agreement protocol instance, it blocks until the last round ended
 func (impl *DolevStrongMultiInstanceConsensus) StartInstance(msg OpaqueMessage) []byte {
 	log.Info("Starting %v", impl.publicKey.String())
-	impl.startTime = time.Now()
-	go impl.SendMessage(msg)
+	impl.SendMessage(msg)
 	go impl.StartListening()
 	impl.waitForConsensus()
 	return msg.Data()
 }
 
-// NewDSC creates a new instance of dolev strong agreement protocol
+// NewDSC creates a new instance of dolev strong agreement protocol in the session
 func NewDSC(conf dsCfg.Config,
 	timer Timer,
+	sessionID []byte,
 	nodes []string,
 	network NetworkConnection,
 	pubKey crypto.PublicKey,
 	privKey crypto.PrivateKey) (*DolevStrongMultiInstanceConsensus, error) {
 
+	if len(sessionID) == 0 {
+		return nil, errors.New("missing session id")
+	}
+
 	dsc := &DolevStrongMultiInstanceConsensus{
+		sessionID:       sessionID,
+		participants:    make(map[string]struct{}, len(nodes)),
 		activeInstances: make(map[string]CAInstance),
-		ingressChannel:  network.RegisterProtocol("DolevStrong"),
+		ingressChannel:  network.RegisterProtocol(ProtocolName(sessionID)),
 		publicKey:       pubKey,
 		privateKey:      privKey,
 		net:             network,
 		dsConfig:        conf,
 		timer:           timer,
-		startTime:       time.Now(),
+		startTime:       conf.StartTime,
 		abortInstance:   make(chan struct{}, 1),
 		abortListening:  make(chan struct{}, 1),
 	}
 	for _, node := range nodes {
+		dsc.participants[node] = struct{}{}
 		dsc.activeInstances[node] = NewDolevStrongInstance(dsc)
 	}
 
@@ -73,13 +94,25 @@ func NewDSC(conf dsCfg.Config,
 
 func (impl *DolevStrongMultiInstanceConsensus) handleMessage(message OpaqueMessage) error {
 	msg := &pb.ConsensusMessage{}
-	proto.Unmarshal(message.Data(), msg)
+	if err := proto.Unmarshal(message.Data(), msg); err != nil {
+		return fmt.Errorf("cannot unmarshal message: %v", err)
+	}
+	if msg.Msg == nil {
+		return errors.New("message without data")
+	}
+	if !bytes.Equal(msg.Msg.SessionId, impl.sessionID) {
+		return fmt.Errorf("message of another session %x", msg.Msg.SessionId)
+	}
+
 	pk, err := crypto.NewPublicKey(msg.Msg.AuthPubKey)
 	if err != nil {
 		log.Error("cannot parse public key from message: %v, err :%v", msg.Msg.AuthPubKey, err)
 		return err
 	}
 	nodeID := pk.String()
+
+	impl.mutex.Lock()
+	defer impl.mutex.Unlock()
 	var ci CAInstance
 	if val, ok := impl.activeInstances[nodeID]; !ok {
 		ci = NewDolevStrongInstance(impl)
@@ -108,20 +141,24 @@ func (impl *DolevStrongMultiInstanceConsensus) StartListening() {
 	}
 }
 
-func (impl *DolevStrongMultiInstanceConsensus) waitForConsensus() {
-	numOfRounds := impl.dsConfig.NumOfAdversaries + 1
-	ticker := time.NewTicker(time.Duration(time.Duration(numOfRounds) * impl.dsConfig.RoundTime))
+// numOfRounds returns the number of rounds which are required to tolerate the configured number of adversaries
+func (impl *DolevStrongMultiInstanceConsensus) numOfRounds() int32 {
+	return impl.dsConfig.NumOfAdversaries + 1
+}
 
-	for {
+func (impl *DolevStrongMultiInstanceConsensus) waitForConsensus() {
+	for round := int32(1); round <= impl.numOfRounds(); round++ {
+		roundEnd := impl.startTime.Add(time.Duration(round) * impl.dsConfig.RoundTime)
 		select {
-		case <-ticker.C:
-			log.Info("finished all DS rounds")
-			impl.Abort()
+		case <-impl.timer.After(roundEnd.Sub(impl.timer.GetTime())):
+			log.Info("DS round %v ended", round-1)
 		case <-impl.abortInstance:
-			ticker.Stop()
 			return
 		}
 	}
+
+	log.Info("finished all DS rounds")
+	impl.abortListening <- struct{}{}
 }
 
 // SendMessage sends this message in order to reach consensus
@@ -132,6 +169,7 @@ func (impl *DolevStrongMultiInstanceConsensus) SendMessage(msg OpaqueMessage) er
 	dataMsg := pb.MessageData{
 		Data:       msg.Data(),
 		AuthPubKey: impl.publicKey.Bytes(),
+		SessionId:  impl.sessionID,
 	}
 	protocolMsg := pb.ConsensusMessage{
 		Msg:        &dataMsg,
@@ -144,7 +182,9 @@ func (impl *DolevStrongMultiInstanceConsensus) SendMessage(msg OpaqueMessage) er
 	}
 	except := make(map[string]struct{})
 	except[impl.publicKey.String()] = struct{}{}
+	impl.mutex.Lock()
 	impl.sendToRemainingNodes(&protocolMsg, except)
+	impl.mutex.Unlock()
 	return nil
 }
 
@@ -158,57 +198,62 @@ func (dsci *DolevStrongInstance) authenticateSignature(validator crypto.PublicKe
 	return out
 }
 
+// getRound returns the round of the local time, the first round is 0
 func (impl *DolevStrongMultiInstanceConsensus) getRound() int32 {
 	return int32(impl.timer.Since(impl.startTime).Nanoseconds() / impl.dsConfig.RoundTime.Nanoseconds())
 }
 
-//GetOutput Returns the message agreed upon in this instance of dolev string, returns nil if the message agreement failed
+// GetOutput returns the value agreed upon in this instance of dolev strong. The value is the single value which
+// was extracted, nil is the default value which is agreed upon when no value or more than one value was extracted
 func (dsci *DolevStrongInstance) GetOutput() []byte {
-	if dsci.abortProcessingInstance {
+	if len(dsci.values) != 1 {
 		return nil
 	}
-	return dsci.value
+	return dsci.values[0]
 }
 
-// ReceiveMessage is the main receiver handler for a message received from a dolev strong instance running in the network
+// ReceiveMessage is the main receiver handler for a message received from a dolev strong instance running in the network.
+// A value is extracted in round r if the message carries the signatures of r+1 distinct nodes, the first of them
+// is the initiator's. Every value extracted is relayed to the nodes which didn't sign it, up to two values since
+// two values are enough to prove the initiator is faulty
 func (dsci *DolevStrongInstance) ReceiveMessage(message *pb.ConsensusMessage) error {
 	//calculates round according to local clock
 	round := dsci.ds.getRound()
 	log.Info("%v received message in round %v num of signatures: %v", dsci.ds.publicKey.String(), round, len(message.Validators))
 
-	if dsci.abortProcessingInstance {
-		return fmt.Errorf("message received on aborted isntance, round: %v", round)
+	if round < 0 || round >= dsci.ds.numOfRounds() {
+		return fmt.Errorf("round out of order: %v", round)
 	}
 
-	if round > dsci.ds.dsConfig.NumOfAdversaries+1 {
-		return fmt.Errorf("round out of order: %v", round)
+	if len(dsci.values) > 1 { // the initiator was already proven faulty
+		return nil
+	}
+
+	if len(message.Validators) == 0 || !bytes.Equal(message.Validators[0].AuthPubKey, message.Msg.AuthPubKey) {
+		return errors.New("initiator did not sign the message first")
 	}
 
 	publicKeys, err := dsci.findAndValidateSignatures(message)
 	if err != nil {
 		return err
 	}
-	initiatorPubKey, err := crypto.NewPublicKey(message.Msg.AuthPubKey)
-	if _, ok := publicKeys[initiatorPubKey.String()]; !ok || err != nil {
-		return fmt.Errorf("initiator did not sign the message - aborting %v", initiatorPubKey.String())
 
-	}
 	//check if there are enough signature to match round number
-	if len(publicKeys) < int(round) {
+	if len(publicKeys) < int(round)+1 {
 		return fmt.Errorf("invalid number of signatures - not matching round number %v num of signatures %v", round, len(publicKeys))
 	}
-	//todo: what if the signatures are of nodes that are not in the layer?
-	if dsci.value != nil {
-		if res := bytes.Compare(dsci.value, message.Msg.Data); res != 0 {
-			dsci.abortProcessingInstance = true //with abort
-			dsci.sendMessage(message, publicKeys)
-			return fmt.Errorf("received two different messages from same sender, aborting this instance")
+
+	for _, value := range dsci.values {
+		if bytes.Equal(value, message.Msg.Data) { // already extracted and relayed
+			return nil
 		}
-		// no need to do anything
-		return nil
 	}
-	//set the message as the correct one
-	dsci.value = message.Msg.Data
+
+	//extract the value and relay it
+	dsci.values = append(dsci.values, message.Msg.Data)
+	if len(dsci.values) > 1 {
+		log.Warning("received two different messages from same sender, the default value is agreed")
+	}
 	log.Info("Message received on node : %v", dsci.ds.publicKey.String())
 
 	return dsci.sendMessage(message, publicKeys)
@@ -226,6 +271,8 @@ func (dsci *DolevStrongInstance) sendMessage(message *pb.ConsensusMessage, publi
 	return nil
 }
 
+// findAndValidateSignatures returns the distinct signers of the message, the signatures of nodes other than the
+// initiator and the participants of the session are ignored
 func (dsci *DolevStrongInstance) findAndValidateSignatures(message *pb.ConsensusMessage) (map[string]struct{}, error) {
 	publicKeys := make(map[string]struct{})
 	data, err := proto.Marshal(message.Msg)
@@ -243,6 +290,12 @@ func (dsci *DolevStrongInstance) findAndValidateSignatures(message *pb.Consensus
 		if !validSig {
 			return nil, fmt.Errorf("invalid signature of %v", val.AuthorSign)
 		}
+
+		_, participant := dsci.ds.participants[key.String()]
+		if !participant && !bytes.Equal(val.AuthPubKey, message.Msg.AuthPubKey) {
+			log.Warning("ignoring the signature of %v which is not a participant", key.String())
+			continue
+		}
 		publicKeys[key.String()] = struct{}{}
 	}
 	return publicKeys, nil
@@ -276,6 +329,8 @@ func (impl *DolevStrongMultiInstanceConsensus) sendToRemainingNodes(message *pb.
 
 // GetOtherInstancesOutput returns all the outputs agreed in this layer from other instances that ran in the same time as this initiator
 func (impl *DolevStrongMultiInstanceConsensus) GetOtherInstancesOutput() map[string][]byte {
+	impl.mutex.Lock()
+	defer impl.mutex.Unlock()
 	output := make(map[string][]byte)
 	for key, ds := range impl.activeInstances {
 		output[key] = ds.GetOutput()
@@ -306,8 +361,7 @@ func (impl *DolevStrongMultiInstanceConsensus) signMessageAndAppend(message *pb.
 //NewDolevStrongInstance return a new instance of a dolev strong receiver
 func NewDolevStrongInstance(ca *DolevStrongMultiInstanceConsensus) *DolevStrongInstance {
 	ds := &DolevStrongInstance{
-		value: nil,
-		ds:    ca,
+		ds: ca,
 	}
 
 	return ds
@@ -0,0 +1,152 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"github.com/btcsuite/btcd/btcec"
+	"math/big"
+)
+
+// VRF is an elliptic curve verifiable random function over secp256k1 (ECVRF, hash to curve by try and increment).
+// A proof is only producible with the private key, is unique for a key and message, and its output is a
+// uniformly distributed hash that anyone holding the public key can verify.
+
+const (
+	vrfChallengeLen = 16 // bytes of the challenge c
+	vrfScalarLen    = 32 // bytes of the scalar s
+	vrfPointLen     = 33 // bytes of a compressed point
+
+	// VRFProofLen is the length of a VRF proof: gamma || c || s
+	VRFProofLen = vrfPointLen + vrfChallengeLen + vrfScalarLen
+)
+
+var (
+	vrfSuite = []byte{0xfe} // domain separation of the VRF hashes
+
+	errVRFHashToCurve = errors.New("vrf: could not hash message to curve")
+	errVRFProof       = errors.New("vrf: malformed proof")
+)
+
+// VRFProve returns the VRF proof of the message by the private key
+func VRFProve(key PrivateKey, msg []byte) ([]byte, error) {
+	curve := btcec.S256()
+	priv := key.InternalKey()
+	pub := key.GetPublicKey().InternalKey()
+
+	hx, hy, err := vrfHashToCurve(pub, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	gx, gy := curve.ScalarMult(hx, hy, priv.D.Bytes())
+
+	// the nonce is derived from the key and H so that proofs are deterministic
+	k := new(big.Int).SetBytes(Sha256(vrfSuite, priv.D.Bytes(), serializePoint(hx, hy)))
+	k.Mod(k, curve.N)
+	if k.Sign() == 0 {
+		k.SetInt64(1)
+	}
+
+	ux, uy := curve.ScalarBaseMult(k.Bytes())
+	vx, vy := curve.ScalarMult(hx, hy, k.Bytes())
+	c := vrfChallenge(hx, hy, gx, gy, ux, uy, vx, vy)
+
+	s := new(big.Int).Mul(c, priv.D)
+	s.Add(s, k)
+	s.Mod(s, curve.N)
+
+	proof := make([]byte, 0, VRFProofLen)
+	proof = append(proof, serializePoint(gx, gy)...)
+	proof = append(proof, padBytes(c.Bytes(), vrfChallengeLen)...)
+	proof = append(proof, padBytes(s.Bytes(), vrfScalarLen)...)
+	return proof, nil
+}
+
+// VRFVerify checks that proof is the VRF proof of msg by the owner of the public key
+func VRFVerify(key PublicKey, msg []byte, proof []byte) bool {
+	curve := btcec.S256()
+	pub := key.InternalKey()
+
+	gamma, c, s, err := parseVRFProof(proof)
+	if err != nil {
+		return false
+	}
+
+	hx, hy, err := vrfHashToCurve(pub, msg)
+	if err != nil {
+		return false
+	}
+
+	negC := new(big.Int).Sub(curve.N, c).Bytes()
+
+	// U = s*G - c*Y
+	sgx, sgy := curve.ScalarBaseMult(s.Bytes())
+	cyx, cyy := curve.ScalarMult(pub.X, pub.Y, negC)
+	ux, uy := curve.Add(sgx, sgy, cyx, cyy)
+
+	// V = s*H - c*Gamma
+	shx, shy := curve.ScalarMult(hx, hy, s.Bytes())
+	cgx, cgy := curve.ScalarMult(gamma.X, gamma.Y, negC)
+	vx, vy := curve.Add(shx, shy, cgx, cgy)
+
+	return vrfChallenge(hx, hy, gamma.X, gamma.Y, ux, uy, vx, vy).Cmp(c) == 0
+}
+
+// VRFOutput returns the random output of a VRF proof, the proof should be verified before using its output
+func VRFOutput(proof []byte) ([]byte, error) {
+	if len(proof) != VRFProofLen {
+		return nil, errVRFProof
+	}
+	return Sha256(vrfSuite, []byte{0x03}, proof[:vrfPointLen]), nil
+}
+
+func parseVRFProof(proof []byte) (*btcec.PublicKey, *big.Int, *big.Int, error) {
+	if len(proof) != VRFProofLen {
+		return nil, nil, nil, errVRFProof
+	}
+
+	gamma, err := btcec.ParsePubKey(proof[:vrfPointLen], btcec.S256())
+	if err != nil || !bytes.Equal(gamma.SerializeCompressed(), proof[:vrfPointLen]) {
+		return nil, nil, nil, errVRFProof
+	}
+
+	c := new(big.Int).SetBytes(proof[vrfPointLen : vrfPointLen+vrfChallengeLen])
+	s := new(big.Int).SetBytes(proof[vrfPointLen+vrfChallengeLen:])
+	if s.Cmp(btcec.S256().N) >= 0 {
+		return nil, nil, nil, errVRFProof
+	}
+
+	return gamma, c, s, nil
+}
+
+// vrfHashToCurve deterministically maps the public key and message to a curve point
+func vrfHashToCurve(pub *btcec.PublicKey, msg []byte) (*big.Int, *big.Int, error) {
+	for ctr := 0; ctr < 256; ctr++ {
+		h := Sha256(vrfSuite, []byte{0x01}, pub.SerializeCompressed(), msg, []byte{byte(ctr)})
+		p, err := btcec.ParsePubKey(append([]byte{0x02}, h...), btcec.S256())
+		if err == nil {
+			return p.X, p.Y, nil
+		}
+	}
+	return nil, nil, errVRFHashToCurve
+}
+
+func vrfChallenge(points ...*big.Int) *big.Int {
+	data := make([][]byte, 0, 1+len(points)/2)
+	data = append(data, vrfSuite, []byte{0x02})
+	for i := 0; i+1 < len(points); i += 2 {
+		data = append(data, serializePoint(points[i], points[i+1]))
+	}
+	return new(big.Int).SetBytes(Sha256(data...)[:vrfChallengeLen])
+}
+
+func serializePoint(x, y *big.Int) []byte {
+	return (&btcec.PublicKey{Curve: btcec.S256(), X: x, Y: y}).SerializeCompressed()
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
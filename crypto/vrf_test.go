@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVRF_ProveVerify(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	assert.NoError(t, err)
+
+	msg := []byte("layer seed")
+	proof, err := VRFProve(priv, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, VRFProofLen, len(proof))
+	assert.True(t, VRFVerify(pub, msg, proof))
+
+	// proofs are deterministic
+	proof2, err := VRFProve(priv, msg)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(proof, proof2))
+
+	out, err := VRFOutput(proof)
+	assert.NoError(t, err)
+	assert.Equal(t, 32, len(out))
+}
+
+func TestVRF_VerifyFails(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	assert.NoError(t, err)
+	_, otherPub, err := GenerateKeyPair()
+	assert.NoError(t, err)
+
+	msg := []byte("layer seed")
+	proof, err := VRFProve(priv, msg)
+	assert.NoError(t, err)
+
+	assert.False(t, VRFVerify(otherPub, msg, proof), "verified with another key")
+	assert.False(t, VRFVerify(pub, []byte("other seed"), proof), "verified another message")
+	assert.False(t, VRFVerify(pub, msg, proof[:VRFProofLen-1]), "verified a truncated proof")
+
+	for _, i := range []int{0, 5, vrfPointLen, vrfPointLen + vrfChallengeLen + 3} {
+		tampered := make([]byte, len(proof))
+		copy(tampered, proof)
+		tampered[i] ^= 0x01
+		assert.False(t, VRFVerify(pub, msg, tampered), "verified a tampered proof")
+	}
+}
+
+func TestVRF_OutputDiffers(t *testing.T) {
+	priv, _, err := GenerateKeyPair()
+	assert.NoError(t, err)
+
+	p1, err := VRFProve(priv, []byte{1})
+	assert.NoError(t, err)
+	p2, err := VRFProve(priv, []byte{2})
+	assert.NoError(t, err)
+
+	o1, _ := VRFOutput(p1)
+	o2, _ := VRFOutput(p2)
+	assert.False(t, bytes.Equal(o1, o2))
+}
@@ -38,3 +38,13 @@ func NewLevelDbStore(name string, wo *opt.WriteOptions, ro *opt.ReadOptions) DB
 	}
 	return LevelDB{blocks, wo, ro}
 }
+
+// NewReadOnlyLevelDbStore opens an existing database at path for reading only, writes to it return an error
+func NewReadOnlyLevelDbStore(path string) (DB, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		log.Error("could not open "+path+" database ", err)
+		return nil, err
+	}
+	return LevelDB{db, nil, nil}, nil
+}
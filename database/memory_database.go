@@ -17,8 +17,12 @@
 package database
 
 import (
+	"bytes"
 	"errors"
 	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/memdb"
 	"sync"
 
 )
@@ -80,6 +84,21 @@ func (db *MemDatabase) Keys() [][]byte {
 	return keys
 }
 
+// NewIteratorWithPrefix returns an iterator over a snapshot of the database content with a particular prefix,
+// ordered by key like the iterator of LDBDatabase
+func (db *MemDatabase) NewIteratorWithPrefix(prefix []byte) iterator.Iterator {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	snapshot := memdb.New(comparer.DefaultComparer, 0)
+	for key, value := range db.db {
+		if bytes.HasPrefix([]byte(key), prefix) {
+			snapshot.Put([]byte(key), common.CopyBytes(value))
+		}
+	}
+	return snapshot.NewIterator(nil)
+}
+
 func (db *MemDatabase) Delete(key []byte) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
@@ -107,6 +107,12 @@ func GetAccountsDataDirectoryPath() (string, error) {
 	return ensureDataSubDirectory(config.AccountsDirectoryName)
 }
 
+// GetStateDataDirectoryPath returns the path to the global state data directory.
+// It will create the directory if it doesn't already exist.
+func GetStateDataDirectoryPath() (string, error) {
+	return ensureDataSubDirectory(config.StateDirectoryName)
+}
+
 // GetLogsDataDirectoryPath returns the path to the app logs data directory.
 // It will create the directory if it doesn't already exist.
 func GetLogsDataDirectoryPath() (string, error) {
@@ -1,9 +1,9 @@
 package hare
 
 import (
-	"encoding/binary"
 	"errors"
 	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/common"
 	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/hare/config"
 	"github.com/spacemeshos/go-spacemesh/hare/pb"
@@ -23,6 +23,37 @@ type NetworkService interface {
 	Broadcast(protocol string, payload []byte) error
 }
 
+// TerminationOutput is reported by a consensus process when it terminates with an agreed set
+type TerminationOutput interface {
+	Id() common.Hash
+	Set() *Set
+	Certificate() *pb.Certificate // the certificate of the set, nil if none was received
+	Round() uint32                // the round counter on termination
+}
+
+type procOutput struct {
+	id   common.Hash
+	set  *Set
+	cert *pb.Certificate
+	k    uint32
+}
+
+func (cpo procOutput) Id() common.Hash {
+	return cpo.id
+}
+
+func (cpo procOutput) Set() *Set {
+	return cpo.set
+}
+
+func (cpo procOutput) Certificate() *pb.Certificate {
+	return cpo.cert
+}
+
+func (cpo procOutput) Round() uint32 {
+	return cpo.k
+}
+
 type State struct {
 	k           uint32          // the round counter (k%4 is the round number)
 	ki          int32           // indicates when S was first committed upon
@@ -32,26 +63,30 @@ type State struct {
 
 type ConsensusProcess struct {
 	State
-	Closer // the consensus is closeable
-	pubKey          crypto.PublicKey
-	instanceId      InstanceId
-	oracle          Rolacle // roles oracle
-	signing         Signing
-	network         NetworkService
-	startTime       time.Time // TODO: needed?
-	inbox           chan *pb.HareMessage
-	role            Role // the current role
-	validator       *MessageValidator
-	preRoundTracker *PreRoundTracker
-	statusesTracker *StatusTracker
-	proposalTracker *ProposalTracker
-	commitTracker   *CommitTracker
-	notifyTracker   *NotifyTracker
-	terminating     bool
-	cfg             config.Config
-}
-
-func NewConsensusProcess(cfg config.Config, key crypto.PublicKey, instanceId InstanceId, s Set, oracle Rolacle, signing Signing, p2p NetworkService) *ConsensusProcess {
+	Closer            // the consensus is closeable
+	pubKey            crypto.PublicKey
+	instanceId        InstanceId
+	oracle            Rolacle // roles oracle
+	signing           Signing
+	network           NetworkService
+	clock             Clock
+	startTime         time.Time // TODO: needed?
+	inbox             chan *pb.HareMessage
+	role              Role // the current role
+	validator         *MessageValidator
+	preRoundTracker   *PreRoundTracker
+	statusesTracker   *StatusTracker
+	proposalTracker   *ProposalTracker
+	commitTracker     *CommitTracker
+	notifyTracker     *NotifyTracker
+	equivocations     *EquivocationTracker
+	terminating       bool
+	pending           []*pb.HareMessage // messages of the next round, handled when it begins
+	cfg               config.Config
+	terminationReport chan TerminationOutput // the output is reported on this channel upon termination
+}
+
+func NewConsensusProcess(cfg config.Config, key crypto.PublicKey, instanceId InstanceId, s Set, oracle Rolacle, signing Signing, p2p NetworkService, clock Clock, terminationReport chan TerminationOutput) *ConsensusProcess {
 	proc := &ConsensusProcess{}
 	proc.State = State{0, -1, &s, nil}
 	proc.Closer = NewCloser()
@@ -60,20 +95,23 @@ func NewConsensusProcess(cfg config.Config, key crypto.PublicKey, instanceId Ins
 	proc.oracle = oracle
 	proc.signing = signing
 	proc.network = p2p
+	proc.clock = clock
 	proc.role = Passive
-	proc.validator = NewMessageValidator(signing, cfg.F+1, cfg.N)
+	proc.validator = NewMessageValidator(signing, oracle, cfg.F+1, cfg.N)
 	proc.preRoundTracker = NewPreRoundTracker(cfg.F+1, cfg.N)
 	proc.statusesTracker = NewStatusTracker(cfg.F+1, cfg.N)
 	proc.proposalTracker = NewProposalTracker(cfg.N)
 	proc.commitTracker = NewCommitTracker(cfg.F+1, cfg.N, nil)
 	proc.notifyTracker = NewNotifyTracker(cfg.N)
+	proc.equivocations = NewEquivocationTracker(cfg.N, cfg.F)
 	proc.terminating = false
 	proc.cfg = cfg
+	proc.terminationReport = terminationReport
 
 	return proc
 }
 
-func (proc *ConsensusProcess) Id() uint32 {
+func (proc *ConsensusProcess) Id() common.Hash {
 	return proc.instanceId.Id()
 }
 
@@ -88,7 +126,7 @@ func (proc *ConsensusProcess) Start() error {
 		return StartInstanceError(errors.New("instance already started"))
 	}
 
-	proc.startTime = time.Now()
+	proc.startTime = proc.clock.Now()
 
 	go proc.eventLoop()
 
@@ -103,6 +141,10 @@ func (proc *ConsensusProcess) createInbox(size uint32) chan *pb.HareMessage {
 func (proc *ConsensusProcess) eventLoop() {
 	log.Info("Start listening")
 
+	// every tick ends a round, the first one ends the pre-round
+	ticker := proc.clock.NewTicker(proc.cfg.RoundDuration)
+	defer ticker.Stop()
+
 	// update role
 	proc.updateRole()
 
@@ -110,13 +152,16 @@ func (proc *ConsensusProcess) eventLoop() {
 	proc.sendMessage(proc.initDefaultBuilder(proc.s).SetType(PreRound).Sign(proc.signing).Build())
 
 	// listen to pre-round messages
-	timer := time.NewTimer(proc.cfg.RoundDuration)
 PreRound:
 	for {
 		select {
 		case msg := <-proc.inbox:
+			if msg.Message != nil && MessageType(msg.Message.Type) != PreRound { // round 1 shares the round counter
+				proc.deferMessage(msg)
+				continue
+			}
 			proc.handleMessage(msg)
-		case <-timer.C:
+		case <-ticker.Chan():
 			break PreRound
 		case <-proc.CloseChannel():
 			return
@@ -126,12 +171,11 @@ PreRound:
 
 	// start first iteration
 	proc.onRoundBegin()
-	ticker := time.NewTicker(proc.cfg.RoundDuration)
 	for {
 		select {
 		case msg := <-proc.inbox: // msg event
 			proc.handleMessage(msg)
-		case <-ticker.C: // next round event
+		case <-ticker.Chan(): // next round event
 			proc.onRoundEnd()
 			proc.advanceToNextRound()
 			proc.onRoundBegin()
@@ -150,9 +194,7 @@ func roleFromRoundCounter(k uint32) Role {
 	switch k % 4 {
 	case Round2:
 		return Leader
-	case Round4:
-		return Passive
-	default:
+	default: // notifications are sent by active parties as well
 		return Active
 	}
 }
@@ -160,14 +202,43 @@ func roleFromRoundCounter(k uint32) Role {
 func (proc *ConsensusProcess) handleMessage(m *pb.HareMessage) {
 	// Note: instanceId is already verified by the broker
 
-	// validate message
-	if !proc.validator.ValidateMessage(m, proc.k) {
+	// validate message syntax and signature
+	if !proc.validator.ValidateSignedMessage(m) {
 		log.Info("Message is not syntactically valid")
 		return
 	}
 
+	// parties whose clock is slightly ahead may send the messages of the next round before it begins here
+	if m.Message.K > proc.k {
+		if m.Message.K == proc.k+1 {
+			proc.deferMessage(m)
+		}
+		return
+	}
+
+	pub, err := crypto.NewPublicKey(m.PubKey)
+	if err != nil {
+		log.Warning("Could not construct public key: ", err.Error())
+		return
+	}
+
+	// any signed message of the round may reveal an equivocation, even if it is late
+	if proof := proc.equivocations.OnMessage(m); proof != nil {
+		proc.onEquivocation(pub.String(), proof)
+		return
+	}
+	if proc.equivocations.IsEquivocator(pub.String()) { // ignore equivocators
+		return
+	}
+
+	// validate context
+	if !isContextuallyValid(m, proc.k) {
+		log.Info("Message is not contextually valid")
+		return
+	}
+
 	// validate role
-	if proc.oracle.Role(Signature(m.Message.RoleProof)) == roleFromRoundCounter(m.Message.K) {
+	if proc.oracle.Role(pub, proc.instanceId, m.Message.K, Signature(m.Message.RoleProof)) != roleFromRoundCounter(m.Message.K) {
 		log.Warning("invalid role detected for: ", m.String())
 		return
 	}
@@ -189,6 +260,50 @@ func (proc *ConsensusProcess) handleMessage(m *pb.HareMessage) {
 	}
 }
 
+// deferMessage keeps the message until the next round begins
+func (proc *ConsensusProcess) deferMessage(m *pb.HareMessage) {
+	if len(proc.pending) >= InboxCapacity {
+		log.Warning("Too many messages of the next round, message dropped")
+		return
+	}
+
+	proc.pending = append(proc.pending, m)
+}
+
+// handlePending handles the messages deferred to the current round
+func (proc *ConsensusProcess) handlePending() {
+	pending := proc.pending
+	proc.pending = nil
+	for _, m := range pending {
+		proc.handleMessage(m)
+	}
+}
+
+// onEquivocation excludes the equivocator from all thresholds and publishes the proof
+func (proc *ConsensusProcess) onEquivocation(pub string, proof *pb.EquivocationProof) {
+	proc.preRoundTracker.Exclude(pub)
+	if proc.statusesTracker != nil {
+		proc.statusesTracker.Exclude(pub)
+	}
+	if proc.proposalTracker != nil {
+		proc.proposalTracker.Exclude(pub)
+	}
+	if proc.commitTracker != nil {
+		proc.commitTracker.Exclude(pub)
+	}
+	proc.notifyTracker.Exclude(pub)
+
+	data, err := proto.Marshal(proof)
+	if err != nil {
+		log.Error("failed marshaling equivocation proof")
+		return
+	}
+
+	if err := proc.network.Broadcast(EquivocationProtoName, data); err != nil {
+		log.Error("Could not broadcast equivocation proof %v", err.Error())
+	}
+}
+
 func (proc *ConsensusProcess) sendMessage(msg *pb.HareMessage) {
 	// invalid
 	if msg == nil {
@@ -236,6 +351,8 @@ func (proc *ConsensusProcess) beginRound1() {
 }
 
 func (proc *ConsensusProcess) beginRound2() {
+	proc.proposalTracker = NewProposalTracker(proc.cfg.N)
+
 	if proc.role == Leader && proc.statusesTracker.IsSVPReady() {
 		builder := proc.initDefaultBuilder(proc.statusesTracker.ProposalSet(proc.cfg.SetSize))
 		svp := proc.statusesTracker.BuildSVP()
@@ -285,13 +402,18 @@ func (proc *ConsensusProcess) onRoundBegin() {
 		log.Error("Current round out of bounds. Expected: 0-4, Found: ", proc.currentRound())
 		panic("Current round out of bounds")
 	}
+
+	proc.handlePending()
 }
 
 func (proc *ConsensusProcess) roleProof() Signature {
-	kInBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(kInBytes, uint32(proc.k))
+	proof, err := proc.oracle.Proof(proc.instanceId, proc.k)
+	if err != nil {
+		log.Error("Could not create role proof: %v", err)
+		return nil
+	}
 
-	return proc.signing.Sign(kInBytes)
+	return proof
 }
 
 func (proc *ConsensusProcess) initDefaultBuilder(s *Set) *MessageBuilder {
@@ -357,12 +479,31 @@ func (proc *ConsensusProcess) processNotifyMsg(msg *pb.HareMessage) {
 		return
 	}
 
+	// the output is the notified set, which may not be our set if we didn't reach round 4
+	if !proc.s.Equals(s) || proc.certificate == nil {
+		proc.s = s
+		proc.certificate = msg.Cert
+	}
+
 	// enough notifications, should terminate
-	log.Info("Consensus process terminated for %v with output set: ", proc.pubKey, proc.s)
+	log.Info("Consensus process terminated for %v with output set: %v", proc.pubKey, proc.s)
+	proc.report()
 	proc.terminating = true // ensures immediate termination
 	proc.Close()
 }
 
+// report the output set to the termination channel, gives up if the process is closed meanwhile
+func (proc *ConsensusProcess) report() {
+	if proc.terminationReport == nil {
+		return
+	}
+
+	select {
+	case proc.terminationReport <- procOutput{proc.Id(), proc.s, proc.certificate, proc.k}:
+	case <-proc.CloseChannel():
+	}
+}
+
 func (proc *ConsensusProcess) currentRound() int {
 	return int(proc.k % 4)
 }
@@ -393,5 +534,5 @@ func (proc *ConsensusProcess) endOfRound3() {
 }
 
 func (proc *ConsensusProcess) updateRole() {
-	proc.role = proc.oracle.Role(proc.roleProof())
+	proc.role = proc.oracle.Role(proc.pubKey, proc.instanceId, proc.k, proc.roleProof())
 }
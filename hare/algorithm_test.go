@@ -1,11 +1,14 @@
 package hare
 
 import (
+	"github.com/gogo/protobuf/proto"
 	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/hare/config"
+	"github.com/spacemeshos/go-spacemesh/hare/pb"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 var cfg = config.DefaultConfig()
@@ -26,12 +29,12 @@ func TestConsensusProcess_StartTwice(t *testing.T) {
 	sim := service.NewSimulator()
 	n1 := sim.NewNode()
 
-	broker := NewBroker(n1)
+	broker := NewBroker(n1, defaultValidator())
 	s := NewEmptySet(cfg.SetSize)
 	oracle := NewMockOracle()
 	signing := NewMockSigning()
 
-	proc := NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *s, oracle, signing, n1)
+	proc := NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *s, oracle, signing, n1, RealClock{}, make(chan TerminationOutput, 1))
 	broker.Register(proc)
 	err := proc.Start()
 	assert.Equal(t, nil, err)
@@ -44,12 +47,12 @@ func TestConsensusProcess_eventLoop(t *testing.T) {
 	n1 := sim.NewNode()
 	n2 := sim.NewNode()
 
-	broker := NewBroker(n1)
+	broker := NewBroker(n1, defaultValidator())
 	s := NewEmptySet(cfg.SetSize)
 	oracle := NewMockOracle()
 	signing := NewMockSigning()
 
-	proc := NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *s, oracle, signing, n1)
+	proc := NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *s, oracle, signing, n1, RealClock{}, make(chan TerminationOutput, 1))
 	broker.Register(proc)
 	go proc.eventLoop()
 	n2.Broadcast(ProtoName, []byte{})
@@ -62,12 +65,12 @@ func TestConsensusProcess_handleMessage(t *testing.T) {
 	sim := service.NewSimulator()
 	n1 := sim.NewNode()
 
-	broker := NewBroker(n1)
+	broker := NewBroker(n1, defaultValidator())
 	s := NewEmptySet(cfg.SetSize)
 	oracle := NewMockOracle()
 	signing := NewMockSigning()
 
-	proc := NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *s, oracle, signing, n1)
+	proc := NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *s, oracle, signing, n1, RealClock{}, make(chan TerminationOutput, 1))
 	broker.Register(proc)
 
 	m := NewMessageBuilder().SetRoundCounter(0).SetInstanceId(*instanceId1).SetPubKey(generatePubKey(t)).Sign(proc.signing).Build()
@@ -79,12 +82,12 @@ func TestConsensusProcess_nextRound(t *testing.T) {
 	sim := service.NewSimulator()
 	n1 := sim.NewNode()
 
-	broker := NewBroker(n1)
+	broker := NewBroker(n1, defaultValidator())
 	s := NewEmptySet(cfg.SetSize)
 	oracle := NewMockOracle()
 	signing := NewMockSigning()
 
-	proc := NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *s, oracle, signing, n1)
+	proc := NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *s, oracle, signing, n1, RealClock{}, make(chan TerminationOutput, 1))
 	broker.Register(proc)
 
 	proc.advanceToNextRound()
@@ -93,6 +96,48 @@ func TestConsensusProcess_nextRound(t *testing.T) {
 	assert.Equal(t, uint32(2), proc.k)
 }
 
+// sentMessagesNetwork passes the hare messages broadcast by the process to the test
+type sentMessagesNetwork struct {
+	NetworkService
+	sent chan *pb.HareMessage
+}
+
+func (n *sentMessagesNetwork) Broadcast(protocol string, payload []byte) error {
+	m := &pb.HareMessage{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		return err
+	}
+	n.sent <- m
+
+	return nil
+}
+
+func TestConsensusProcess_ManualClock(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	network := &sentMessagesNetwork{service.NewSimulator().NewNode(), make(chan *pb.HareMessage, 10)}
+	proc := NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *NewEmptySet(cfg.SetSize), roundRoleOracle{}, NewMockSigning(), network, clock, make(chan TerminationOutput, 1))
+	proc.createInbox(InboxCapacity)
+	assert.NoError(t, proc.Start())
+	defer proc.Close()
+
+	m := <-network.sent
+	assert.Equal(t, PreRound, MessageType(m.Message.Type))
+
+	// the pre-round ends only when the round duration passed
+	clock.Advance(cfg.RoundDuration - time.Nanosecond)
+	assert.Equal(t, 0, len(network.sent))
+	clock.Advance(time.Nanosecond)
+	m = <-network.sent
+	assert.Equal(t, Status, MessageType(m.Message.Type))
+	assert.Equal(t, uint32(0), m.Message.K)
+
+	// a whole iteration in a single step, no proposal is made without enough statuses
+	clock.Advance(4 * cfg.RoundDuration)
+	m = <-network.sent
+	assert.Equal(t, Status, MessageType(m.Message.Type))
+	assert.Equal(t, uint32(4), m.Message.K)
+}
+
 func generateConsensusProcess(t *testing.T) *ConsensusProcess {
 	sim := service.NewSimulator()
 	n1 := sim.NewNode()
@@ -101,7 +146,7 @@ func generateConsensusProcess(t *testing.T) *ConsensusProcess {
 	oracle := NewMockOracle()
 	signing := NewMockSigning()
 
-	return NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *s, oracle, signing, n1)
+	return NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *s, oracle, signing, n1, RealClock{}, make(chan TerminationOutput, 1))
 }
 
 func TestConsensusProcess_Id(t *testing.T) {
@@ -142,4 +187,138 @@ func TestConsensusProcess_buildNotify(t *testing.T) {
 	proc := generateConsensusProcess(t)
 	m := proc.buildNotifyMessage()
 	assert.Equal(t, Notify, MessageType(m.Message.Type))
-}
\ No newline at end of file
+}
+
+// fixedRoleOracle gives every party the same role in every round
+type fixedRoleOracle struct {
+	role Role
+}
+
+func (o fixedRoleOracle) Proof(instanceId InstanceId, k uint32) (Signature, error) {
+	return Signature{1}, nil
+}
+
+func (o fixedRoleOracle) Role(pub crypto.PublicKey, instanceId InstanceId, k uint32, proof Signature) Role {
+	return o.role
+}
+
+func TestRoleFromRoundCounter(t *testing.T) {
+	// only proposals require a leader, the parties sending notifications are active
+	expected := map[uint32]Role{Round1: Active, Round2: Leader, Round3: Active, Round4: Active}
+	for k := uint32(0); k < 8; k++ {
+		assert.Equal(t, expected[k%4], roleFromRoundCounter(k), "round counter %v", k)
+	}
+}
+
+func TestConsensusProcess_handleMessageRole(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	s := NewEmptySet(cfg.SetSize)
+	s.Add(value1)
+
+	for role, handled := range map[Role]bool{Active: true, Leader: false, Passive: false} {
+		signing, _ := generateKeySigning(t)
+		proc := NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *s, fixedRoleOracle{role}, signing, n1, RealClock{}, make(chan TerminationOutput, 1))
+		senderSigning, senderPub := generateKeySigning(t)
+		proc.handleMessage(buildSignedMsg(senderSigning, senderPub, PreRound, 0, -1, s))
+		_, exist := proc.preRoundTracker.preRound[senderPub.String()]
+		assert.Equal(t, handled, exist, "pre-round message of a party with role %v", role)
+	}
+}
+
+func TestConsensusProcess_handleLatePreRound(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	s := NewEmptySet(cfg.SetSize)
+	s.Add(value1)
+
+	signing, _ := generateKeySigning(t)
+	proc := NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *s, fixedRoleOracle{Active}, signing, n1, RealClock{}, make(chan TerminationOutput, 1))
+	for i := 0; i < 5; i++ {
+		proc.advanceToNextRound()
+	}
+
+	// a pre-round message is contextually valid in every round, it still proves values for the following iterations
+	senderSigning, senderPub := generateKeySigning(t)
+	proc.handleMessage(buildSignedMsg(senderSigning, senderPub, PreRound, 0, -1, s))
+	_, exist := proc.preRoundTracker.preRound[senderPub.String()]
+	assert.True(t, exist, "late pre-round message was dropped")
+}
+
+func TestConsensusProcess_DeferNextRound(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	signing, _ := generateKeySigning(t)
+	proc := NewConsensusProcess(cfg, generatePubKey(t), *instanceId1, *NewEmptySet(cfg.SetSize), roundRoleOracle{}, signing, n1, RealClock{}, make(chan TerminationOutput, 1))
+	for i := 0; i < 3; i++ {
+		proc.advanceToNextRound()
+	}
+
+	// statuses of the next iteration arrive while the notify round is still running here
+	s := NewEmptySet(cfg.SetSize)
+	senderSigning, senderPub := generateKeySigning(t)
+	proc.handleMessage(buildSignedMsg(senderSigning, senderPub, Status, 4, -1, s))
+	lateSigning, latePub := generateKeySigning(t)
+	proc.handleMessage(buildSignedMsg(lateSigning, latePub, Status, 8, -1, s)) // too far ahead, dropped
+	assert.Equal(t, 1, len(proc.pending))
+
+	proc.advanceToNextRound()
+	proc.onRoundBegin()
+	assert.Empty(t, proc.pending)
+	_, exist := proc.statusesTracker.statuses[senderPub.String()]
+	assert.True(t, exist, "deferred status was not handled when its round began")
+	_, exist = proc.statusesTracker.statuses[latePub.String()]
+	assert.False(t, exist)
+}
+
+func TestConsensusProcess_DeferMessageCapacity(t *testing.T) {
+	proc := generateConsensusProcess(t)
+	m := BuildStatusMsg(generatePubKey(t), NewEmptySet(cfg.SetSize))
+	for i := 0; i < InboxCapacity+10; i++ {
+		proc.deferMessage(m)
+	}
+	assert.Equal(t, InboxCapacity, len(proc.pending))
+}
+
+func TestConsensusProcess_beginRound2ResetsProposal(t *testing.T) {
+	proc := generateConsensusProcess(t)
+	s := NewEmptySet(cfg.SetSize)
+	s.Add(value1)
+	proc.proposalTracker.OnProposal(BuildProposalMsg(generatePubKey(t), s))
+	assert.NotNil(t, proc.proposalTracker.ProposedSet())
+
+	// the proposal of the previous iteration isn't committed to in the next one
+	proc.k = 5
+	proc.beginRound2()
+	assert.Nil(t, proc.proposalTracker.ProposedSet())
+}
+
+func TestConsensusProcess_processNotifyMsgAdoptsSet(t *testing.T) {
+	conf := cfg
+	conf.F = 2
+	own := NewEmptySet(conf.SetSize)
+	own.Add(value1)
+	output := make(chan TerminationOutput, 1)
+	proc := NewConsensusProcess(conf, generatePubKey(t), *instanceId1, *own, roundRoleOracle{}, NewMockSigning(), service.NewSimulator().NewNode(), RealClock{}, output)
+
+	// the process didn't reach round 4, the set notified by f+1 parties is the output
+	notified := NewEmptySet(conf.SetSize)
+	notified.Add(value1)
+	notified.Add(value2)
+	cert := buildCertificate(t, *instanceId1, notified, conf.F+1)
+	for i := 0; i < conf.F+1; i++ {
+		signing, pub := generateKeySigning(t)
+		m := NewMessageBuilder().SetType(Notify).SetInstanceId(*instanceId1).SetRoundCounter(Round4).SetKi(Round3).
+			SetValues(notified).SetCertificate(cert).SetPubKey(pub).Sign(signing).Build()
+		proc.processNotifyMsg(m)
+	}
+
+	assert.True(t, proc.terminating)
+	select {
+	case out := <-output:
+		assert.True(t, notified.Equals(out.Set()))
+		assert.Equal(t, cert, out.(procOutput).cert)
+	default:
+		t.Fatal("output was not reported")
+	}
+}
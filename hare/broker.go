@@ -3,18 +3,26 @@ package hare
 import (
 	"errors"
 	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/common"
 	"github.com/spacemeshos/go-spacemesh/hare/pb"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
 	"sync"
+	"time"
 )
 
 const InboxCapacity = 100
 
+const (
+	MaxBufferedMessages = 1000             // max number of messages buffered for all upcoming instances
+	BufferedMessageTTL  = 30 * time.Second // buffered messages older than this are discarded
+	MaxLayersAhead      = 2                // messages of instances further ahead of the latest layer are rejected
+)
+
 type StartInstanceError error
 
 type Identifiable interface {
-	Id() uint32
+	Id() common.Hash
 }
 
 type Inboxer interface {
@@ -29,15 +37,16 @@ type IdentifiableInboxer interface {
 // Closer is used to add closeability to an object
 type Closer struct {
 	channel chan struct{} // closeable go routines listen to this channel
+	once    *sync.Once    // the channel is closed only once
 }
 
 func NewCloser() Closer {
-	return Closer{make(chan struct{})}
+	return Closer{make(chan struct{}), &sync.Once{}}
 }
 
-// Closes all listening instances (should be called only once)
+// Closes all listening instances (safe to call more than once)
 func (closer *Closer) Close() {
-	close(closer.channel)
+	closer.once.Do(func() { close(closer.channel) })
 }
 
 // CloseChannel returns the channel to wait on
@@ -45,20 +54,46 @@ func (closer *Closer) CloseChannel() chan struct{} {
 	return closer.channel
 }
 
+// a message received before its instance was registered
+type bufferedMessage struct {
+	msg      *pb.HareMessage
+	received time.Time
+}
+
+// ValidatorProvider returns the validator of the messages of an instance
+type ValidatorProvider func(instanceId InstanceId) (*MessageValidator, error)
+
 // Broker is responsible for dispatching hare messages to the matching set id listener
 type Broker struct {
 	Closer
-	network NetworkService
-	inbox   chan service.Message
-	outbox  map[uint32]chan *pb.HareMessage
-	mutex   sync.RWMutex
+	network     NetworkService
+	validators  ValidatorProvider
+	inbox       chan service.Message
+	outbox      map[common.Hash]chan *pb.HareMessage
+	pending     map[common.Hash][]bufferedMessage // messages of upcoming instances by instance id
+	pendingSize int                               // the total number of buffered messages
+	latestLayer uint32                            // the layer of the latest started instance
+	started     bool                              // true once an instance was started and latestLayer was set
+	ttl         time.Duration
+	mutex       sync.RWMutex
 }
 
-func NewBroker(networkService NetworkService) *Broker {
+func NewBroker(networkService NetworkService, validator *MessageValidator) *Broker {
+	return NewInstanceBroker(networkService, func(instanceId InstanceId) (*MessageValidator, error) {
+		return validator, nil
+	})
+}
+
+// NewInstanceBroker creates a broker which validates the early messages of an instance with the validator
+// returned for it, so that the thresholds of the instance's committee are applied
+func NewInstanceBroker(networkService NetworkService, validators ValidatorProvider) *Broker {
 	p := new(Broker)
 	p.Closer = NewCloser()
 	p.network = networkService
-	p.outbox = make(map[uint32]chan *pb.HareMessage)
+	p.validators = validators
+	p.outbox = make(map[common.Hash]chan *pb.HareMessage)
+	p.pending = make(map[common.Hash][]bufferedMessage)
+	p.ttl = BufferedMessageTTL
 
 	return p
 }
@@ -79,6 +114,9 @@ func (broker *Broker) Start() error {
 
 // Dispatch incoming messages to the matching set id instance
 func (broker *Broker) dispatcher() {
+	ticker := time.NewTicker(broker.ttl)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case msg := <-broker.inbox:
@@ -89,28 +127,124 @@ func (broker *Broker) dispatcher() {
 				continue
 			}
 
-			instanceId := NewBytes32(hareMsg.Message.InstanceId)
+			if hareMsg.Message == nil {
+				log.Warning("Message without inner message was dropped")
+				continue
+			}
+
+			instanceId := InstanceId{NewBytes32(hareMsg.Message.InstanceId)}
 
 			broker.mutex.RLock()
 			c, exist := broker.outbox[instanceId.Id()]
 			broker.mutex.RUnlock()
 			if exist {
 				c <- hareMsg
+				continue
 			}
 
+			broker.bufferEarlyMessage(instanceId, hareMsg)
+
+		case <-ticker.C:
+			broker.removeExpired()
+
 		case <-broker.CloseChannel():
 			return
 		}
 	}
 }
 
-// Register a listener to messages
+// Register a listener to messages, messages buffered for it are delivered to its inbox
 func (broker *Broker) Register(idBox IdentifiableInboxer) {
 	broker.mutex.Lock()
-	broker.outbox[idBox.Id()] = idBox.createInbox(InboxCapacity)
+	defer broker.mutex.Unlock()
+
+	inbox := idBox.createInbox(InboxCapacity)
+	broker.outbox[idBox.Id()] = inbox
+
+	// flush buffered messages, the inbox is empty so this doesn't block
+	for _, bm := range broker.pending[idBox.Id()] {
+		if time.Since(bm.received) > broker.ttl {
+			continue
+		}
+		inbox <- bm.msg
+	}
+	broker.pendingSize -= len(broker.pending[idBox.Id()])
+	delete(broker.pending, idBox.Id())
+}
+
+// SetLatestLayer updates the layer of the latest started instance, messages are buffered only for the layers following it
+func (broker *Broker) SetLatestLayer(layer uint32) {
+	broker.mutex.Lock()
+	if !broker.started || layer > broker.latestLayer {
+		broker.latestLayer = layer
+		broker.started = true
+	}
 	broker.mutex.Unlock()
 }
 
+// bufferEarlyMessage keeps a valid message of an upcoming instance until the instance is registered. Before the first
+// instance is started the current layer is unknown, the messages of any layer are buffered
+func (broker *Broker) bufferEarlyMessage(instanceId InstanceId, msg *pb.HareMessage) {
+	broker.mutex.RLock()
+	latest := broker.latestLayer
+	started := broker.started
+	broker.mutex.RUnlock()
+
+	layer := instanceId.Layer()
+	if started && (layer <= latest || layer > latest+MaxLayersAhead) {
+		log.Debug("Message of instance of layer %v was dropped, latest layer is %v", layer, latest)
+		return
+	}
+
+	// validate before buffering so that invalid messages can't fill the buffer
+	validator, err := broker.validators(instanceId)
+	if err != nil {
+		log.Warning("Early message of layer %v was dropped, could not validate it: %v", layer, err)
+		return
+	}
+	if !validator.ValidateSignedMessage(msg) {
+		log.Warning("Invalid early message was dropped")
+		return
+	}
+
+	broker.mutex.Lock()
+	if c, exist := broker.outbox[instanceId.Id()]; exist { // registered meanwhile
+		broker.mutex.Unlock()
+		c <- msg
+		return
+	}
+	defer broker.mutex.Unlock()
+
+	if broker.pendingSize >= MaxBufferedMessages || len(broker.pending[instanceId.Id()]) >= InboxCapacity {
+		log.Warning("Buffer of early messages is full, message of layer %v was dropped", layer)
+		return
+	}
+
+	broker.pending[instanceId.Id()] = append(broker.pending[instanceId.Id()], bufferedMessage{msg, time.Now()})
+	broker.pendingSize++
+}
+
+// removeExpired discards buffered messages older than the ttl
+func (broker *Broker) removeExpired() {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	for id, msgs := range broker.pending {
+		valid := msgs[:0]
+		for _, bm := range msgs {
+			if time.Since(bm.received) <= broker.ttl {
+				valid = append(valid, bm)
+			}
+		}
+		broker.pendingSize -= len(msgs) - len(valid)
+		if len(valid) == 0 {
+			delete(broker.pending, id)
+			continue
+		}
+		broker.pending[id] = valid
+	}
+}
+
 // Unregister a listener
 func (broker *Broker) Unregister(identifiable Identifiable) {
 	broker.mutex.Lock()
@@ -2,6 +2,7 @@ package hare
 
 import (
 	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/common"
 	"github.com/spacemeshos/go-spacemesh/hare/pb"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
 	"github.com/stretchr/testify/assert"
@@ -27,7 +28,7 @@ func createMessage(t *testing.T, instanceId Byteable) []byte {
 
 type MockInboxer struct {
 	inbox chan *pb.HareMessage
-	id uint32
+	id    common.Hash
 }
 
 func (inboxer *MockInboxer) createInbox(size uint32) chan *pb.HareMessage {
@@ -35,14 +36,14 @@ func (inboxer *MockInboxer) createInbox(size uint32) chan *pb.HareMessage {
 	return inboxer.inbox
 }
 
-func (inboxer *MockInboxer) Id() uint32 {
+func (inboxer *MockInboxer) Id() common.Hash {
 	return inboxer.id
 }
 
 func TestBroker_Start(t *testing.T) {
 	sim := service.NewSimulator()
 	n1 := sim.NewNode()
-	broker := NewBroker(n1)
+	broker := NewBroker(n1, defaultValidator())
 
 	err := broker.Start()
 	assert.Equal(t, nil, err)
@@ -57,7 +58,7 @@ func TestBroker_Received(t *testing.T) {
 	n1 := sim.NewNode()
 	n2 := sim.NewNode()
 
-	broker := NewBroker(n1)
+	broker := NewBroker(n1, defaultValidator())
 	broker.Start()
 
 	inboxer := &MockInboxer{nil, instanceId1.Id()}
@@ -76,7 +77,7 @@ func TestBroker_Abort(t *testing.T) {
 	sim := service.NewSimulator()
 	n1 := sim.NewNode()
 
-	broker := NewBroker(n1)
+	broker := NewBroker(n1, defaultValidator())
 	broker.Start()
 
 	timer := time.NewTimer(3 * time.Second)
@@ -111,7 +112,7 @@ func TestBroker_MultipleInstanceIds(t *testing.T) {
 	n2 := sim.NewNode()
 	const msgCount = 100
 
-	broker := NewBroker(n1)
+	broker := NewBroker(n1, defaultValidator())
 	broker.Start()
 
 	inboxer1 := &MockInboxer{nil, instanceId1.Id()}
@@ -139,7 +140,7 @@ func TestBroker_MultipleInstanceIds(t *testing.T) {
 func TestBroker_RegisterUnregister(t *testing.T) {
 	sim := service.NewSimulator()
 	n1 := sim.NewNode()
-	broker := NewBroker(n1)
+	broker := NewBroker(n1, defaultValidator())
 	broker.Start()
 	inboxer := &MockInboxer{nil, instanceId1.Id()}
 	broker.Register(inboxer)
@@ -147,3 +148,144 @@ func TestBroker_RegisterUnregister(t *testing.T) {
 	broker.Unregister(instanceId1)
 	assert.Equal(t, 0, len(broker.outbox))
 }
+
+func createLayerMessage(t *testing.T, layer uint32, signing Signing) []byte {
+	s := NewEmptySet(lowDefaultSize)
+	s.Add(value1)
+	hareMsg := NewMessageBuilder().SetType(Status).SetInstanceId(NewInstanceId(layer)).SetRoundCounter(0).SetKi(-1).
+		SetValues(s).SetPubKey(generatePubKey(t)).Sign(signing).Build()
+	serMsg, err := proto.Marshal(hareMsg)
+	assert.NoError(t, err)
+
+	return serMsg
+}
+
+func pendingCount(broker *Broker) int {
+	broker.mutex.RLock()
+	defer broker.mutex.RUnlock()
+	return broker.pendingSize
+}
+
+// waits for the dispatcher to handle the messages sent so far
+func waitDispatched(t *testing.T, broker *Broker, expected int) {
+	timer := time.NewTimer(time.Second)
+	for pendingCount(broker) != expected {
+		select {
+		case <-timer.C:
+			assert.Fail(t, "timeout")
+			return
+		default:
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestBroker_EarlyMessageFlushedOnRegister(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+
+	broker := NewBroker(n1, defaultValidator())
+	broker.Start()
+	broker.SetLatestLayer(1)
+
+	n2.Broadcast(ProtoName, createLayerMessage(t, 2, NewMockSigning()))
+	n2.Broadcast(ProtoName, createLayerMessage(t, 2, NewMockSigning()))
+	waitDispatched(t, broker, 2)
+
+	inboxer := &MockInboxer{nil, NewInstanceId(2).Id()}
+	broker.Register(inboxer)
+	assert.Equal(t, 0, pendingCount(broker))
+	assert.Equal(t, 2, len(inboxer.inbox))
+
+	recv := <-inboxer.inbox
+	assert.Equal(t, NewInstanceId(2).Bytes(), recv.Message.InstanceId)
+}
+
+func TestBroker_EarlyMessageRejected(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+
+	broker := NewBroker(n1, defaultValidator())
+	broker.Start()
+	broker.SetLatestLayer(5)
+
+	n2.Broadcast(ProtoName, createLayerMessage(t, 6, &MockSigning{[]byte{9}}))           // invalid signature
+	n2.Broadcast(ProtoName, createLayerMessage(t, 5+MaxLayersAhead+1, NewMockSigning())) // too far ahead
+	n2.Broadcast(ProtoName, createLayerMessage(t, 4, NewMockSigning()))                  // old layer
+	n2.Broadcast(ProtoName, createMessage(t, &InstanceId{NewInstanceId(6).Bytes32}))     // unsigned
+	n2.Broadcast(ProtoName, createLayerMessage(t, 5+MaxLayersAhead, NewMockSigning()))   // valid
+	waitDispatched(t, broker, 1)
+
+	broker.mutex.RLock()
+	assert.Equal(t, 1, len(broker.pending))
+	assert.Equal(t, 1, len(broker.pending[NewInstanceId(5+MaxLayersAhead).Id()]))
+	broker.mutex.RUnlock()
+}
+
+func TestBroker_EarlyMessageBeforeFirstLayer(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+
+	broker := NewBroker(n1, defaultValidator())
+	broker.Start()
+
+	// the messages of any layer are buffered until the first instance is started, including layer 0
+	n2.Broadcast(ProtoName, createLayerMessage(t, 0, NewMockSigning()))
+	n2.Broadcast(ProtoName, createLayerMessage(t, 1, NewMockSigning()))
+	waitDispatched(t, broker, 2)
+
+	// layer 0 is started, its messages and the messages of older layers aren't buffered anymore
+	broker.SetLatestLayer(0)
+	n2.Broadcast(ProtoName, createLayerMessage(t, 0, NewMockSigning()))
+	n2.Broadcast(ProtoName, createLayerMessage(t, 2, NewMockSigning()))
+	waitDispatched(t, broker, 3)
+
+	broker.mutex.RLock()
+	assert.Equal(t, 1, len(broker.pending[NewInstanceId(0).Id()]))
+	assert.Equal(t, 1, len(broker.pending[NewInstanceId(1).Id()]))
+	assert.Equal(t, 1, len(broker.pending[NewInstanceId(2).Id()]))
+	broker.mutex.RUnlock()
+}
+
+func TestBroker_EarlyMessageCapacity(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+
+	broker := NewBroker(n1, defaultValidator())
+	broker.Start()
+
+	msg := createLayerMessage(t, 1, NewMockSigning())
+	for i := 0; i < InboxCapacity+10; i++ {
+		n2.Broadcast(ProtoName, msg)
+	}
+	// the marker is dispatched after all the messages above
+	n2.Broadcast(ProtoName, createLayerMessage(t, 2, NewMockSigning()))
+	waitDispatched(t, broker, InboxCapacity+1)
+}
+
+func TestBroker_EarlyMessageExpired(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+
+	broker := NewBroker(n1, defaultValidator())
+	broker.ttl = 50 * time.Millisecond
+	broker.Start()
+
+	n2.Broadcast(ProtoName, createLayerMessage(t, 1, NewMockSigning()))
+	waitDispatched(t, broker, 1)
+	time.Sleep(2 * broker.ttl)
+
+	// expired messages are not delivered even if the cleanup didn't run yet
+	inboxer := &MockInboxer{nil, NewInstanceId(1).Id()}
+	broker.Register(inboxer)
+	assert.Equal(t, 0, len(inboxer.inbox))
+
+	n2.Broadcast(ProtoName, createLayerMessage(t, 2, NewMockSigning()))
+	waitDispatched(t, broker, 1)
+	waitDispatched(t, broker, 0) // removed by the periodic cleanup
+}
@@ -0,0 +1,34 @@
+package hare
+
+import "time"
+
+// Ticker delivers the ticks which end the rounds of a consensus process
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// Clock is an interface to receive the current time and round ticks
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// RealClock is the clock of the system
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) Chan() <-chan time.Time {
+	return t.C
+}
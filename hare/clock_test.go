@@ -0,0 +1,52 @@
+package hare
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestManualClock_Advance(t *testing.T) {
+	start := time.Now()
+	clock := NewManualClock(start)
+	ticker := clock.NewTicker(time.Second)
+	ticks := make(chan time.Time, 10)
+	go func() {
+		for tick := range ticker.Chan() {
+			ticks <- tick
+		}
+	}()
+
+	clock.Advance(999 * time.Millisecond)
+	assert.Equal(t, start.Add(999*time.Millisecond), clock.Now())
+	assert.Equal(t, 0, len(ticks))
+
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, start.Add(time.Second), <-ticks)
+
+	// no tick is dropped
+	clock.Advance(3 * time.Second)
+	assert.Equal(t, start.Add(2*time.Second), <-ticks)
+	assert.Equal(t, start.Add(3*time.Second), <-ticks)
+	assert.Equal(t, start.Add(4*time.Second), <-ticks)
+}
+
+func TestManualClock_StoppedTicker(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	ticker := clock.NewTicker(time.Second)
+	ticker.Stop()
+	ticker.Stop()
+
+	clock.Advance(2 * time.Second) // doesn't wait for the stopped ticker
+}
+
+func TestRealClock_NewTicker(t *testing.T) {
+	ticker := RealClock{}.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	select {
+	case <-ticker.Chan():
+	case <-time.After(time.Second):
+		assert.Fail(t, "no tick")
+	}
+}
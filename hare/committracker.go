@@ -1,6 +1,7 @@
 package hare
 
 import (
+	"github.com/gogo/protobuf/proto"
 	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/hare/pb"
 	"github.com/spacemeshos/go-spacemesh/log"
@@ -53,6 +54,17 @@ func (ct *CommitTracker) OnCommit(msg *pb.HareMessage) {
 	ct.commits = append(ct.commits, msg)
 }
 
+// Exclude the commit message of the sender
+func (ct *CommitTracker) Exclude(pub string) {
+	for i, m := range ct.commits {
+		p, err := crypto.NewPublicKey(m.PubKey)
+		if err == nil && p.String() == pub {
+			ct.commits = append(ct.commits[:i], ct.commits[i+1:]...)
+			return
+		}
+	}
+}
+
 func (ct *CommitTracker) HasEnoughCommits() bool {
 	if ct.proposedSet == nil {
 		return false
@@ -68,11 +80,14 @@ func (ct *CommitTracker) BuildCertificate() *pb.Certificate {
 
 	c := &pb.Certificate{}
 	c.Values = ct.proposedSet.To2DSlice()
-	c.AggMsgs.Messages = ct.commits
-
-	// optimize msg size by setting values to nil
-	for _, commit := range c.AggMsgs.Messages {
-		commit.Message.Values = nil
+	c.AggMsgs = &pb.AggregatedMessages{}
+	c.AggMsgs.Messages = make([]*pb.HareMessage, 0, len(ct.commits))
+
+	// optimize msg size by setting values to nil, on copies since the tracked commits are kept
+	for _, commit := range ct.commits {
+		m := proto.Clone(commit).(*pb.HareMessage)
+		m.Message.Values = nil
+		c.AggMsgs.Messages = append(c.AggMsgs.Messages, m)
 	}
 
 	// TODO: set c.AggMsgs.AggSig
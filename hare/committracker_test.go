@@ -29,3 +29,37 @@ func TestCommitTracker_OnCommit(t *testing.T) {
 	tracker.OnCommit(BuildCommitMsg(generatePubKey(t), s))
 	assert.True(t, tracker.HasEnoughCommits())
 }
+
+func TestCommitTracker_Exclude(t *testing.T) {
+	s := NewEmptySet(lowDefaultSize)
+	s.Add(value1)
+	tracker := NewCommitTracker(2, lowThresh10, s)
+	pubKey := generatePubKey(t)
+	tracker.OnCommit(BuildCommitMsg(pubKey, s))
+	tracker.OnCommit(BuildCommitMsg(generatePubKey(t), s))
+	assert.True(t, tracker.HasEnoughCommits())
+	tracker.Exclude(pubKey.String())
+	assert.False(t, tracker.HasEnoughCommits())
+}
+
+func TestCommitTracker_BuildCertificate(t *testing.T) {
+	s := NewEmptySet(lowDefaultSize)
+	s.Add(value1)
+	tracker := NewCommitTracker(2, lowThresh10, s)
+	assert.Nil(t, tracker.BuildCertificate())
+
+	tracker.OnCommit(BuildCommitMsg(generatePubKey(t), s))
+	tracker.OnCommit(BuildCommitMsg(generatePubKey(t), s))
+	cert := tracker.BuildCertificate()
+	assert.NotNil(t, cert)
+	assert.True(t, s.Equals(NewSet(cert.Values)))
+	assert.Equal(t, 2, len(cert.AggMsgs.Messages))
+	for _, m := range cert.AggMsgs.Messages {
+		assert.Nil(t, m.Message.Values)
+	}
+
+	// the tracked commits are not modified
+	for _, m := range tracker.commits {
+		assert.True(t, s.Equals(NewSet(m.Message.Values)))
+	}
+}
@@ -3,12 +3,14 @@ package config
 import "time"
 
 type Config struct {
-	N             int           // total number of active parties
-	F             int           // number of dishonest parties
-	SetSize       int           // max size of set in a consensus
-	RoundDuration time.Duration // the duration of a single round
+	N               int           // the expected number of active parties in a round, bounded by the active set of an instance
+	F               int           // the number of dishonest parties out of N
+	SetSize         int           // max size of set in a consensus, bounded by the active set of an instance
+	RoundDuration   time.Duration // the duration of a single round
+	LimitIterations int           // the max number of iterations an instance runs before it is terminated without output
+	ExpectedLeaders int           // the expected number of leaders in a proposal round
 }
 
 func DefaultConfig() Config {
-	return Config{800, 400, 200, time.Second * time.Duration(15)}
+	return Config{800, 400, 200, time.Second * time.Duration(15), 5, 1}
 }
@@ -0,0 +1,140 @@
+package hare
+
+import (
+	"bytes"
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/hare/pb"
+	"github.com/spacemeshos/go-spacemesh/log"
+)
+
+const EquivocationProtoName = "HARE_EQUIVOCATION"
+
+// identifies the single message a party may send of a given type in a given round
+type roundKey struct {
+	pub     string
+	msgType MessageType
+	k       uint32
+}
+
+// EquivocationTracker detects parties which send two different messages of the same type in the same round of an
+// instance. It is sized by the committee size of the instance and the number of dishonest parties it tolerates
+type EquivocationTracker struct {
+	messages  map[roundKey]*pb.HareMessage     // maps the round of a sender to the first message seen
+	proofs    map[string]*pb.EquivocationProof // maps PubKey->EquivocationProof
+	tolerated int                              // the number of dishonest parties tolerated by the instance
+}
+
+func NewEquivocationTracker(expectedSize int, tolerated int) *EquivocationTracker {
+	et := &EquivocationTracker{}
+	et.messages = make(map[roundKey]*pb.HareMessage, expectedSize)
+	et.proofs = make(map[string]*pb.EquivocationProof)
+	et.tolerated = tolerated
+
+	return et
+}
+
+// addProof records the proof of a new equivocator and reports when the equivocators exceed the tolerated threshold
+func (et *EquivocationTracker) addProof(pub string, proof *pb.EquivocationProof) {
+	et.proofs[pub] = proof
+	if len(et.proofs) == et.tolerated+1 {
+		log.Error("%v parties equivocated, more than the %v dishonest parties tolerated", len(et.proofs), et.tolerated)
+	}
+}
+
+// Exceeded returns true if more parties equivocated than the dishonest parties tolerated by the instance
+func (et *EquivocationTracker) Exceeded() bool {
+	return len(et.proofs) > et.tolerated
+}
+
+// OnMessage records a signed message. It returns a proof if the message reveals a new equivocator
+func (et *EquivocationTracker) OnMessage(msg *pb.HareMessage) *pb.EquivocationProof {
+	pub, err := crypto.NewPublicKey(msg.PubKey)
+	if err != nil {
+		log.Warning("Could not construct public key: %v", err.Error())
+		return nil
+	}
+
+	if et.IsEquivocator(pub.String()) { // already proved
+		return nil
+	}
+
+	key := roundKey{pub.String(), MessageType(msg.Message.Type), msg.Message.K}
+	first, exist := et.messages[key]
+	if !exist {
+		// keep a copy since messages are modified when aggregated (e.g. commits in a certificate)
+		et.messages[key] = proto.Clone(msg).(*pb.HareMessage)
+		return nil
+	}
+
+	if proto.Equal(first.Message, msg.Message) { // same message received twice
+		return nil
+	}
+
+	log.Warning("Equivocation detected: %v sent two different %v messages in round %v", pub.String(), key.msgType, key.k)
+	proof := &pb.EquivocationProof{First: first, Second: proto.Clone(msg).(*pb.HareMessage)}
+	et.addProof(pub.String(), proof)
+
+	return proof
+}
+
+// OnProof records a validated proof received from another party. It returns true if the equivocator is new
+func (et *EquivocationTracker) OnProof(proof *pb.EquivocationProof) bool {
+	pub, err := crypto.NewPublicKey(proof.First.PubKey)
+	if err != nil {
+		log.Warning("Could not construct public key: %v", err.Error())
+		return false
+	}
+
+	if et.IsEquivocator(pub.String()) {
+		return false
+	}
+
+	et.addProof(pub.String(), proof)
+	return true
+}
+
+func (et *EquivocationTracker) IsEquivocator(pub string) bool {
+	_, exist := et.proofs[pub]
+	return exist
+}
+
+// Proof returns the proof of the equivocator or nil if the party didn't equivocate
+func (et *EquivocationTracker) Proof(pub string) *pb.EquivocationProof {
+	return et.proofs[pub]
+}
+
+// ValidateEquivocationProof verifies that both messages of the proof were signed by the same party,
+// are of the same type, instance and round, and are different
+func (validator *MessageValidator) ValidateEquivocationProof(proof *pb.EquivocationProof) bool {
+	if proof == nil || proof.First == nil || proof.Second == nil {
+		log.Warning("Equivocation proof validation failed: nil identified")
+		return false
+	}
+
+	first, second := proof.First, proof.Second
+	if !validator.ValidateSignedMessage(first) || !validator.ValidateSignedMessage(second) {
+		log.Warning("Equivocation proof validation failed: invalid message")
+		return false
+	}
+
+	pub1, err1 := crypto.NewPublicKey(first.PubKey)
+	pub2, err2 := crypto.NewPublicKey(second.PubKey)
+	if err1 != nil || err2 != nil || pub1.String() != pub2.String() {
+		log.Warning("Equivocation proof validation failed: messages of different senders")
+		return false
+	}
+
+	if first.Message.Type != second.Message.Type || first.Message.K != second.Message.K ||
+		!bytes.Equal(first.Message.InstanceId, second.Message.InstanceId) {
+		log.Warning("Equivocation proof validation failed: messages of different rounds")
+		return false
+	}
+
+	if proto.Equal(first.Message, second.Message) {
+		log.Warning("Equivocation proof validation failed: messages are equal")
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,236 @@
+package hare
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/hare/pb"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// roundRoleOracle gives every party the role required by the round
+type roundRoleOracle struct{}
+
+func (o roundRoleOracle) Proof(instanceId InstanceId, k uint32) (Signature, error) {
+	return Signature{1}, nil
+}
+
+func (o roundRoleOracle) Role(pub crypto.PublicKey, instanceId InstanceId, k uint32, proof Signature) Role {
+	return roleFromRoundCounter(k)
+}
+
+func buildSignedMsg(signing Signing, pub crypto.PublicKey, msgType MessageType, k uint32, ki int32, s *Set) *pb.HareMessage {
+	return NewMessageBuilder().SetType(msgType).SetInstanceId(*instanceId1).SetRoundCounter(k).SetKi(ki).
+		SetValues(s).SetRoleProof(Signature{1}).SetPubKey(pub).Sign(signing).Build()
+}
+
+func TestEquivocationTracker_OnMessage(t *testing.T) {
+	signing, pub := generateKeySigning(t)
+	s1 := NewEmptySet(lowDefaultSize)
+	s1.Add(value1)
+	s2 := NewEmptySet(lowDefaultSize)
+	s2.Add(value2)
+
+	for _, msgType := range []MessageType{PreRound, Status, Proposal, Commit, Notify} {
+		et := NewEquivocationTracker(lowDefaultSize, 1)
+		m1 := buildSignedMsg(signing, pub, msgType, 4, -1, s1)
+		assert.Nil(t, et.OnMessage(m1))
+		assert.Nil(t, et.OnMessage(m1), "duplicate message detected as equivocation")
+		assert.Nil(t, et.OnMessage(buildSignedMsg(signing, pub, msgType, 8, -1, s2)), "messages of different rounds")
+		assert.False(t, et.IsEquivocator(pub.String()))
+
+		m2 := buildSignedMsg(signing, pub, msgType, 4, -1, s2)
+		proof := et.OnMessage(m2)
+		assert.NotNil(t, proof, "equivocation of %v was not detected", msgType)
+		assert.True(t, et.IsEquivocator(pub.String()))
+		assert.True(t, proto.Equal(m1, proof.First))
+		assert.True(t, proto.Equal(m2, proof.Second))
+		assert.Equal(t, proof, et.Proof(pub.String()))
+
+		assert.Nil(t, et.OnMessage(buildSignedMsg(signing, pub, msgType, 4, 2, s2)), "equivocator proved twice")
+	}
+}
+
+func TestMessageValidator_ValidateEquivocationProof(t *testing.T) {
+	signing, pub := generateKeySigning(t)
+	otherSigning, otherPub := generateKeySigning(t)
+	validator := NewMessageValidator(signing, roundRoleOracle{}, lowThresh10, lowDefaultSize)
+	s1 := NewEmptySet(lowDefaultSize)
+	s1.Add(value1)
+	s2 := NewEmptySet(lowDefaultSize)
+	s2.Add(value2)
+
+	m1 := buildSignedMsg(signing, pub, Status, 0, -1, s1)
+	m2 := buildSignedMsg(signing, pub, Status, 0, -1, s2)
+	assert.True(t, validator.ValidateEquivocationProof(&pb.EquivocationProof{First: m1, Second: m2}))
+
+	assert.False(t, validator.ValidateEquivocationProof(nil))
+	assert.False(t, validator.ValidateEquivocationProof(&pb.EquivocationProof{First: m1}))
+	assert.False(t, validator.ValidateEquivocationProof(&pb.EquivocationProof{First: m1, Second: m1}), "equal messages")
+
+	other := buildSignedMsg(otherSigning, otherPub, Status, 0, -1, s2)
+	assert.False(t, validator.ValidateEquivocationProof(&pb.EquivocationProof{First: m1, Second: other}), "different senders")
+
+	nextRound := buildSignedMsg(signing, pub, Status, 4, -1, s2)
+	assert.False(t, validator.ValidateEquivocationProof(&pb.EquivocationProof{First: m1, Second: nextRound}), "different rounds")
+
+	forged := buildSignedMsg(otherSigning, pub, Status, 0, -1, s2)
+	assert.False(t, validator.ValidateEquivocationProof(&pb.EquivocationProof{First: m1, Second: forged}), "forged signature")
+}
+
+func TestConsensusProcess_ExcludeEquivocator(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	published := n2.RegisterProtocol(EquivocationProtoName)
+	proofs := make(chan service.Message, 1)
+	go func() { proofs <- <-published }()
+
+	conf := cfg
+	conf.N = 10
+	conf.F = 4
+	signing, _ := generateKeySigning(t)
+	proc := NewConsensusProcess(conf, generatePubKey(t), *instanceId1, *NewEmptySet(conf.SetSize), roundRoleOracle{}, signing, n1, RealClock{}, nil)
+	proc.statusesTracker = NewStatusTracker(conf.F+1, conf.N)
+
+	s := NewEmptySet(conf.SetSize)
+	s.Add(value1)
+	for i := 0; i < conf.F+1; i++ { // make the set provable
+		proc.preRoundTracker.tracker.Track(value1)
+	}
+	equivocatorSigning, equivocatorPub := generateKeySigning(t)
+	for i := 0; i < 2; i++ {
+		senderSigning, senderPub := generateKeySigning(t)
+		proc.handleMessage(buildSignedMsg(senderSigning, senderPub, Status, 0, -1, s))
+	}
+	proc.handleMessage(buildSignedMsg(equivocatorSigning, equivocatorPub, Status, 0, -1, s))
+	assert.Equal(t, 3, len(proc.statusesTracker.statuses))
+
+	// a conflicting status excludes the sender's first status
+	proc.handleMessage(buildSignedMsg(equivocatorSigning, equivocatorPub, Status, 0, 3, s))
+	assert.Equal(t, 2, len(proc.statusesTracker.statuses))
+	assert.True(t, proc.equivocations.IsEquivocator(equivocatorPub.String()))
+
+	// later messages of the equivocator are ignored
+	proc.handleMessage(buildSignedMsg(equivocatorSigning, equivocatorPub, PreRound, 0, -1, s))
+	assert.Equal(t, 0, len(proc.preRoundTracker.preRound))
+
+	select {
+	case msg := <-proofs:
+		proof := &pb.EquivocationProof{}
+		assert.NoError(t, proto.Unmarshal(msg.Bytes(), proof))
+		assert.True(t, proc.validator.ValidateEquivocationProof(proof))
+		assert.Equal(t, equivocatorPub.Bytes(), proof.First.PubKey)
+	case <-time.After(5 * time.Second):
+		t.Fatal("equivocation proof was not published")
+	}
+}
+
+func TestHare_EquivocationProof(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	signing, pub := generateKeySigning(t)
+	h := New(cfg, pub, n1, signing, NewMockOracle(), FixedActiveSet(cfg.N), newMockMesh(1, 1), NewOutputStore(database.NewMemDatabase()), make(chan mesh.LayerID))
+	assert.NoError(t, h.Start())
+	defer h.Close()
+
+	equivocatorSigning, equivocatorPub := generateKeySigning(t)
+	s1 := NewEmptySet(lowDefaultSize)
+	s1.Add(value1)
+	s2 := NewEmptySet(lowDefaultSize)
+	s2.Add(value2)
+
+	// equal messages are not a proof
+	m1 := buildSignedMsg(equivocatorSigning, equivocatorPub, Commit, 2, -1, s1)
+	invalid, err := proto.Marshal(&pb.EquivocationProof{First: m1, Second: m1})
+	assert.NoError(t, err)
+	assert.NoError(t, n2.Broadcast(EquivocationProtoName, invalid))
+
+	valid, err := proto.Marshal(&pb.EquivocationProof{First: m1, Second: buildSignedMsg(equivocatorSigning, equivocatorPub, Commit, 2, -1, s2)})
+	assert.NoError(t, err)
+	assert.NoError(t, n2.Broadcast(EquivocationProtoName, valid))
+
+	timeout := time.After(5 * time.Second)
+	for h.EquivocationProof(equivocatorPub) == nil {
+		select {
+		case <-timeout:
+			t.Fatal("equivocation proof was not recorded")
+		default:
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestHare_EquivocationProofOfInstanceParams(t *testing.T) {
+	signing, pub := generateKeySigning(t)
+	h := New(cfg, pub, service.NewSimulator().NewNode(), signing, roundRoleOracle{}, layerActiveSets{1: 10, 2: 10 * cfg.N},
+		newMockMesh(1, 1), NewOutputStore(database.NewMemDatabase()), make(chan mesh.LayerID))
+
+	small, err := NewParams(cfg, 10)
+	assert.NoError(t, err)
+	s1 := NewEmptySet(cfg.SetSize)
+	s1.Add(value1)
+	s2 := NewEmptySet(cfg.SetSize)
+	s2.Add(value2)
+
+	// conflicting notifications whose certificates meet the threshold of the small active set of layer 1 only
+	for layer, proved := range map[uint32]bool{1: true, 2: false} {
+		equivocatorSigning, equivocatorPub := generateKeySigning(t)
+		instanceId := NewInstanceId(layer)
+		h.onEquivocationProof(&pb.EquivocationProof{
+			First:  buildNotify(t, equivocatorSigning, equivocatorPub, instanceId, s1, small.F+1),
+			Second: buildNotify(t, equivocatorSigning, equivocatorPub, instanceId, s2, small.F+1),
+		})
+		assert.Equal(t, proved, h.EquivocationProof(equivocatorPub) != nil, "equivocation in layer %v", layer)
+	}
+}
+
+func TestHare_EquivocationTrackerOfInstanceParams(t *testing.T) {
+	signing, pub := generateKeySigning(t)
+	h := New(cfg, pub, service.NewSimulator().NewNode(), signing, roundRoleOracle{}, layerActiveSets{1: 10},
+		newMockMesh(1, 1), NewOutputStore(database.NewMemDatabase()), make(chan mesh.LayerID))
+
+	small, err := NewParams(cfg, 10)
+	assert.NoError(t, err)
+	s1 := NewEmptySet(cfg.SetSize)
+	s1.Add(value1)
+	s2 := NewEmptySet(cfg.SetSize)
+	s2.Add(value2)
+
+	// the instance tolerates the dishonest parties of its own committee, not of the static config
+	instanceId := NewInstanceId(1)
+	for i := 0; i <= small.F; i++ {
+		equivocatorSigning, equivocatorPub := generateKeySigning(t)
+		h.onEquivocationProof(&pb.EquivocationProof{
+			First:  buildNotify(t, equivocatorSigning, equivocatorPub, instanceId, s1, small.F+1),
+			Second: buildNotify(t, equivocatorSigning, equivocatorPub, instanceId, s2, small.F+1),
+		})
+		assert.Equal(t, i == small.F, h.equivocations[instanceId.Id()].Exceeded(), "%v equivocators", i+1)
+	}
+	assert.Equal(t, small.F, h.equivocations[instanceId.Id()].tolerated)
+}
+
+func TestHare_EquivocationLoopStopsOnClosedChannel(t *testing.T) {
+	h := New(cfg, generatePubKey(t), service.NewSimulator().NewNode(), NewMockSigning(), roundRoleOracle{},
+		FixedActiveSet(cfg.N), newMockMesh(1, 3), NewOutputStore(database.NewMemDatabase()), make(chan mesh.LayerID))
+	proofs := make(chan service.Message)
+	h.proofs = proofs
+	close(proofs)
+
+	stopped := make(chan struct{})
+	go func() {
+		h.equivocationLoop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("equivocation loop didn't stop when the channel was closed")
+	}
+}
@@ -0,0 +1,467 @@
+package hare
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/hare/config"
+	"github.com/spacemeshos/go-spacemesh/hare/pb"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"sync"
+	"time"
+)
+
+const outputChanSize = 100
+
+// Mesh provides the blocks seen for a layer and receives the block list agreed for the layer
+type Mesh interface {
+	LayerBlockIds(index mesh.LayerID) ([]mesh.BlockID, error)
+	GetBlock(id mesh.BlockID) (*mesh.Block, error)
+	AddLayer(layer *mesh.Layer) error
+}
+
+// Hare runs a consensus process for every layer and hands the agreed block list of the layer to the mesh
+type Hare struct {
+	Closer
+	config     config.Config
+	pubKey     crypto.PublicKey
+	network    NetworkService
+	clock      Clock // times the rounds of the consensus processes
+	broker     *Broker
+	signing    Signing
+	oracle     Rolacle
+	activeSets ActiveSetProvider // the committee parameters of an instance are derived from its active set
+	layers     Mesh
+	beginLayer chan mesh.LayerID // layer ticks
+	outputChan chan TerminationOutput
+	timeouts   chan mesh.LayerID
+	mutex      sync.Mutex
+	nextLayer  mesh.LayerID                       // ticks of older layers are ignored
+	instances  map[mesh.LayerID]*ConsensusProcess // running instances
+	outputs    *OutputStore                       // outputs of terminated instances
+
+	proofs        chan service.Message                 // equivocation proofs published by other parties
+	terminations  chan service.Message                 // termination messages published by other parties
+	equivocations map[common.Hash]*EquivocationTracker // equivocations proved to this node by instance
+}
+
+// New creates a hare orchestrator that starts a consensus process on every layer received from beginLayer
+func New(conf config.Config, key crypto.PublicKey, p2p NetworkService, signing Signing, oracle Rolacle, activeSets ActiveSetProvider, layers Mesh, outputs *OutputStore, beginLayer chan mesh.LayerID) *Hare {
+	h := new(Hare)
+	h.Closer = NewCloser()
+	h.config = conf
+	h.pubKey = key
+	h.network = p2p
+	h.clock = RealClock{}
+	h.signing = signing
+	h.oracle = oracle
+	h.activeSets = activeSets
+	h.layers = layers
+	h.outputs = outputs
+	h.beginLayer = beginLayer
+	h.outputChan = make(chan TerminationOutput, outputChanSize)
+	h.timeouts = make(chan mesh.LayerID)
+	h.instances = make(map[mesh.LayerID]*ConsensusProcess)
+	h.broker = NewInstanceBroker(p2p, h.instanceValidator)
+	h.equivocations = make(map[common.Hash]*EquivocationTracker)
+
+	return h
+}
+
+func blockIdAsValue(id mesh.BlockID) Value {
+	buff := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buff, uint32(id))
+	return Value{NewBytes32(buff)}
+}
+
+func valueAsBlockId(v Value) mesh.BlockID {
+	return mesh.BlockID(binary.LittleEndian.Uint32(v.Bytes()))
+}
+
+// instanceConfig returns the configuration of the instance with the committee parameters of its active set
+func (h *Hare) instanceConfig(instanceId InstanceId) (config.Config, error) {
+	activeSetSize, err := h.activeSets.ActiveSetSize(instanceId)
+	if err != nil {
+		return config.Config{}, err
+	}
+
+	params, err := NewParams(h.config, activeSetSize)
+	if err != nil {
+		return config.Config{}, err
+	}
+
+	return params.Apply(h.config), nil
+}
+
+// instanceValidator returns a validator of the messages of the instance which uses its committee parameters
+func (h *Hare) instanceValidator(instanceId InstanceId) (*MessageValidator, error) {
+	conf, err := h.instanceConfig(instanceId)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMessageValidator(h.signing, h.oracle, conf.F+1, conf.N), nil
+}
+
+// instanceEquivocations returns the tracker of the equivocations proved in the instance, it is sized by the committee
+// params of the instance. Needs to be called under mutex lock
+func (h *Hare) instanceEquivocations(instanceId InstanceId) (*EquivocationTracker, error) {
+	if et, ok := h.equivocations[instanceId.Id()]; ok {
+		return et, nil
+	}
+
+	conf, err := h.instanceConfig(instanceId)
+	if err != nil {
+		return nil, err
+	}
+
+	et := NewEquivocationTracker(conf.N, conf.F)
+	h.equivocations[instanceId.Id()] = et
+	return et, nil
+}
+
+// deadline is the time an instance is given to terminate, the pre-round followed by LimitIterations iterations
+func (h *Hare) deadline() time.Duration {
+	return h.config.RoundDuration * time.Duration(1+4*h.config.LimitIterations)
+}
+
+// Start the broker and listen to layer ticks (non-blocking)
+func (h *Hare) Start() error {
+	if err := h.broker.Start(); err != nil {
+		return err
+	}
+
+	h.proofs = h.network.RegisterProtocol(EquivocationProtoName)
+	h.terminations = h.network.RegisterProtocol(TerminationProtoName)
+
+	go h.tickLoop()
+	go h.outputCollectionLoop()
+	go h.equivocationLoop()
+	go h.terminationLoop()
+
+	return nil
+}
+
+// Close the broker and all running instances
+func (h *Hare) Close() {
+	h.Closer.Close()
+	h.broker.Close()
+
+	h.mutex.Lock()
+	for layer, proc := range h.instances {
+		h.broker.Unregister(proc)
+		proc.Close()
+		delete(h.instances, layer)
+	}
+	h.mutex.Unlock()
+}
+
+func (h *Hare) tickLoop() {
+	for {
+		select {
+		case layer := <-h.beginLayer:
+			if err := h.onTick(layer); err != nil {
+				log.Error("could not start consensus for layer %v: %v", layer, err)
+			}
+		case <-h.CloseChannel():
+			return
+		}
+	}
+}
+
+// onTick starts a consensus process for the layer with the blocks seen for it
+func (h *Hare) onTick(layer mesh.LayerID) error {
+	h.mutex.Lock()
+	if layer < h.nextLayer {
+		h.mutex.Unlock()
+		return errors.New("consensus for layer was already started")
+	}
+	h.nextLayer = layer + 1
+	h.mutex.Unlock()
+
+	// the instance may have terminated before a restart
+	if out, err := h.outputs.Get(NewInstanceId(uint32(layer))); err == nil {
+		log.Info("consensus for layer %v already terminated, adding its stored output", layer)
+		return h.addLayer(layer, NewSet(out.Values))
+	}
+
+	instanceId := NewInstanceId(uint32(layer))
+	conf, err := h.instanceConfig(instanceId)
+	if err != nil {
+		return fmt.Errorf("could not derive the committee parameters of layer %v: %v", layer, err)
+	}
+
+	set := NewEmptySet(conf.SetSize)
+	ids, err := h.layers.LayerBlockIds(layer)
+	if err != nil {
+		log.Warning("no blocks found for layer %v, starting consensus with an empty set", layer)
+	}
+	for _, id := range ids {
+		set.Add(blockIdAsValue(id))
+	}
+
+	proc := NewConsensusProcess(conf, h.pubKey, instanceId, *set, h.oracle, h.signing, h.network, h.clock, h.outputChan)
+	h.broker.Register(proc)
+	h.broker.SetLatestLayer(uint32(layer))
+
+	h.mutex.Lock()
+	h.instances[layer] = proc
+	h.mutex.Unlock()
+
+	if err := proc.Start(); err != nil {
+		h.stopInstance(layer)
+		return err
+	}
+
+	time.AfterFunc(h.deadline(), func() {
+		select {
+		case h.timeouts <- layer:
+		case <-h.CloseChannel():
+		}
+	})
+
+	log.Info("started consensus for layer %v with %v blocks", layer, len(ids))
+	return nil
+}
+
+// stopInstance closes and unregisters the instance of the layer, returns false if it is not running
+func (h *Hare) stopInstance(layer mesh.LayerID) bool {
+	h.mutex.Lock()
+	proc, exist := h.instances[layer]
+	delete(h.instances, layer)
+	h.mutex.Unlock()
+
+	if !exist {
+		return false
+	}
+
+	h.broker.Unregister(proc)
+	proc.Close()
+	return true
+}
+
+func (h *Hare) outputCollectionLoop() {
+	for {
+		select {
+		case out := <-h.outputChan:
+			h.collectOutput(out)
+		case layer := <-h.timeouts:
+			if h.stopInstance(layer) {
+				log.Warning("consensus for layer %v did not terminate before the deadline", layer)
+			}
+		case <-h.CloseChannel():
+			return
+		}
+	}
+}
+
+// collectOutput stops the instance that reported the output and hands the agreed blocks to the mesh
+func (h *Hare) collectOutput(out TerminationOutput) {
+	var layer mesh.LayerID
+	found := false
+	h.mutex.Lock()
+	for l, proc := range h.instances {
+		if proc.Id() == out.Id() {
+			layer, found = l, true
+			break
+		}
+	}
+	h.mutex.Unlock()
+
+	if !found || !h.stopInstance(layer) {
+		log.Warning("got output of an instance which is not running %v", out.Id())
+		return
+	}
+
+	instanceId := NewInstanceId(uint32(layer))
+	if h.outputs.Has(instanceId) { // learned from a termination message meanwhile
+		return
+	}
+
+	if err := h.outputs.Put(instanceId, out); err != nil {
+		log.Error("could not store the output of layer %v: %v", layer, err)
+	}
+
+	if err := h.addLayer(layer, out.Set()); err != nil {
+		log.Error("could not add the agreed blocks of layer %v to the mesh: %v", layer, err)
+	}
+
+	if out.Certificate() != nil {
+		h.announceTermination(instanceId, out.Certificate())
+	}
+}
+
+// announceTermination publishes the certificate of the agreed set so that late parties can learn it
+func (h *Hare) announceTermination(instanceId InstanceId, cert *pb.Certificate) {
+	data, err := proto.Marshal(&pb.TerminationMessage{InstanceId: instanceId.Bytes(), Cert: cert})
+	if err != nil {
+		log.Error("could not marshal termination message: %v", err)
+		return
+	}
+
+	if err := h.network.Broadcast(TerminationProtoName, data); err != nil {
+		log.Error("could not broadcast termination message: %v", err)
+	}
+}
+
+func (h *Hare) terminationLoop() {
+	for {
+		select {
+		case msg, ok := <-h.terminations:
+			if !ok {
+				log.Info("termination messages channel closed, stopping the termination loop")
+				return
+			}
+			termination := &pb.TerminationMessage{}
+			if err := proto.Unmarshal(msg.Bytes(), termination); err != nil {
+				log.Error("could not unmarshal termination message: %v", err)
+				continue
+			}
+			h.onTermination(termination)
+		case <-h.CloseChannel():
+			return
+		}
+	}
+}
+
+// onTermination accepts the certified set of an instance without running its rounds. The layer is added to the mesh
+// if it already began, otherwise the stored output is used when it begins
+func (h *Hare) onTermination(msg *pb.TerminationMessage) {
+	instanceId := InstanceId{NewBytes32(msg.InstanceId)}
+	validator, err := h.instanceValidator(instanceId)
+	if err != nil {
+		log.Warning("could not validate the termination message of instance %v: %v", instanceId.Id(), err)
+		return
+	}
+
+	if !validator.ValidateTermination(msg) {
+		log.Warning("got an invalid termination message")
+		return
+	}
+
+	if h.outputs.Has(instanceId) { // already terminated
+		return
+	}
+
+	layer := mesh.LayerID(instanceId.Layer())
+	out := terminationOutput(msg)
+	if err := h.outputs.Put(instanceId, out); err != nil {
+		log.Error("could not store the output of layer %v: %v", layer, err)
+		return
+	}
+
+	h.mutex.Lock()
+	began := layer < h.nextLayer
+	h.mutex.Unlock()
+	if !began {
+		return
+	}
+
+	h.stopInstance(layer)
+	log.Info("learned the agreed set of layer %v from a certificate", layer)
+	if err := h.addLayer(layer, out.Set()); err != nil {
+		log.Error("could not add the agreed blocks of layer %v to the mesh: %v", layer, err)
+	}
+}
+
+// addLayer adds the layer to the mesh with the agreed set as its block list
+func (h *Hare) addLayer(layer mesh.LayerID, s *Set) error {
+	blocks := make([]*mesh.Block, 0, len(s.values))
+	for _, v := range s.values {
+		b, err := h.layers.GetBlock(valueAsBlockId(v))
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, b)
+	}
+
+	return h.layers.AddLayer(mesh.NewExistingLayer(layer, blocks))
+}
+
+func (h *Hare) equivocationLoop() {
+	for {
+		select {
+		case msg, ok := <-h.proofs:
+			if !ok {
+				log.Info("equivocation proofs channel closed, stopping the equivocation loop")
+				return
+			}
+			proof := &pb.EquivocationProof{}
+			if err := proto.Unmarshal(msg.Bytes(), proof); err != nil {
+				log.Error("could not unmarshal equivocation proof: %v", err)
+				continue
+			}
+			h.onEquivocationProof(proof)
+		case <-h.CloseChannel():
+			return
+		}
+	}
+}
+
+// onEquivocationProof records a valid proof and passes the conflicting messages to the instance they were sent to,
+// so that it excludes the equivocator as well
+func (h *Hare) onEquivocationProof(proof *pb.EquivocationProof) {
+	if proof == nil || proof.First == nil || proof.First.Message == nil {
+		log.Warning("got an invalid equivocation proof")
+		return
+	}
+
+	instanceId := InstanceId{NewBytes32(proof.First.Message.InstanceId)}
+	validator, err := h.instanceValidator(instanceId)
+	if err != nil {
+		log.Warning("could not validate the equivocation proof of instance %v: %v", instanceId.Id(), err)
+		return
+	}
+
+	if !validator.ValidateEquivocationProof(proof) {
+		log.Warning("got an invalid equivocation proof")
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	equivocations, err := h.instanceEquivocations(instanceId)
+	if err != nil {
+		log.Warning("could not track the equivocations of instance %v: %v", instanceId.Id(), err)
+		return
+	}
+	if !equivocations.OnProof(proof) { // already known
+		return
+	}
+
+	for _, proc := range h.instances {
+		if bytes.Equal(proc.instanceId.Bytes(), proof.First.Message.InstanceId) {
+			for _, m := range []*pb.HareMessage{proof.First, proof.Second} {
+				select {
+				case proc.inbox <- m:
+				default:
+					log.Warning("inbox of instance %v is full, equivocation proof was not delivered", proc.Id())
+				}
+			}
+		}
+	}
+}
+
+// Output returns the stored output of the consensus of the layer, an error is returned if it didn't terminate
+func (h *Hare) Output(layer mesh.LayerID) (*pb.InstanceOutput, error) {
+	return h.outputs.Get(NewInstanceId(uint32(layer)))
+}
+
+// EquivocationProof returns a proof of equivocation of the party received from the network in any instance, nil if
+// there's none
+func (h *Hare) EquivocationProof(pub crypto.PublicKey) *pb.EquivocationProof {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, equivocations := range h.equivocations {
+		if proof := equivocations.Proof(pub.String()); proof != nil {
+			return proof
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package hare
+
+import (
+	"errors"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/hare/config"
+	"github.com/spacemeshos/go-spacemesh/hare/pb"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockMesh struct {
+	mutex  sync.Mutex
+	blocks map[mesh.BlockID]*mesh.Block
+	added  chan *mesh.Layer
+}
+
+func newMockMesh(layer mesh.LayerID, count int) *mockMesh {
+	mm := &mockMesh{blocks: make(map[mesh.BlockID]*mesh.Block), added: make(chan *mesh.Layer, 10)}
+	for i := 0; i < count; i++ {
+		b := mesh.NewBlock(true, nil, time.Now(), layer)
+		mm.blocks[b.ID()] = b
+	}
+	return mm
+}
+
+func (mm *mockMesh) LayerBlockIds(index mesh.LayerID) ([]mesh.BlockID, error) {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+	ids := make([]mesh.BlockID, 0, len(mm.blocks))
+	for id, b := range mm.blocks {
+		if b.Layer() == index {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (mm *mockMesh) GetBlock(id mesh.BlockID) (*mesh.Block, error) {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+	if b, ok := mm.blocks[id]; ok {
+		return b, nil
+	}
+	return nil, errors.New("block not found")
+}
+
+func (mm *mockMesh) AddLayer(layer *mesh.Layer) error {
+	mm.added <- layer
+	return nil
+}
+
+func newTestHareWithSigning(t *testing.T, conf config.Config, layers Mesh, signing Signing, pub crypto.PublicKey) (*Hare, chan mesh.LayerID) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	ticks := make(chan mesh.LayerID)
+	h := New(conf, pub, n1, signing, roundRoleOracle{}, FixedActiveSet(conf.N), layers, NewOutputStore(database.NewMemDatabase()), ticks)
+	assert.NoError(t, h.Start())
+	return h, ticks
+}
+
+func newTestHare(t *testing.T, conf config.Config, layers Mesh) (*Hare, chan mesh.LayerID) {
+	return newTestHareWithSigning(t, conf, layers, NewMockSigning(), generatePubKey(t))
+}
+
+// newKeyedTestHare returns a hare which validates signatures made by keys
+func newKeyedTestHare(t *testing.T, conf config.Config, layers Mesh) (*Hare, chan mesh.LayerID) {
+	signing, pub := generateKeySigning(t)
+	return newTestHareWithSigning(t, conf, layers, signing, pub)
+}
+
+func (h *Hare) instance(layer mesh.LayerID) *ConsensusProcess {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.instances[layer]
+}
+
+func (h *Hare) runningInstances() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.instances)
+}
+
+func TestHare_StartInstanceOnTick(t *testing.T) {
+	layers := newMockMesh(1, 3)
+	h, ticks := newTestHare(t, cfg, layers)
+	defer h.Close()
+
+	ticks <- 1
+	ticks <- 1 // duplicate ticks are ignored
+
+	proc := h.instance(1)
+	assert.NotNil(t, proc)
+	assert.Equal(t, 1, h.runningInstances())
+	assert.Equal(t, NewInstanceId(1).Id(), proc.Id())
+	assert.Equal(t, 3, len(proc.s.values))
+
+	h.broker.mutex.RLock()
+	_, registered := h.broker.outbox[proc.Id()]
+	h.broker.mutex.RUnlock()
+	assert.True(t, registered)
+}
+
+func TestHare_InstanceParams(t *testing.T) {
+	layers := newMockMesh(1, 3)
+	ticks := make(chan mesh.LayerID)
+	h := New(cfg, generatePubKey(t), service.NewSimulator().NewNode(), NewMockSigning(), NewMockOracle(),
+		layerActiveSets{1: 10, 2: 10 * cfg.N}, layers, NewOutputStore(database.NewMemDatabase()), ticks)
+	assert.NoError(t, h.Start())
+	defer h.Close()
+
+	ticks <- 1
+	ticks <- 2
+	ticks <- 3 // the active set of the layer is unknown
+
+	small, err := NewParams(cfg, 10)
+	assert.NoError(t, err)
+	proc := h.instance(1)
+	assert.NotNil(t, proc)
+	assert.Equal(t, small, Params{proc.cfg.N, proc.cfg.F, proc.cfg.SetSize})
+
+	proc = h.instance(2)
+	assert.NotNil(t, proc)
+	assert.Equal(t, Params{cfg.N, cfg.F, cfg.SetSize}, Params{proc.cfg.N, proc.cfg.F, proc.cfg.SetSize})
+
+	assert.Nil(t, h.instance(3))
+}
+
+func TestHare_OutputToMesh(t *testing.T) {
+	layers := newMockMesh(2, 4)
+	h, ticks := newTestHare(t, cfg, layers)
+	defer h.Close()
+
+	ticks <- 2
+	proc := h.instance(2)
+	assert.NotNil(t, proc)
+
+	// agree on a subset of the blocks
+	agreed := NewEmptySet(cfg.SetSize)
+	expected := make([]mesh.BlockID, 0, 2)
+	for id := range layers.blocks {
+		if len(expected) == 2 {
+			break
+		}
+		agreed.Add(blockIdAsValue(id))
+		expected = append(expected, id)
+	}
+	h.outputChan <- procOutput{proc.Id(), agreed, nil, 7}
+
+	select {
+	case layer := <-layers.added:
+		assert.Equal(t, mesh.LayerID(2), layer.Index())
+		ids := make([]mesh.BlockID, 0, len(layer.Blocks()))
+		for _, b := range layer.Blocks() {
+			ids = append(ids, b.ID())
+		}
+		assert.ElementsMatch(t, expected, ids)
+	case <-time.After(5 * time.Second):
+		t.Fatal("agreed set was not handed to the mesh")
+	}
+
+	assert.Equal(t, 0, h.runningInstances())
+	h.broker.mutex.RLock()
+	assert.Equal(t, 0, len(h.broker.outbox))
+	h.broker.mutex.RUnlock()
+	<-proc.CloseChannel()
+
+	out, err := h.Output(2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(7), out.K)
+	assert.True(t, agreed.Equals(NewSet(out.Values)))
+	_, err = h.Output(3)
+	assert.Error(t, err)
+}
+
+func TestHare_StoredOutputAfterRestart(t *testing.T) {
+	layers := newMockMesh(3, 2)
+	h, ticks := newTestHare(t, cfg, layers)
+	defer h.Close()
+
+	// the instance of the layer terminated before the restart
+	agreed := NewEmptySet(cfg.SetSize)
+	for id := range layers.blocks {
+		agreed.Add(blockIdAsValue(id))
+	}
+	assert.NoError(t, h.outputs.Put(NewInstanceId(3), procOutput{NewInstanceId(3).Id(), agreed, nil, 7}))
+
+	ticks <- 3
+	select {
+	case layer := <-layers.added:
+		assert.Equal(t, mesh.LayerID(3), layer.Index())
+		assert.Equal(t, 2, len(layer.Blocks()))
+	case <-time.After(5 * time.Second):
+		t.Fatal("stored set was not handed to the mesh")
+	}
+	assert.Nil(t, h.instance(3))
+}
+
+func TestHare_Deadline(t *testing.T) {
+	conf := cfg
+	conf.RoundDuration = 10 * time.Millisecond
+	conf.LimitIterations = 1
+	layers := newMockMesh(1, 2)
+	h, ticks := newTestHare(t, conf, layers)
+	defer h.Close()
+
+	ticks <- 1
+	proc := h.instance(1)
+	assert.NotNil(t, proc)
+
+	select {
+	case <-proc.CloseChannel():
+	case <-time.After(5 * time.Second):
+		t.Fatal("instance was not closed after the deadline")
+	}
+
+	timeout := time.After(5 * time.Second)
+	for h.runningInstances() > 0 {
+		select {
+		case <-timeout:
+			t.Fatal("instance was not removed after the deadline")
+		default:
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	h.broker.mutex.RLock()
+	assert.Equal(t, 0, len(h.broker.outbox))
+	h.broker.mutex.RUnlock()
+
+	select {
+	case <-layers.added:
+		t.Fatal("layer was added without an agreed set")
+	default:
+	}
+}
+
+func TestHare_Close(t *testing.T) {
+	layers := newMockMesh(1, 2)
+	h, ticks := newTestHare(t, cfg, layers)
+
+	ticks <- 1
+	proc := h.instance(1)
+	assert.NotNil(t, proc)
+
+	h.Close()
+	<-proc.CloseChannel()
+	assert.Equal(t, 0, h.runningInstances())
+}
+
+// buildNotify returns a notification of the set with a certificate signed by count parties
+func buildNotify(t *testing.T, signing Signing, pub crypto.PublicKey, instanceId InstanceId, s *Set, count int) *pb.HareMessage {
+	return NewMessageBuilder().SetType(Notify).SetInstanceId(instanceId).SetRoundCounter(Round4).SetKi(Round3).
+		SetValues(s).SetCertificate(buildCertificate(t, instanceId, s, count)).SetRoleProof(Signature{1}).
+		SetPubKey(pub).Sign(signing).Build()
+}
+
+func TestHare_InstanceParamsOfEarlyMessages(t *testing.T) {
+	layers := newMockMesh(1, 2)
+	signing, pub := generateKeySigning(t)
+	h := New(cfg, pub, service.NewSimulator().NewNode(), signing, roundRoleOracle{}, layerActiveSets{1: 10, 2: 10 * cfg.N},
+		layers, NewOutputStore(database.NewMemDatabase()), make(chan mesh.LayerID))
+
+	// notifications of upcoming instances certified by the threshold of a small active set
+	small, err := NewParams(cfg, 10)
+	assert.NoError(t, err)
+	senderSigning, senderPub := generateKeySigning(t)
+	for _, layer := range []uint32{1, 2} {
+		instanceId := NewInstanceId(layer)
+		h.broker.bufferEarlyMessage(instanceId, buildNotify(t, senderSigning, senderPub, instanceId, layerSet(layers), small.F+1))
+	}
+
+	h.broker.mutex.RLock()
+	defer h.broker.mutex.RUnlock()
+	assert.Equal(t, 1, len(h.broker.pending[NewInstanceId(1).Id()]))
+	assert.Empty(t, h.broker.pending[NewInstanceId(2).Id()])
+}
@@ -2,8 +2,10 @@ package hare
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
-	"hash/fnv"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"sort"
 )
 
@@ -57,27 +59,38 @@ func NewBytes32(buff []byte) Bytes32 {
 	return x
 }
 
-func (b32 Bytes32) Id() uint32 {
-	h := fnv.New32()
-	h.Write(b32[:])
-	return h.Sum32()
+// Id returns the bytes themselves as the id, which is collision free
+func (b32 Bytes32) Id() common.Hash {
+	return common.Hash(b32)
 }
 
 func (b32 Bytes32) Bytes() []byte {
 	return b32[:]
 }
 
+// NewInstanceId returns the id of the consensus instance of the layer
+func NewInstanceId(layer uint32) InstanceId {
+	buff := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buff, layer)
+	return InstanceId{NewBytes32(buff)}
+}
+
+// Layer returns the layer the instance agrees on
+func (id InstanceId) Layer() uint32 {
+	return binary.LittleEndian.Uint32(id.Bytes32[:4])
+}
+
 // Represents a unique set of values
 type Set struct {
-	values    map[uint32]Value
-	id        uint32
+	values    map[common.Hash]Value
+	id        common.Hash
 	isIdValid bool
 }
 
 func NewEmptySet(expectedSize int) *Set {
 	s := &Set{}
-	s.values = make(map[uint32]Value, expectedSize)
-	s.id = 0
+	s.values = make(map[common.Hash]Value, expectedSize)
+	s.id = common.Hash{}
 	s.isIdValid = false
 
 	return s
@@ -87,7 +100,7 @@ func NewSet(data [][]byte) *Set {
 	s := &Set{}
 	s.isIdValid = false
 
-	s.values = make(map[uint32]Value, len(data))
+	s.values = make(map[common.Hash]Value, len(data))
 	for i := 0; i < len(data); i++ {
 		bid := Value{NewBytes32(data[i])}
 		s.values[bid.Id()] = bid
@@ -129,6 +142,7 @@ func (s *Set) Equals(g *Set) bool {
 	return true
 }
 
+// To2DSlice returns the values of the set sorted, so that equal sets are serialized (and signed) the same
 func (s *Set) To2DSlice() [][]byte {
 	slice := make([][]byte, len(s.values))
 	i := 0
@@ -137,32 +151,18 @@ func (s *Set) To2DSlice() [][]byte {
 		copy(slice[i], v.Bytes())
 		i++
 	}
+	sort.Slice(slice, func(i, j int) bool { return bytes.Compare(slice[i], slice[j]) < 0 })
 
 	return slice
 }
 
+// the id is the cryptographic hash of the sorted values, values are of fixed size so their concatenation is unambiguous
 func (s *Set) updateId() {
-	// order keys
-	keys := make([]uint32, len(s.values))
-	i := 0
-	for k := range s.values {
-		keys[i] = k
-		i++
-	}
-	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
-
-	// calc
-	h := fnv.New32()
-	for i := 0; i < len(keys); i++ {
-		h.Write(s.values[keys[i]].Bytes())
-	}
-
-	// update
-	s.id = h.Sum32()
+	s.id = common.BytesToHash(crypto.Sha256(s.To2DSlice()...))
 	s.isIdValid = true
 }
 
-func (s *Set) Id() uint32 {
+func (s *Set) Id() common.Hash {
 	if !s.isIdValid {
 		s.updateId()
 	}
@@ -1,6 +1,7 @@
 package hare
 
 import (
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -51,3 +52,23 @@ func TestSet_Id(t *testing.T) {
 	s1.Add(value3)
 	assert.Equal(t, s1.Id(), s3.Id())
 }
+
+func TestSet_IdIsHashOfSortedValues(t *testing.T) {
+	s := NewEmptySet(lowDefaultSize)
+	s.Add(value3)
+	s.Add(value1)
+	expected := crypto.Sha256(value1.Bytes(), value3.Bytes())
+	assert.Equal(t, expected, s.Id().Bytes())
+
+	// the id is updated on changes
+	s.Remove(value3)
+	assert.Equal(t, crypto.Sha256(value1.Bytes()), s.Id().Bytes())
+	assert.NotEqual(t, s.Id(), NewEmptySet(lowDefaultSize).Id())
+}
+
+func TestBytes32_Id(t *testing.T) {
+	assert.Equal(t, instanceId1.Bytes(), instanceId1.Id().Bytes())
+	assert.NotEqual(t, instanceId1.Id(), instanceId2.Id())
+	assert.NotEqual(t, NewInstanceId(1).Id(), NewInstanceId(1<<16).Id())
+	assert.Equal(t, uint32(1<<16), NewInstanceId(1<<16).Layer())
+}
@@ -1,6 +1,7 @@
 package hare
 
 import (
+	"bytes"
 	"github.com/gogo/protobuf/proto"
 	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/hare/pb"
@@ -9,17 +10,17 @@ import (
 
 type MessageValidator struct {
 	signing     Signing
+	oracle      Rolacle // verifies the eligibility of the senders of aggregated messages
 	threshold   int
 	defaultSize int
 }
 
-func NewMessageValidator(signing Signing, threshold int, defaultSize int) *MessageValidator {
-	return &MessageValidator{signing, threshold, defaultSize}
+func NewMessageValidator(signing Signing, oracle Rolacle, threshold int, defaultSize int) *MessageValidator {
+	return &MessageValidator{signing, oracle, threshold, defaultSize}
 }
 
 func (validator *MessageValidator) ValidateMessage(m *pb.HareMessage, k uint32) bool {
-	if !validator.isSyntacticallyValid(m) {
-		log.Warning("Validate message failed: message is not syntactically valid")
+	if !validator.ValidateSignedMessage(m) {
 		return false
 	}
 
@@ -29,14 +30,18 @@ func (validator *MessageValidator) ValidateMessage(m *pb.HareMessage, k uint32)
 		return false
 	}
 
-	data, err := proto.Marshal(m.Message)
-	if err != nil {
-		log.Error("Validate message failed: failed marshaling inner message")
+	return true
+}
+
+// ValidateSignedMessage validates the message regardless of the current round, i.e. its syntax and signature
+func (validator *MessageValidator) ValidateSignedMessage(m *pb.HareMessage) bool {
+	if !validator.isSyntacticallyValid(m) {
+		log.Warning("Validate message failed: message is not syntactically valid")
 		return false
 	}
 
 	// validate signature
-	if !validator.signing.Validate(data, m.InnerSig) {
+	if !validator.isSignatureValid(m) {
 		log.Warning("Validate message failed: invalid message signature detected")
 		return false
 	}
@@ -44,11 +49,30 @@ func (validator *MessageValidator) ValidateMessage(m *pb.HareMessage, k uint32)
 	return true
 }
 
+// verifies the inner message was signed by the owner of the message's public key
+func (validator *MessageValidator) isSignatureValid(m *pb.HareMessage) bool {
+	pub, err := crypto.NewPublicKey(m.PubKey)
+	if err != nil {
+		log.Warning("Signature validation failed: could not construct public key: %v", err.Error())
+		return false
+	}
+
+	data, err := proto.Marshal(m.Message)
+	if err != nil {
+		log.Error("Signature validation failed: failed marshaling inner message")
+		return false
+	}
+
+	return validator.signing.Validate(pub, data, m.InnerSig)
+}
+
 // verifies the message is contextually valid
 func isContextuallyValid(m *pb.HareMessage, k uint32) bool {
 	isSameIteration := iterationFromCounter(k) == iterationFromCounter(m.Message.K)
 	currentRound := k % 4
 	switch MessageType(m.Message.Type) {
+	case PreRound:
+		return true
 	case Status:
 		return isSameIteration && currentRound == Round1
 	case Proposal:
@@ -70,6 +94,18 @@ func (validator *MessageValidator) isSyntacticallyValid(m *pb.HareMessage) bool
 		return false
 	}
 
+	// ids are the bytes themselves, a shorter or longer buffer would be identified with another one
+	if len(m.Message.InstanceId) != len(Bytes32{}) {
+		log.Warning("Syntax validation failed: invalid instance id length %v", len(m.Message.InstanceId))
+		return false
+	}
+	for _, v := range m.Message.Values {
+		if len(v) != len(Bytes32{}) {
+			log.Warning("Syntax validation failed: invalid value length %v", len(v))
+			return false
+		}
+	}
+
 	claimedRound := m.Message.K % 4
 	switch MessageType(m.Message.Type) {
 	case PreRound:
@@ -132,6 +168,17 @@ func (validator *MessageValidator) validateAggregatedMessage(aggMsg *pb.Aggregat
 		}
 		senders[pub.String()] = struct{}{} // mark sender as exist
 
+		if !validator.isSignatureValid(innerMsg) {
+			log.Warning("Aggregated validation failed: identified an invalid signature")
+			return false
+		}
+
+		// a forged certificate or svp may be signed by any keys, only the messages of the committee count
+		if !validator.isEligible(pub, innerMsg) {
+			log.Warning("Aggregated validation failed: sender %v is not eligible for round %v", pub.String(), innerMsg.Message.K)
+			return false
+		}
+
 		// validate with attached validators
 		for _, validator := range validators {
 			if !validator(innerMsg) {
@@ -144,6 +191,12 @@ func (validator *MessageValidator) validateAggregatedMessage(aggMsg *pb.Aggregat
 	return true
 }
 
+// verifies the role proof of the message proves the sender has the role required by the round it was sent in
+func (validator *MessageValidator) isEligible(pub crypto.PublicKey, m *pb.HareMessage) bool {
+	instanceId := InstanceId{NewBytes32(m.Message.InstanceId)}
+	return validator.oracle.Role(pub, instanceId, m.Message.K, Signature(m.Message.RoleProof)) == roleFromRoundCounter(m.Message.K)
+}
+
 func (validator *MessageValidator) validateSVP(msg *pb.HareMessage) bool {
 	validateSameIteration := func(m *pb.HareMessage) bool {
 		proposalIter := iterationFromCounter(msg.Message.K)
@@ -193,9 +246,18 @@ func (validator *MessageValidator) validateCertificate(cert *pb.Certificate) boo
 		return false
 	}
 
-	validateSameK := func(m *pb.HareMessage) bool { return m.Message.K == cert.AggMsgs.Messages[0].Message.K }
-	validators := []func(m *pb.HareMessage) bool{validateCommitType, validateSameK}
-	if !validator.validateAggregatedMessage(cert.AggMsgs, validators) {
+	aggMsgs, ok := refillCommits(cert)
+	if !ok {
+		log.Warning("Certificate validation failed: commits don't match the certified set")
+		return false
+	}
+
+	validateSameK := func(m *pb.HareMessage) bool { return m.Message.K == aggMsgs.Messages[0].Message.K }
+	validateSameInstance := func(m *pb.HareMessage) bool {
+		return bytes.Equal(m.Message.InstanceId, aggMsgs.Messages[0].Message.InstanceId)
+	}
+	validators := []func(m *pb.HareMessage) bool{validateCommitType, validateSameK, validateSameInstance}
+	if !validator.validateAggregatedMessage(aggMsgs, validators) {
 		log.Warning("Certificate validation failed: aggregated messages validation failed")
 		return false
 	}
@@ -203,6 +265,37 @@ func (validator *MessageValidator) validateCertificate(cert *pb.Certificate) boo
 	return true
 }
 
+// refillCommits returns a copy of the aggregated commits of the certificate with the values which were
+// omitted to save space restored from the certified set, so that their signatures can be validated.
+// It returns false if a commit carries a set other than the certified one
+func refillCommits(cert *pb.Certificate) (*pb.AggregatedMessages, bool) {
+	if cert.AggMsgs == nil {
+		return nil, true // rejected by the aggregated validation
+	}
+
+	certified := NewSet(cert.Values)
+	aggMsgs := &pb.AggregatedMessages{AggSig: cert.AggMsgs.AggSig}
+	if cert.AggMsgs.Messages != nil {
+		aggMsgs.Messages = make([]*pb.HareMessage, 0, len(cert.AggMsgs.Messages))
+	}
+	for _, commit := range cert.AggMsgs.Messages {
+		if commit == nil || commit.Message == nil {
+			aggMsgs.Messages = append(aggMsgs.Messages, commit) // rejected by the syntactic validation
+			continue
+		}
+
+		m := proto.Clone(commit).(*pb.HareMessage)
+		if m.Message.Values == nil {
+			m.Message.Values = cert.Values
+		} else if !certified.Equals(NewSet(m.Message.Values)) {
+			return nil, false
+		}
+		aggMsgs.Messages = append(aggMsgs.Messages, m)
+	}
+
+	return aggMsgs, true
+}
+
 func validateCommitType(m *pb.HareMessage) bool {
 	return MessageType(m.Message.Type) == Commit
 }
@@ -7,7 +7,7 @@ import (
 )
 
 func defaultValidator() *MessageValidator {
-	return NewMessageValidator(NewMockSigning(), lowThresh10, lowDefaultSize)
+	return NewMessageValidator(NewMockSigning(), roundRoleOracle{}, lowThresh10, lowDefaultSize)
 }
 
 func TestMessageValidator_CommitStatus(t *testing.T) {
@@ -47,6 +47,15 @@ func TestMessageValidator_IsSyntacticallyValid(t *testing.T) {
 	m.Message = &pb.InnerMessage{}
 	assert.False(t, validator.isSyntacticallyValid(m))
 	m.Message.Values = NewEmptySet(validator.defaultSize).To2DSlice()
+	assert.False(t, validator.isSyntacticallyValid(m))
+	m.Message.InstanceId = instanceId1.Bytes()
+	assert.True(t, validator.isSyntacticallyValid(m))
+	m.Message.InstanceId = instanceId1.Bytes()[:4] // truncated ids are identified with padded ones
+	assert.False(t, validator.isSyntacticallyValid(m))
+	m.Message.InstanceId = instanceId1.Bytes()
+	m.Message.Values = [][]byte{value1.Bytes()[:4]}
+	assert.False(t, validator.isSyntacticallyValid(m))
+	m.Message.Values = [][]byte{value1.Bytes()}
 	assert.True(t, validator.isSyntacticallyValid(m))
 }
 
@@ -94,4 +103,12 @@ func TestConsensusProcess_isContextuallyValid(t *testing.T) {
 			cp.advanceToNextRound()
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestConsensusProcess_isContextuallyValidPreRound(t *testing.T) {
+	s := NewEmptySet(cfg.SetSize)
+	m := NewMessageBuilder().SetType(PreRound).SetInstanceId(*instanceId1).SetRoundCounter(0).SetValues(s).Build()
+	for k := uint32(0); k < 8; k++ {
+		assert.True(t, isContextuallyValid(m, k))
+	}
+}
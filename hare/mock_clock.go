@@ -0,0 +1,87 @@
+package hare
+
+import (
+	"sync"
+	"time"
+)
+
+// ManualClock is a clock which moves only when advanced so round transitions can be stepped by hand
+type ManualClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (clock *ManualClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return clock.now
+}
+
+func (clock *ManualClock) NewTicker(d time.Duration) Ticker {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	t := &manualTicker{c: make(chan time.Time), stop: make(chan struct{}), period: d, next: clock.now.Add(d)}
+	clock.tickers = append(clock.tickers, t)
+
+	return t
+}
+
+// Advance moves the clock forward by d and delivers the ticks which are due
+// It returns after every running ticker received its ticks, stopped tickers are skipped
+func (clock *ManualClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	clock.now = clock.now.Add(d)
+	now := clock.now
+	tickers := make([]*manualTicker, len(clock.tickers))
+	copy(tickers, clock.tickers)
+	clock.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, t := range tickers {
+		wg.Add(1)
+		go func(t *manualTicker) {
+			t.tickUntil(now)
+			wg.Done()
+		}(t)
+	}
+	wg.Wait()
+}
+
+type manualTicker struct {
+	mutex    sync.Mutex // serializes the deliveries of concurrent advances
+	c        chan time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+	period   time.Duration
+	next     time.Time
+}
+
+func (t *manualTicker) Chan() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTicker) Stop() {
+	t.stopOnce.Do(func() { close(t.stop) })
+}
+
+// tickUntil delivers a tick for every period which ended until now, unlike the real ticker no tick is dropped
+func (t *manualTicker) tickUntil(now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for !t.next.After(now) {
+		select {
+		case t.c <- t.next:
+			t.next = t.next.Add(t.period)
+		case <-t.stop:
+			return
+		}
+	}
+}
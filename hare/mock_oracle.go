@@ -2,20 +2,9 @@ package hare
 
 import (
 	"encoding/binary"
+	"github.com/spacemeshos/go-spacemesh/crypto"
 )
 
-type Role byte
-
-const (
-	Passive = Role(0)
-	Active  = Role(1)
-	Leader  = Role(2)
-)
-
-type Rolacle interface {
-	Role(sig Signature) Role
-}
-
 type MockOracle struct {
 	roles         map[uint32]Role
 	isLeaderTaken bool
@@ -28,8 +17,16 @@ func NewMockOracle() *MockOracle {
 	return mock
 }
 
-func (mockOracle *MockOracle) Role(proof Signature) Role {
-	if proof == nil {
+// Proof returns the round counter as the proof
+func (mockOracle *MockOracle) Proof(instanceId InstanceId, k uint32) (Signature, error) {
+	proof := make([]byte, 4)
+	binary.LittleEndian.PutUint32(proof, k)
+
+	return proof, nil
+}
+
+func (mockOracle *MockOracle) Role(pub crypto.PublicKey, instanceId InstanceId, k uint32, proof Signature) Role {
+	if len(proof) < 4 {
 		return Passive
 	}
 
@@ -44,4 +41,4 @@ func (mockOracle *MockOracle) Role(proof Signature) Role {
 	}
 
 	return Passive
-}
\ No newline at end of file
+}
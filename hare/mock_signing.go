@@ -1,11 +1,9 @@
 package hare
 
-import "bytes"
-
-type Signing interface {
-	Sign(m []byte) []byte
-	Validate(m []byte, sig []byte) bool
-}
+import (
+	"bytes"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+)
 
 type MockSigning struct {
 	sig []byte
@@ -19,6 +17,6 @@ func (mockSigning *MockSigning) Sign(m []byte) []byte {
 	return mockSigning.sig
 }
 
-func (mockSigning *MockSigning) Validate(m []byte, sig []byte) bool {
+func (mockSigning *MockSigning) Validate(pub crypto.PublicKey, m []byte, sig []byte) bool {
 	return bytes.Equal(mockSigning.sig, sig)
 }
@@ -42,6 +42,17 @@ func (nt *NotifyTracker) OnNotify(msg *pb.HareMessage) bool {
 	return false
 }
 
+// Exclude the notification of the sender
+func (nt *NotifyTracker) Exclude(pub string) {
+	msg, exist := nt.notifies[pub]
+	if !exist {
+		return
+	}
+
+	nt.tracker.Untrack(NewSet(msg.Message.Values))
+	delete(nt.notifies, pub)
+}
+
 func (nt *NotifyTracker) NotificationsCount(s *Set) int {
 	return int(nt.tracker.CountStatus(s))
 }
@@ -42,3 +42,15 @@ func TestNotifyTracker_NotificationsCount(t *testing.T) {
 	tracker.OnNotify(BuildNotifyMsg(generatePubKey(t), s))
 	assert.Equal(t, 2, tracker.NotificationsCount(s))
 }
+
+func TestNotifyTracker_Exclude(t *testing.T) {
+	s := NewEmptySet(lowDefaultSize)
+	s.Add(value1)
+	pubKey := generatePubKey(t)
+	tracker := NewNotifyTracker(lowDefaultSize)
+	tracker.OnNotify(BuildNotifyMsg(pubKey, s))
+	tracker.OnNotify(BuildNotifyMsg(generatePubKey(t), s))
+	assert.Equal(t, 2, tracker.NotificationsCount(s))
+	tracker.Exclude(pubKey.String())
+	assert.Equal(t, 1, tracker.NotificationsCount(s))
+}
@@ -0,0 +1,107 @@
+package hare
+
+import (
+	"encoding/binary"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/hare/config"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"math/big"
+)
+
+type Role byte
+
+const (
+	Passive = Role(0)
+	Active  = Role(1)
+	Leader  = Role(2)
+)
+
+// Rolacle decides the role of a party in a round of a consensus instance
+type Rolacle interface {
+	// Proof returns the proof of this party's role in round k of the instance
+	Proof(instanceId InstanceId, k uint32) (Signature, error)
+	// Role returns the role proven by the proof of the party with the public key, Passive if the proof is invalid
+	Role(pub crypto.PublicKey, instanceId InstanceId, k uint32, proof Signature) Role
+}
+
+// SeedProvider provides the random seed of a consensus instance
+type SeedProvider interface {
+	Seed(instanceId InstanceId) []byte
+}
+
+// FixedSeed derives the seed of every instance from a single network wide seed
+type FixedSeed []byte
+
+func (fs FixedSeed) Seed(instanceId InstanceId) []byte {
+	return crypto.Sha256(fs, instanceId.Bytes())
+}
+
+// VRFOracle is a Rolacle that draws roles with a verifiable random function keyed by the party's identity.
+// A party is eligible for a round if the VRF output of the instance seed and the round counter is below
+// a threshold, so that N parties are expected to be active in a round out of the active set of the instance,
+// and ExpectedLeaders parties are expected to lead a proposal round
+type VRFOracle struct {
+	key             crypto.PrivateKey
+	seeds           SeedProvider
+	activeSets      ActiveSetProvider
+	committeeSize   int
+	expectedLeaders int
+}
+
+func NewVRFOracle(key crypto.PrivateKey, seeds SeedProvider, activeSets ActiveSetProvider, cfg config.Config) *VRFOracle {
+	return &VRFOracle{key, seeds, activeSets, cfg.N, cfg.ExpectedLeaders}
+}
+
+func (oracle *VRFOracle) vrfMessage(instanceId InstanceId, k uint32) []byte {
+	kInBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(kInBytes, k)
+
+	return append(oracle.seeds.Seed(instanceId), kInBytes...)
+}
+
+func (oracle *VRFOracle) Proof(instanceId InstanceId, k uint32) (Signature, error) {
+	return crypto.VRFProve(oracle.key, oracle.vrfMessage(instanceId, k))
+}
+
+func (oracle *VRFOracle) Role(pub crypto.PublicKey, instanceId InstanceId, k uint32, proof Signature) Role {
+	if pub == nil || !crypto.VRFVerify(pub, oracle.vrfMessage(instanceId, k), proof) {
+		log.Warning("Role proof validation failed for round %v", k)
+		return Passive
+	}
+
+	output, err := crypto.VRFOutput(proof)
+	if err != nil {
+		return Passive
+	}
+
+	activeSetSize, err := oracle.activeSets.ActiveSetSize(instanceId)
+	if err != nil {
+		log.Warning("Could not get the active set of the instance: %v", err)
+		return Passive
+	}
+
+	if k%4 == Round2 {
+		if isEligible(output, oracle.expectedLeaders, activeSetSize) {
+			return Leader
+		}
+		return Passive
+	}
+
+	if isEligible(output, oracle.committeeSize, activeSetSize) {
+		return Active
+	}
+
+	return Passive
+}
+
+// isEligible returns true if output/2^256 < expected/activeSetSize
+func isEligible(output []byte, expected int, activeSetSize int) bool {
+	if expected >= activeSetSize {
+		return true
+	}
+
+	lhs := new(big.Int).Mul(new(big.Int).SetBytes(output), big.NewInt(int64(activeSetSize)))
+	rhs := new(big.Int).Lsh(big.NewInt(int64(expected)), uint(8*len(output)))
+
+	return lhs.Cmp(rhs) < 0
+}
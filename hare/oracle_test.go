@@ -0,0 +1,120 @@
+package hare
+
+import (
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testSeed = FixedSeed("test seed")
+
+func newTestVRFOracle(t *testing.T, n int, activeSetSize int) (*VRFOracle, crypto.PublicKey) {
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	conf := cfg
+	conf.N = n
+	return NewVRFOracle(priv, testSeed, FixedActiveSet(activeSetSize), conf), pub
+}
+
+func TestVRFOracle_ProofVerifiesByOthers(t *testing.T) {
+	oracle, pub := newTestVRFOracle(t, 10, 10)
+	other, _ := newTestVRFOracle(t, 10, 10)
+
+	proof, err := oracle.Proof(*instanceId1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, Active, other.Role(pub, *instanceId1, 0, proof))
+	assert.Equal(t, oracle.Role(pub, *instanceId1, 0, proof), other.Role(pub, *instanceId1, 0, proof))
+}
+
+func TestVRFOracle_InvalidProof(t *testing.T) {
+	oracle, pub := newTestVRFOracle(t, 10, 10)
+	_, otherPub := newTestVRFOracle(t, 10, 10)
+
+	proof, err := oracle.Proof(*instanceId1, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, Passive, oracle.Role(otherPub, *instanceId1, 4, proof), "proof accepted for another key")
+	assert.Equal(t, Passive, oracle.Role(pub, *instanceId1, 8, proof), "proof accepted for another round")
+	assert.Equal(t, Passive, oracle.Role(pub, *instanceId2, 4, proof), "proof accepted for another instance")
+	assert.Equal(t, Passive, oracle.Role(pub, *instanceId1, 4, nil))
+	assert.Equal(t, Passive, oracle.Role(nil, *instanceId1, 4, proof))
+}
+
+func TestVRFOracle_ExpectedCommitteeSize(t *testing.T) {
+	const parties = 50
+	const rounds = 4
+	active, leaders := 0, 0
+	for i := 0; i < parties; i++ {
+		oracle, pub := newTestVRFOracle(t, 10, parties)
+		for k := uint32(0); k < rounds; k++ {
+			proof, err := oracle.Proof(*instanceId1, k)
+			assert.NoError(t, err)
+			switch oracle.Role(pub, *instanceId1, k, proof) {
+			case Active:
+				active++
+			case Leader:
+				leaders++
+			}
+		}
+	}
+
+	// 3 committee rounds with an expected size of 10, one proposal round with an expected single leader
+	assert.True(t, active > 10 && active < 55, "unexpected number of active parties ", active)
+	assert.True(t, leaders < 8, "unexpected number of leaders ", leaders)
+}
+
+func TestVRFOracle_ActiveSetOfInstance(t *testing.T) {
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	conf := cfg
+	conf.N = 10
+	oracle := NewVRFOracle(priv, testSeed, layerActiveSets{1: 10, 2: 1000000}, conf)
+
+	// the whole active set of the first instance is in the committee
+	small := NewInstanceId(1)
+	proof, err := oracle.Proof(small, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, Active, oracle.Role(pub, small, 0, proof))
+
+	active := 0
+	large := NewInstanceId(2)
+	for k := uint32(0); k < 40; k += 4 {
+		proof, err := oracle.Proof(large, k)
+		assert.NoError(t, err)
+		if oracle.Role(pub, large, k, proof) == Active {
+			active++
+		}
+	}
+	assert.True(t, active < 5, "unexpected number of active rounds ", active)
+
+	// no role is given in an instance whose active set is unknown
+	unknown := NewInstanceId(3)
+	proof, err = oracle.Proof(unknown, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, Passive, oracle.Role(pub, unknown, 0, proof))
+}
+
+func TestConsensusProcess_handleMessageRoleProof(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	s := NewEmptySet(cfg.SetSize)
+	s.Add(value1)
+
+	oracle, pub := newTestVRFOracle(t, cfg.N, cfg.N)
+	sender, senderPub := newTestVRFOracle(t, cfg.N, cfg.N)
+	proc := NewConsensusProcess(cfg, pub, *instanceId1, *s, oracle, NewMockSigning(), n1, RealClock{}, make(chan TerminationOutput, 1))
+
+	proof, err := sender.Proof(*instanceId1, 0)
+	assert.NoError(t, err)
+
+	// proof of the sender but another public key
+	m := NewMessageBuilder().SetType(PreRound).SetRoundCounter(0).SetInstanceId(*instanceId1).SetPubKey(generatePubKey(t)).
+		SetValues(s).SetRoleProof(proof).Sign(proc.signing).Build()
+	proc.handleMessage(m)
+	assert.Equal(t, 0, len(proc.preRoundTracker.preRound))
+
+	m = NewMessageBuilder().SetType(PreRound).SetRoundCounter(0).SetInstanceId(*instanceId1).SetPubKey(senderPub).
+		SetValues(s).SetRoleProof(proof).Sign(proc.signing).Build()
+	proc.handleMessage(m)
+	assert.Equal(t, 1, len(proc.preRoundTracker.preRound))
+}
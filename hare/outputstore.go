@@ -0,0 +1,63 @@
+package hare
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/hare/pb"
+)
+
+// OutputStore persists the outputs of terminated consensus instances keyed by their instance id
+type OutputStore struct {
+	db database.DB
+}
+
+func NewOutputStore(db database.DB) *OutputStore {
+	return &OutputStore{db}
+}
+
+// Put stores the agreed set, the certificate and the termination round of the instance
+func (store *OutputStore) Put(instanceId InstanceId, out TerminationOutput) error {
+	output := &pb.InstanceOutput{}
+	output.InstanceId = instanceId.Bytes()
+	output.Values = out.Set().To2DSlice()
+	output.Cert = out.Certificate()
+	output.K = out.Round()
+
+	data, err := proto.Marshal(output)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Put(instanceId.Bytes(), data)
+}
+
+// Get returns the output of the instance, an error is returned if the instance has no output
+func (store *OutputStore) Get(instanceId InstanceId) (*pb.InstanceOutput, error) {
+	data, err := store.db.Get(instanceId.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	output := &pb.InstanceOutput{}
+	if err := proto.Unmarshal(data, output); err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// Has returns true if the instance terminated and its output was stored
+func (store *OutputStore) Has(instanceId InstanceId) bool {
+	_, err := store.db.Get(instanceId.Bytes())
+	return err == nil
+}
+
+// Set returns the agreed set of the instance
+func (store *OutputStore) Set(instanceId InstanceId) (*Set, error) {
+	output, err := store.Get(instanceId)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSet(output.Values), nil
+}
@@ -0,0 +1,46 @@
+package hare
+
+import (
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/hare/pb"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOutputStore_PutGet(t *testing.T) {
+	store := NewOutputStore(database.NewMemDatabase())
+	id := NewInstanceId(7)
+	assert.False(t, store.Has(id))
+	_, err := store.Get(id)
+	assert.Error(t, err)
+
+	s := NewEmptySet(lowDefaultSize)
+	s.Add(value1)
+	s.Add(value2)
+	cert := &pb.Certificate{Values: s.To2DSlice(), AggMsgs: &pb.AggregatedMessages{}}
+	assert.NoError(t, store.Put(id, procOutput{id.Id(), s, cert, 7}))
+
+	assert.True(t, store.Has(id))
+	assert.False(t, store.Has(NewInstanceId(8)))
+
+	out, err := store.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, id.Bytes(), out.InstanceId)
+	assert.Equal(t, uint32(7), out.K)
+	assert.True(t, s.Equals(NewSet(out.Cert.Values)))
+
+	stored, err := store.Set(id)
+	assert.NoError(t, err)
+	assert.True(t, s.Equals(stored))
+}
+
+func TestOutputStore_NoCertificate(t *testing.T) {
+	store := NewOutputStore(database.NewMemDatabase())
+	id := NewInstanceId(1)
+	assert.NoError(t, store.Put(id, procOutput{id.Id(), NewEmptySet(lowDefaultSize), nil, 3}))
+
+	out, err := store.Get(id)
+	assert.NoError(t, err)
+	assert.Nil(t, out.Cert)
+	assert.Equal(t, 0, len(out.Values))
+}
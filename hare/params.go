@@ -0,0 +1,61 @@
+package hare
+
+import (
+	"errors"
+	"github.com/spacemeshos/go-spacemesh/hare/config"
+)
+
+// ActiveSetProvider provides the number of active identities in the layer of a consensus instance
+type ActiveSetProvider interface {
+	ActiveSetSize(instanceId InstanceId) (int, error)
+}
+
+// FixedActiveSet is an active set of the same size in every layer
+type FixedActiveSet int
+
+func (size FixedActiveSet) ActiveSetSize(instanceId InstanceId) (int, error) {
+	return int(size), nil
+}
+
+// Params are the committee parameters of a single consensus instance
+type Params struct {
+	N       int // the expected number of active parties in a round
+	F       int // the number of dishonest parties tolerated
+	SetSize int // the max size of the set
+}
+
+// NewParams derives the parameters of an instance from the size of its active set.
+// The committees are expected to be of the configured size N unless the active set is smaller, in which case the
+// whole active set is the committee. The ratio of dishonest parties is kept as configured and a layer has at most
+// a block of every active identity
+func NewParams(cfg config.Config, activeSetSize int) (Params, error) {
+	if activeSetSize <= 0 {
+		return Params{}, errors.New("empty active set")
+	}
+
+	n := cfg.N
+	if activeSetSize < n {
+		n = activeSetSize
+	}
+
+	f := 0
+	if cfg.N > 0 {
+		f = n * cfg.F / cfg.N
+	}
+
+	setSize := cfg.SetSize
+	if activeSetSize < setSize {
+		setSize = activeSetSize
+	}
+
+	return Params{n, f, setSize}, nil
+}
+
+// Apply returns the configuration with the committee parameters of the instance
+func (params Params) Apply(cfg config.Config) config.Config {
+	cfg.N = params.N
+	cfg.F = params.F
+	cfg.SetSize = params.SetSize
+
+	return cfg
+}
@@ -0,0 +1,54 @@
+package hare
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// layerActiveSets provides the active sets of known layers
+type layerActiveSets map[uint32]int
+
+func (sets layerActiveSets) ActiveSetSize(instanceId InstanceId) (int, error) {
+	size, ok := sets[instanceId.Layer()]
+	if !ok {
+		return 0, errors.New("unknown layer")
+	}
+
+	return size, nil
+}
+
+func TestNewParams_LargeActiveSet(t *testing.T) {
+	params, err := NewParams(cfg, 10*cfg.N)
+	assert.NoError(t, err)
+	assert.Equal(t, Params{cfg.N, cfg.F, cfg.SetSize}, params)
+}
+
+func TestNewParams_SmallActiveSet(t *testing.T) {
+	conf := cfg
+	conf.N = 800
+	conf.F = 400
+	conf.SetSize = 200
+
+	params, err := NewParams(conf, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, Params{100, 50, 100}, params)
+
+	params, err = NewParams(conf, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Params{1, 0, 1}, params)
+}
+
+func TestNewParams_EmptyActiveSet(t *testing.T) {
+	_, err := NewParams(cfg, 0)
+	assert.Error(t, err)
+}
+
+func TestParams_Apply(t *testing.T) {
+	conf := Params{10, 3, 5}.Apply(cfg)
+	assert.Equal(t, 10, conf.N)
+	assert.Equal(t, 3, conf.F)
+	assert.Equal(t, 5, conf.SetSize)
+	assert.Equal(t, cfg.RoundDuration, conf.RoundDuration)
+	assert.Equal(t, cfg.LimitIterations, conf.LimitIterations)
+}
@@ -7,14 +7,14 @@ import (
 )
 
 type PreRoundTracker struct {
-	preRound  map[string]struct{} // maps PubKey->Pre-Round msg (unique)
-	tracker   *RefCountTracker    // keeps track of seen values
-	threshold uint32              // the threshold to prove a single value
+	preRound  map[string]*pb.HareMessage // maps PubKey->Pre-Round msg (unique)
+	tracker   *RefCountTracker           // keeps track of seen values
+	threshold uint32                     // the threshold to prove a single value
 }
 
 func NewPreRoundTracker(threshold int, expectedSize int) *PreRoundTracker {
 	pre := &PreRoundTracker{}
-	pre.preRound = make(map[string]struct{}, expectedSize)
+	pre.preRound = make(map[string]*pb.HareMessage, expectedSize)
 	pre.tracker = NewRefCountTracker(expectedSize)
 	pre.threshold = uint32(threshold)
 
@@ -39,7 +39,21 @@ func (pre *PreRoundTracker) OnPreRound(msg *pb.HareMessage) {
 		pre.tracker.Track(v)
 	}
 
-	pre.preRound[pub.String()] = struct{}{}
+	pre.preRound[pub.String()] = msg
+}
+
+// Exclude the values of the pre-round message of the sender from the tracking
+func (pre *PreRoundTracker) Exclude(pub string) {
+	msg, exist := pre.preRound[pub]
+	if !exist {
+		return
+	}
+
+	s := NewSet(msg.Message.Values)
+	for _, v := range s.values {
+		pre.tracker.Untrack(v)
+	}
+	delete(pre.preRound, pub)
 }
 
 func (pre *PreRoundTracker) CanProveValue(value Value) bool {
@@ -60,3 +60,16 @@ func TestPreRoundTracker_CanProveValueAndSet(t *testing.T) {
 	assert.True(t, tracker.CanProveValue(value2))
 	assert.True(t, tracker.CanProveSet(s))
 }
+
+func TestPreRoundTracker_Exclude(t *testing.T) {
+	s := NewEmptySet(lowDefaultSize)
+	s.Add(value1)
+	pubKey := generatePubKey(t)
+	tracker := NewPreRoundTracker(2, lowThresh10)
+	tracker.OnPreRound(BuildPreRoundMsg(pubKey, s))
+	tracker.OnPreRound(BuildPreRoundMsg(generatePubKey(t), s))
+	assert.True(t, tracker.CanProveSet(s))
+	tracker.Exclude(pubKey.String())
+	assert.False(t, tracker.CanProveSet(s))
+	assert.Equal(t, 1, len(tracker.preRound))
+}
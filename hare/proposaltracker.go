@@ -2,6 +2,7 @@ package hare
 
 import (
 	"bytes"
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/hare/pb"
 )
 
@@ -45,6 +46,10 @@ func (pt *ProposalTracker) OnProposal(msg *pb.HareMessage) {
 }
 
 func (pt *ProposalTracker) OnLateProposal(msg *pb.HareMessage) {
+	if pt.proposal == nil { // no proposal to conflict with
+		return
+	}
+
 	// if same sender then we should check for equivocation
 	if bytes.Equal(pt.proposal.PubKey, msg.PubKey) {
 		s := NewSet(msg.Message.Values)
@@ -61,6 +66,18 @@ func (pt *ProposalTracker) OnLateProposal(msg *pb.HareMessage) {
 	}
 }
 
+// Exclude marks the tracked proposal as conflicting if it was sent by pub
+func (pt *ProposalTracker) Exclude(pub string) {
+	if pt.proposal == nil {
+		return
+	}
+
+	p, err := crypto.NewPublicKey(pt.proposal.PubKey)
+	if err == nil && p.String() == pub {
+		pt.isConflicting = true
+	}
+}
+
 func (pt *ProposalTracker) IsConflicting() bool {
 	return pt.isConflicting
 }
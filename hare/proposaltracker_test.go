@@ -41,3 +41,14 @@ func TestProposalTracker_IsConflicting(t *testing.T) {
 		assert.False(t, tracker.IsConflicting())
 	}
 }
+
+func TestProposalTracker_OnLateProposalWithoutProposal(t *testing.T) {
+	s := NewEmptySet(lowDefaultSize)
+	s.Add(value1)
+	tracker := NewProposalTracker(lowThresh10)
+
+	// a late proposal when no proposal was received in time has nothing to conflict with
+	tracker.OnLateProposal(BuildProposalMsg(generatePubKey(t), s))
+	assert.False(t, tracker.IsConflicting())
+	assert.Nil(t, tracker.ProposedSet())
+}
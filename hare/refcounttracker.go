@@ -1,12 +1,14 @@
 package hare
 
+import "github.com/spacemeshos/go-spacemesh/common"
+
 type RefCountTracker struct {
-	table map[uint32]uint32
+	table map[common.Hash]uint32
 }
 
 func NewRefCountTracker(size int) *RefCountTracker {
 	t := &RefCountTracker{}
-	t.table = make(map[uint32]uint32, size)
+	t.table = make(map[common.Hash]uint32, size)
 
 	return t
 }
@@ -28,3 +30,17 @@ func (tracker *RefCountTracker) Track(id Identifiable) {
 
 	tracker.table[id.Id()]++
 }
+
+func (tracker *RefCountTracker) Untrack(id Identifiable) {
+	count, exist := tracker.table[id.Id()]
+	if !exist {
+		return
+	}
+
+	if count == 1 {
+		delete(tracker.table, id.Id())
+		return
+	}
+
+	tracker.table[id.Id()]--
+}
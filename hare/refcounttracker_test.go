@@ -1,6 +1,7 @@
 package hare
 
 import (
+	"github.com/spacemeshos/go-spacemesh/common"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -9,8 +10,8 @@ type MyInt struct {
 	val uint32
 }
 
-func (m MyInt) Id() uint32 {
-	return m.val
+func (m MyInt) Id() common.Hash {
+	return common.BytesToHash([]byte{byte(m.val)})
 }
 
 func TestRefCountTracker_Track(t *testing.T) {
@@ -30,3 +31,17 @@ func TestRefCountTracker_CountStatus(t *testing.T) {
 	tracker.Track(myInt)
 	assert.Equal(t, uint32(2), tracker.CountStatus(myInt))
 }
+
+func TestRefCountTracker_Untrack(t *testing.T) {
+	tracker := NewRefCountTracker(10)
+	myInt := MyInt{1}
+	tracker.Track(myInt)
+	tracker.Track(myInt)
+	tracker.Untrack(myInt)
+	assert.Equal(t, uint32(1), tracker.CountStatus(myInt))
+	tracker.Untrack(myInt)
+	assert.Equal(t, uint32(0), tracker.CountStatus(myInt))
+	assert.Equal(t, 0, len(tracker.table))
+	tracker.Untrack(myInt)
+	assert.Equal(t, uint32(0), tracker.CountStatus(myInt))
+}
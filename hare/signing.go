@@ -0,0 +1,46 @@
+package hare
+
+import (
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/log"
+)
+
+type Signing interface {
+	Sign(m []byte) []byte
+	// Validate returns true if sig is the signature of m by the owner of the public key
+	Validate(pub crypto.PublicKey, m []byte, sig []byte) bool
+}
+
+// KeySigning signs hare messages with the node's private key.
+// Messages are hashed before signing since the signature scheme only signs a digest
+type KeySigning struct {
+	key crypto.PrivateKey
+}
+
+func NewKeySigning(key crypto.PrivateKey) *KeySigning {
+	return &KeySigning{key}
+}
+
+func (ks *KeySigning) Sign(m []byte) []byte {
+	sig, err := ks.key.Sign(crypto.Sha256(m))
+	if err != nil {
+		log.Error("Could not sign message: %v", err)
+		return nil
+	}
+
+	return sig
+}
+
+func (ks *KeySigning) Validate(pub crypto.PublicKey, m []byte, sig []byte) bool {
+	if pub == nil || len(sig) == 0 {
+		return false
+	}
+
+	verified, err := pub.Verify(crypto.Sha256(m), sig)
+	if err != nil {
+		log.Warning("Could not verify signature: %v", err)
+		return false
+	}
+
+	return verified
+}
@@ -0,0 +1,88 @@
+package hare
+
+import (
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/hare/pb"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func generateKeySigning(t *testing.T) (*KeySigning, crypto.PublicKey) {
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	return NewKeySigning(priv), pub
+}
+
+func buildSignedStatus(signing Signing, pub crypto.PublicKey, s *Set) *pb.HareMessage {
+	return NewMessageBuilder().SetType(Status).SetInstanceId(*instanceId1).SetRoundCounter(0).SetKi(-1).
+		SetValues(s).SetPubKey(pub).Sign(signing).Build()
+}
+
+func TestKeySigning_SignValidate(t *testing.T) {
+	signing, pub := generateKeySigning(t)
+	_, otherPub := generateKeySigning(t)
+
+	msg := []byte("hare message")
+	sig := signing.Sign(msg)
+	assert.True(t, signing.Validate(pub, msg, sig))
+	assert.False(t, signing.Validate(otherPub, msg, sig))
+	assert.False(t, signing.Validate(pub, []byte("other message"), sig))
+	assert.False(t, signing.Validate(pub, msg, nil))
+	assert.False(t, signing.Validate(nil, msg, sig))
+}
+
+func TestMessageValidator_KeySigning(t *testing.T) {
+	signing, pub := generateKeySigning(t)
+	validator := NewMessageValidator(signing, roundRoleOracle{}, lowThresh10, lowDefaultSize)
+	s := NewEmptySet(lowDefaultSize)
+	s.Add(value1)
+
+	assert.True(t, validator.ValidateMessage(buildSignedStatus(signing, pub, s), 0))
+}
+
+func TestMessageValidator_TamperedMessage(t *testing.T) {
+	signing, pub := generateKeySigning(t)
+	validator := NewMessageValidator(signing, roundRoleOracle{}, lowThresh10, lowDefaultSize)
+	s := NewEmptySet(lowDefaultSize)
+	s.Add(value1)
+
+	m := buildSignedStatus(signing, pub, s)
+	m.Message.Values = append(m.Message.Values, value2.Bytes())
+	assert.False(t, validator.ValidateMessage(m, 0), "tampered values were accepted")
+
+	m = buildSignedStatus(signing, pub, s)
+	m.Message.Ki = 3
+	assert.False(t, validator.ValidateMessage(m, 0), "tampered ki was accepted")
+
+	m = buildSignedStatus(signing, pub, s)
+	m.Message.InstanceId = instanceId2.Bytes()
+	assert.False(t, validator.ValidateMessage(m, 0), "tampered instance id was accepted")
+}
+
+func TestMessageValidator_SwappedKeys(t *testing.T) {
+	signing, _ := generateKeySigning(t)
+	_, otherPub := generateKeySigning(t)
+	validator := NewMessageValidator(signing, roundRoleOracle{}, lowThresh10, lowDefaultSize)
+	s := NewEmptySet(lowDefaultSize)
+	s.Add(value1)
+
+	// signed by one key but claims to be sent by another
+	assert.False(t, validator.ValidateMessage(buildSignedStatus(signing, otherPub, s), 0))
+}
+
+func TestMessageValidator_AggregatedInvalidSignature(t *testing.T) {
+	signing, _ := generateKeySigning(t)
+	validator := NewMessageValidator(signing, roundRoleOracle{}, lowThresh10, lowDefaultSize)
+
+	msgs := make([]*pb.HareMessage, validator.threshold)
+	for i := 0; i < validator.threshold; i++ {
+		senderSigning, senderPub := generateKeySigning(t)
+		msgs[i] = buildSignedStatus(senderSigning, senderPub, NewEmptySet(lowDefaultSize))
+	}
+	agg := &pb.AggregatedMessages{Messages: msgs}
+	validators := []func(m *pb.HareMessage) bool{validateStatusType}
+	assert.True(t, validator.validateAggregatedMessage(agg, validators))
+
+	msgs[0].Message.Ki = 5
+	assert.False(t, validator.validateAggregatedMessage(agg, validators))
+}
@@ -0,0 +1,470 @@
+package hare
+
+import (
+	"crypto/rand"
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/hare/config"
+	"github.com/spacemeshos/go-spacemesh/hare/pb"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// the behaviours of byzantine parties in the simulation
+type byzantineBehaviour int
+
+const (
+	silent               byzantineBehaviour = iota // sends nothing
+	equivocating                                   // sends two different messages in every round
+	invalidSVP                                     // proposes with an SVP that doesn't prove the proposed set
+	fakeRoleProof                                  // sends messages with role proofs it can't produce
+	conflictingProposals                           // leads with two valid proposals of different sets
+)
+
+func (b byzantineBehaviour) String() string {
+	switch b {
+	case silent:
+		return "silent"
+	case equivocating:
+		return "equivocating"
+	case invalidSVP:
+		return "invalidSVP"
+	case fakeRoleProof:
+		return "fakeRoleProof"
+	case conflictingProposals:
+		return "conflictingProposals"
+	default:
+		return "unknown"
+	}
+}
+
+// simInbox forwards the messages dispatched to a process one at a time, so that the simulation knows when the
+// process received all of them, and records the statuses received so that byzantine processes can use them
+type simInbox struct {
+	*ConsensusProcess
+	hs       *hareSimulation
+	inbox    chan *pb.HareMessage
+	procIn   chan *pb.HareMessage // the unbuffered inbox of the process
+	holding  int32                // a message was taken from the inbox but not yet received by the process
+	mutex    sync.Mutex
+	statuses []*pb.HareMessage
+}
+
+func (in *simInbox) createInbox(size uint32) chan *pb.HareMessage {
+	in.inbox = make(chan *pb.HareMessage, size)
+	in.procIn = in.ConsensusProcess.createInbox(0) // unbuffered so the process takes messages only when it's idle
+	go func() {
+		for {
+			select {
+			case m := <-in.inbox:
+				atomic.StoreInt32(&in.holding, 1)
+				if m.Message != nil && MessageType(m.Message.Type) == Status {
+					in.mutex.Lock()
+					in.statuses = append(in.statuses, m)
+					in.mutex.Unlock()
+				}
+				select {
+				case in.procIn <- m:
+				case <-in.CloseChannel():
+					return
+				}
+				atomic.AddInt64(&in.hs.activity, 1)
+				atomic.StoreInt32(&in.holding, 0)
+			case <-in.CloseChannel():
+				return
+			}
+		}
+	}()
+
+	return in.inbox
+}
+
+// idle returns true if the process received all the messages dispatched to it or it was closed
+func (in *simInbox) idle() bool {
+	select {
+	case <-in.CloseChannel():
+		return true
+	default:
+	}
+
+	return len(in.inbox) == 0 && atomic.LoadInt32(&in.holding) == 0
+}
+
+// probe returns once the process is idle, the empty message it receives is dropped as invalid
+func (in *simInbox) probe() {
+	select {
+	case in.procIn <- &pb.HareMessage{}:
+	case <-in.CloseChannel():
+	}
+}
+
+// statusesOf returns the status messages received in the iteration
+func (in *simInbox) statusesOf(iteration uint32) []*pb.HareMessage {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+	statuses := make([]*pb.HareMessage, 0, len(in.statuses))
+	for _, m := range in.statuses {
+		if iterationFromCounter(m.Message.K) == iteration {
+			statuses = append(statuses, m)
+		}
+	}
+
+	return statuses
+}
+
+// simNetwork tracks the broadcasts in progress
+type simNetwork struct {
+	NetworkService
+	hs *hareSimulation
+}
+
+func (sn *simNetwork) Broadcast(protocol string, payload []byte) error {
+	atomic.AddInt64(&sn.hs.activity, 1)
+	atomic.AddInt32(&sn.hs.broadcasting, 1)
+	defer atomic.AddInt32(&sn.hs.broadcasting, -1)
+
+	return sn.NetworkService.Broadcast(protocol, payload)
+}
+
+// byzantineNetwork tampers the hare messages broadcast by a byzantine process according to its behaviour
+type byzantineNetwork struct {
+	NetworkService
+	behaviour byzantineBehaviour
+	signing   Signing
+	threshold int
+	inbox     *simInbox
+}
+
+func (bn *byzantineNetwork) Broadcast(protocol string, payload []byte) error {
+	if protocol != ProtoName {
+		return bn.NetworkService.Broadcast(protocol, payload)
+	}
+
+	msg := &pb.HareMessage{}
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return err
+	}
+
+	switch bn.behaviour {
+	case silent:
+		return nil
+	case equivocating:
+		alt := proto.Clone(msg).(*pb.HareMessage)
+		alt.Message.Values = append(alt.Message.Values, Value{Bytes32{9}}.Bytes())
+		bn.send(msg)
+		bn.send(alt)
+	case invalidSVP:
+		if MessageType(msg.Message.Type) == Proposal && len(msg.Message.Svp.Messages) > 1 {
+			msg.Message.Svp.Messages = msg.Message.Svp.Messages[1:]
+		}
+		bn.send(msg)
+	case fakeRoleProof:
+		proof := make([]byte, crypto.VRFProofLen)
+		rand.Read(proof)
+		msg.Message.RoleProof = proof
+		bn.send(msg)
+	case conflictingProposals:
+		bn.send(msg)
+		if MessageType(msg.Message.Type) == Proposal {
+			if alt := bn.conflictingProposal(msg); alt != nil {
+				bn.send(alt)
+			}
+		}
+	}
+
+	return nil
+}
+
+// conflictingProposal builds a valid proposal of another set from the statuses received, nil if there's no such set
+func (bn *byzantineNetwork) conflictingProposal(msg *pb.HareMessage) *pb.HareMessage {
+	proposed := NewSet(msg.Message.Values)
+	statuses := bn.inbox.statusesOf(iterationFromCounter(msg.Message.K))
+	for _, candidate := range statuses {
+		bound := NewSet(candidate.Message.Values)
+
+		// the statuses whose sets are contained in the candidate set prove a set other than the proposed one
+		svp := &pb.AggregatedMessages{}
+		union := NewEmptySet(len(bound.values))
+		senders := make(map[string]bool)
+		for _, m := range statuses {
+			if len(svp.Messages) == bn.threshold || senders[string(m.PubKey)] || !isSubset(NewSet(m.Message.Values), bound) {
+				continue
+			}
+			senders[string(m.PubKey)] = true
+			svp.Messages = append(svp.Messages, m)
+			for _, v := range NewSet(m.Message.Values).values {
+				union.Add(v)
+			}
+		}
+
+		if len(svp.Messages) == bn.threshold && !union.Equals(proposed) {
+			alt := proto.Clone(msg).(*pb.HareMessage)
+			alt.Message.Values = union.To2DSlice()
+			alt.Message.Svp = svp
+			return alt
+		}
+	}
+
+	return nil
+}
+
+func isSubset(s *Set, g *Set) bool {
+	for _, v := range s.values {
+		if !g.Contains(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// send signs the inner message again after it was tampered
+func (bn *byzantineNetwork) send(msg *pb.HareMessage) {
+	inner, err := proto.Marshal(msg.Message)
+	if err != nil {
+		return
+	}
+	msg.InnerSig = bn.signing.Sign(inner)
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return
+	}
+	bn.NetworkService.Broadcast(ProtoName, data)
+}
+
+// a party of the simulation
+type simParty struct {
+	proc    *ConsensusProcess
+	broker  *Broker
+	inbox   *simInbox
+	outputs chan TerminationOutput
+}
+
+// hareSimulation runs a consensus instance by honest and byzantine parties over the simulated network
+// The rounds are ended by a manual clock once all the messages of the round were handled
+type hareSimulation struct {
+	t            *testing.T
+	cfg          config.Config
+	sim          *service.Simulator
+	clock        *ManualClock
+	seed         FixedSeed
+	honest       []*simParty
+	byzantine    []*simParty
+	inputs       []*Set // the initial sets of the honest parties
+	activity     int64  // counts the broadcasts and deliveries of messages
+	broadcasting int32  // the number of broadcasts in progress
+}
+
+func newHareSimulation(t *testing.T, cfg config.Config) *hareSimulation {
+	return &hareSimulation{t: t, cfg: cfg, sim: service.NewSimulator(), clock: NewManualClock(time.Now()), seed: FixedSeed("hare simulation")}
+}
+
+func (hs *hareSimulation) newParty(input *Set, network func(n NetworkService, signing Signing) NetworkService) *simParty {
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.NoError(hs.t, err)
+
+	n := hs.sim.NewNode()
+	signing := NewKeySigning(priv)
+	party := &simParty{}
+	oracle := NewVRFOracle(priv, hs.seed, FixedActiveSet(hs.cfg.N), hs.cfg) // every party is active
+	party.broker = NewBroker(n, NewMessageValidator(signing, oracle, hs.cfg.F+1, hs.cfg.N))
+	assert.NoError(hs.t, party.broker.Start())
+
+	party.outputs = make(chan TerminationOutput, 1)
+	party.proc = NewConsensusProcess(hs.cfg, pub, *instanceId1, *input, oracle, signing, network(&simNetwork{n, hs}, signing), hs.clock, party.outputs)
+	party.inbox = &simInbox{ConsensusProcess: party.proc, hs: hs}
+	party.broker.Register(party.inbox)
+
+	return party
+}
+
+// addHonest adds an honest party with the initial set
+func (hs *hareSimulation) addHonest(input *Set) {
+	party := hs.newParty(input, func(n NetworkService, signing Signing) NetworkService { return n })
+	hs.honest = append(hs.honest, party)
+	hs.inputs = append(hs.inputs, NewSet(input.To2DSlice())) // the process updates its set
+}
+
+// addByzantine adds a byzantine party with the initial set and behaviour
+func (hs *hareSimulation) addByzantine(input *Set, behaviour byzantineBehaviour) {
+	bn := &byzantineNetwork{behaviour: behaviour, threshold: hs.cfg.F + 1}
+	party := hs.newParty(input, func(n NetworkService, signing Signing) NetworkService {
+		bn.NetworkService = n
+		bn.signing = signing
+		return bn
+	})
+	bn.inbox = party.inbox
+	hs.byzantine = append(hs.byzantine, party)
+}
+
+func (hs *hareSimulation) parties() []*simParty {
+	return append(append([]*simParty{}, hs.honest...), hs.byzantine...)
+}
+
+// idle returns true if no message is being broadcast or waits to be received by a process
+func (hs *hareSimulation) idle() bool {
+	if atomic.LoadInt32(&hs.broadcasting) != 0 {
+		return false
+	}
+
+	for _, party := range hs.parties() {
+		if !party.inbox.idle() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// settle waits until all the messages sent so far were handled, i.e. no process became busy since all of them were idle
+func (hs *hareSimulation) settle() {
+	for {
+		activity := atomic.LoadInt64(&hs.activity)
+		for _, party := range hs.parties() {
+			party.inbox.probe()
+		}
+
+		// the broker dispatches a message shortly after it was broadcast
+		time.Sleep(2 * time.Millisecond)
+		if hs.idle() && activity == atomic.LoadInt64(&hs.activity) {
+			return
+		}
+	}
+}
+
+// run starts all parties, steps through the pre-round and the rounds of the allowed iterations
+// and returns the outputs of the honest parties which terminated
+func (hs *hareSimulation) run() []*Set {
+	parties := hs.parties()
+	for _, party := range parties {
+		assert.NoError(hs.t, party.proc.Start())
+	}
+	defer func() {
+		for _, party := range parties {
+			party.proc.Close()
+			party.broker.Close()
+		}
+	}()
+
+	results := make([]*Set, 0, len(hs.honest))
+	terminated := make([]bool, len(hs.honest))
+	for round := 0; round <= 4*hs.cfg.LimitIterations && len(results) < len(hs.honest); round++ {
+		hs.settle()
+		hs.clock.Advance(hs.cfg.RoundDuration)
+		hs.settle()
+
+		for i, party := range hs.honest {
+			if terminated[i] {
+				continue
+			}
+			select {
+			case out := <-party.outputs:
+				terminated[i] = true
+				results = append(results, out.Set())
+			default:
+			}
+		}
+	}
+
+	return results
+}
+
+// verify checks that all honest parties terminated and the agreement and validity of their outputs
+func (hs *hareSimulation) verify(results []*Set) {
+	assert.Equal(hs.t, len(hs.honest), len(results), "not all honest parties terminated")
+
+	union := NewEmptySet(hs.cfg.SetSize)
+	sameInput := true
+	for _, input := range hs.inputs {
+		for _, v := range input.values {
+			union.Add(v)
+		}
+		sameInput = sameInput && input.Equals(hs.inputs[0])
+	}
+
+	for _, s := range results {
+		assert.True(hs.t, results[0].Equals(s), "honest parties disagree: %v %v", results[0], s)
+		assert.True(hs.t, isSubset(s, union), "output contains values no honest party started with: %v", s)
+		if sameInput {
+			assert.True(hs.t, hs.inputs[0].Equals(s), "output differs from the common input: %v", s)
+		}
+	}
+}
+
+func simulationConfig(n int, f int) config.Config {
+	conf := config.DefaultConfig()
+	conf.N = n
+	conf.F = f
+	conf.LimitIterations = 6
+	conf.ExpectedLeaders = 3
+
+	return conf
+}
+
+func simulationSet(values ...byte) *Set {
+	s := NewEmptySet(len(values))
+	for _, v := range values {
+		s.Add(Value{Bytes32{v}})
+	}
+
+	return s
+}
+
+func TestHareSimulation_Honest(t *testing.T) {
+	hs := newHareSimulation(t, simulationConfig(10, 3))
+	for i := 0; i < 10; i++ {
+		hs.addHonest(simulationSet(1, 2, 3))
+	}
+	hs.verify(hs.run())
+}
+
+func TestHareSimulation_HonestDifferentInputs(t *testing.T) {
+	hs := newHareSimulation(t, simulationConfig(10, 3))
+	for i := 0; i < 10; i++ {
+		switch i % 3 {
+		case 0:
+			hs.addHonest(simulationSet(1, 2, 3))
+		case 1:
+			hs.addHonest(simulationSet(1, 2, 4))
+		default:
+			hs.addHonest(simulationSet(1, 5))
+		}
+	}
+	hs.verify(hs.run())
+}
+
+func TestHareSimulation_Byzantine(t *testing.T) {
+	behaviours := []byzantineBehaviour{silent, equivocating, invalidSVP, fakeRoleProof, conflictingProposals}
+	for _, behaviour := range behaviours {
+		t.Run(behaviour.String(), func(t *testing.T) {
+			hs := newHareSimulation(t, simulationConfig(10, 3))
+			for i := 0; i < 7; i++ {
+				if i%2 == 0 {
+					hs.addHonest(simulationSet(1, 2, 3))
+				} else {
+					hs.addHonest(simulationSet(1, 2, 4))
+				}
+			}
+			for i := 0; i < 3; i++ {
+				hs.addByzantine(simulationSet(1, 6, 7), behaviour)
+			}
+			hs.verify(hs.run())
+		})
+	}
+}
+
+func TestHareSimulation_MixedByzantine(t *testing.T) {
+	hs := newHareSimulation(t, simulationConfig(10, 3))
+	for i := 0; i < 7; i++ {
+		hs.addHonest(simulationSet(1, 2, 3))
+	}
+	hs.addByzantine(simulationSet(1, 2), equivocating)
+	hs.addByzantine(simulationSet(3, 4), conflictingProposals)
+	hs.addByzantine(simulationSet(5), invalidSVP)
+	hs.verify(hs.run())
+}
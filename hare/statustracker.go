@@ -50,6 +50,23 @@ func (st *StatusTracker) RecordStatus(msg *pb.HareMessage) {
 	st.statuses[pub.String()] = msg
 }
 
+// Exclude the status message of the sender, the max ki and its set are recalculated
+func (st *StatusTracker) Exclude(pub string) {
+	if _, exist := st.statuses[pub]; !exist {
+		return
+	}
+
+	delete(st.statuses, pub)
+	st.maxKi = -1
+	st.maxRawSet = nil
+	for _, m := range st.statuses {
+		if m.Message.Ki >= st.maxKi {
+			st.maxKi = m.Message.Ki
+			st.maxRawSet = m.Message.Values
+		}
+	}
+}
+
 func (st *StatusTracker) IsSVPReady() bool {
 	return len(st.statuses) == st.threshold
 }
@@ -0,0 +1,38 @@
+package hare
+
+import (
+	"bytes"
+	"github.com/spacemeshos/go-spacemesh/hare/pb"
+	"github.com/spacemeshos/go-spacemesh/log"
+)
+
+const TerminationProtoName = "HARE_TERMINATION"
+
+// ValidateTermination verifies the certificate of the termination message was built for the announced instance
+func (validator *MessageValidator) ValidateTermination(msg *pb.TerminationMessage) bool {
+	if msg == nil {
+		log.Warning("Termination validation failed: nil identified")
+		return false
+	}
+
+	if !validator.validateCertificate(msg.Cert) {
+		log.Warning("Termination validation failed: invalid certificate")
+		return false
+	}
+
+	// the commits of a valid certificate are of the same instance
+	if !bytes.Equal(msg.Cert.AggMsgs.Messages[0].Message.InstanceId, msg.InstanceId) {
+		log.Warning("Termination validation failed: certificate of a different instance")
+		return false
+	}
+
+	return true
+}
+
+// terminationOutput returns the output proved by a valid termination message
+func terminationOutput(msg *pb.TerminationMessage) TerminationOutput {
+	instanceId := InstanceId{NewBytes32(msg.InstanceId)}
+	k := msg.Cert.AggMsgs.Messages[0].Message.K
+
+	return procOutput{instanceId.Id(), NewSet(msg.Cert.Values), msg.Cert, k}
+}
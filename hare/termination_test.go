@@ -0,0 +1,263 @@
+package hare
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/hare/pb"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type mockMessage struct {
+	data []byte
+}
+
+func (mm *mockMessage) Sender() node.Node {
+	return node.EmptyNode
+}
+
+func (mm *mockMessage) Bytes() []byte {
+	return mm.data
+}
+
+// recordingNetwork records the termination messages broadcast by the hare
+type recordingNetwork struct {
+	NetworkService
+	terminations chan []byte
+}
+
+func (rn *recordingNetwork) Broadcast(protocol string, payload []byte) error {
+	if protocol == TerminationProtoName {
+		rn.terminations <- payload
+	}
+	return rn.NetworkService.Broadcast(protocol, payload)
+}
+
+// buildCertificate returns a certificate of the set signed by count parties as built on the end of round 3
+func buildCertificate(t *testing.T, instanceId InstanceId, s *Set, count int) *pb.Certificate {
+	tracker := NewCommitTracker(count, count, s)
+	for i := 0; i < count; i++ {
+		signing, pub := generateKeySigning(t)
+		m := NewMessageBuilder().SetType(Commit).SetInstanceId(instanceId).SetRoundCounter(Round3).SetKi(ki).
+			SetValues(s).SetPubKey(pub).Sign(signing).Build()
+		tracker.OnCommit(m)
+	}
+
+	return tracker.BuildCertificate()
+}
+
+func TestMessageValidator_ValidateTermination(t *testing.T) {
+	signing, _ := generateKeySigning(t)
+	validator := NewMessageValidator(signing, roundRoleOracle{}, lowThresh10, lowDefaultSize)
+	s := NewEmptySet(lowDefaultSize)
+	s.Add(value1)
+	s.Add(value2)
+
+	assert.False(t, validator.ValidateTermination(nil))
+	assert.False(t, validator.ValidateTermination(&pb.TerminationMessage{InstanceId: instanceId1.Bytes()}))
+
+	cert := buildCertificate(t, *instanceId1, s, validator.threshold)
+	msg := &pb.TerminationMessage{InstanceId: instanceId1.Bytes(), Cert: cert}
+	assert.True(t, validator.ValidateTermination(msg))
+
+	// the stripped values of the commits are not modified by the validation
+	for _, m := range cert.AggMsgs.Messages {
+		assert.Nil(t, m.Message.Values)
+	}
+
+	// certificate of another instance
+	assert.False(t, validator.ValidateTermination(&pb.TerminationMessage{InstanceId: instanceId2.Bytes(), Cert: cert}))
+
+	// certified set differs from the committed set
+	other := NewEmptySet(lowDefaultSize)
+	other.Add(value1)
+	forged := proto.Clone(cert).(*pb.Certificate)
+	forged.Values = other.To2DSlice()
+	assert.False(t, validator.ValidateTermination(&pb.TerminationMessage{InstanceId: instanceId1.Bytes(), Cert: forged}))
+
+	// not enough commits
+	short := buildCertificate(t, *instanceId1, s, validator.threshold-1)
+	assert.False(t, validator.ValidateTermination(&pb.TerminationMessage{InstanceId: instanceId1.Bytes(), Cert: short}))
+
+	// commits signed by keys which aren't active in the committing round
+	for _, role := range []Role{Passive, Leader} {
+		validator := NewMessageValidator(signing, fixedRoleOracle{role}, lowThresh10, lowDefaultSize)
+		assert.False(t, validator.ValidateTermination(msg), "certificate of parties with role %v", role)
+	}
+}
+
+func buildTermination(t *testing.T, layer mesh.LayerID, s *Set, count int) []byte {
+	instanceId := NewInstanceId(uint32(layer))
+	data, err := proto.Marshal(&pb.TerminationMessage{InstanceId: instanceId.Bytes(), Cert: buildCertificate(t, instanceId, s, count)})
+	assert.NoError(t, err)
+	return data
+}
+
+func layerSet(layers *mockMesh) *Set {
+	s := NewEmptySet(cfg.SetSize)
+	for id := range layers.blocks {
+		s.Add(blockIdAsValue(id))
+	}
+	return s
+}
+
+func TestHare_LateNodeLearnsFromCertificate(t *testing.T) {
+	conf := cfg
+	conf.F = 2
+	layers := newMockMesh(1, 3)
+	h, ticks := newKeyedTestHare(t, conf, layers)
+	defer h.Close()
+
+	ticks <- 1
+	proc := h.instance(1)
+	assert.NotNil(t, proc)
+
+	agreed := layerSet(layers)
+	h.terminations <- &mockMessage{buildTermination(t, 1, agreed, conf.F+1)}
+
+	select {
+	case layer := <-layers.added:
+		assert.Equal(t, mesh.LayerID(1), layer.Index())
+		assert.Equal(t, 3, len(layer.Blocks()))
+	case <-time.After(5 * time.Second):
+		t.Fatal("certified set was not handed to the mesh")
+	}
+	<-proc.CloseChannel()
+	assert.Equal(t, 0, h.runningInstances())
+
+	out, err := h.Output(1)
+	assert.NoError(t, err)
+	assert.True(t, agreed.Equals(NewSet(out.Values)))
+	assert.Equal(t, uint32(Round3), out.K)
+}
+
+func TestHare_CertificateOfUpcomingLayer(t *testing.T) {
+	conf := cfg
+	conf.F = 2
+	layers := newMockMesh(2, 2)
+	h, ticks := newKeyedTestHare(t, conf, layers)
+	defer h.Close()
+
+	h.terminations <- &mockMessage{buildTermination(t, 2, layerSet(layers), conf.F+1)}
+	timeout := time.After(5 * time.Second)
+	for !h.outputs.Has(NewInstanceId(2)) {
+		select {
+		case <-timeout:
+			t.Fatal("certified set was not stored")
+		default:
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// the layer is added when it begins, without running the rounds
+	ticks <- 2
+	select {
+	case layer := <-layers.added:
+		assert.Equal(t, mesh.LayerID(2), layer.Index())
+	case <-time.After(5 * time.Second):
+		t.Fatal("certified set was not handed to the mesh")
+	}
+	assert.Nil(t, h.instance(2))
+}
+
+func TestHare_InvalidCertificateIgnored(t *testing.T) {
+	conf := cfg
+	conf.F = 2
+	layers := newMockMesh(1, 2)
+	h, _ := newKeyedTestHare(t, conf, layers)
+	defer h.Close()
+
+	h.onTermination(&pb.TerminationMessage{InstanceId: NewInstanceId(1).Bytes(),
+		Cert: buildCertificate(t, NewInstanceId(1), layerSet(layers), conf.F)})
+	assert.False(t, h.outputs.Has(NewInstanceId(1)))
+}
+
+func TestHare_IneligibleCertificateIgnored(t *testing.T) {
+	conf := cfg
+	conf.F = 2
+	layers := newMockMesh(1, 2)
+	signing, pub := generateKeySigning(t)
+	h := New(conf, pub, service.NewSimulator().NewNode(), signing, fixedRoleOracle{Passive}, FixedActiveSet(conf.N),
+		layers, NewOutputStore(database.NewMemDatabase()), make(chan mesh.LayerID))
+
+	// anyone can sign f+1 commits with fresh keys, they aren't in the committee of the instance
+	h.onTermination(&pb.TerminationMessage{InstanceId: NewInstanceId(1).Bytes(),
+		Cert: buildCertificate(t, NewInstanceId(1), layerSet(layers), conf.F+1)})
+	assert.False(t, h.outputs.Has(NewInstanceId(1)))
+}
+
+func TestHare_CertificateOfInstanceParams(t *testing.T) {
+	layers := newMockMesh(1, 2)
+	signing, pub := generateKeySigning(t)
+	h := New(cfg, pub, service.NewSimulator().NewNode(), signing, roundRoleOracle{}, layerActiveSets{1: 10, 2: 10 * cfg.N},
+		layers, NewOutputStore(database.NewMemDatabase()), make(chan mesh.LayerID))
+	assert.NoError(t, h.Start())
+	defer h.Close()
+
+	// the threshold of an instance with a small active set
+	small, err := NewParams(cfg, 10)
+	assert.NoError(t, err)
+	for _, layer := range []uint32{1, 2} {
+		h.onTermination(&pb.TerminationMessage{InstanceId: NewInstanceId(layer).Bytes(),
+			Cert: buildCertificate(t, NewInstanceId(layer), layerSet(layers), small.F+1)})
+	}
+
+	assert.True(t, h.outputs.Has(NewInstanceId(1)))
+	assert.False(t, h.outputs.Has(NewInstanceId(2)))
+}
+
+func TestHare_AnnounceTermination(t *testing.T) {
+	conf := cfg
+	conf.F = 2
+	layers := newMockMesh(1, 2)
+	signing, pub := generateKeySigning(t)
+	network := &recordingNetwork{service.NewSimulator().NewNode(), make(chan []byte, 1)}
+	ticks := make(chan mesh.LayerID)
+	h := New(conf, pub, network, signing, roundRoleOracle{}, FixedActiveSet(conf.N), layers, NewOutputStore(database.NewMemDatabase()), ticks)
+	assert.NoError(t, h.Start())
+	defer h.Close()
+
+	ticks <- 1
+	proc := h.instance(1)
+	assert.NotNil(t, proc)
+
+	agreed := layerSet(layers)
+	cert := buildCertificate(t, NewInstanceId(1), agreed, conf.F+1)
+	h.outputChan <- procOutput{proc.Id(), agreed, cert, 2}
+
+	select {
+	case data := <-network.terminations:
+		termination := &pb.TerminationMessage{}
+		assert.NoError(t, proto.Unmarshal(data, termination))
+		assert.Equal(t, NewInstanceId(1).Bytes(), termination.InstanceId)
+		validator, err := h.instanceValidator(NewInstanceId(1))
+		assert.NoError(t, err)
+		assert.True(t, validator.ValidateTermination(termination))
+	case <-time.After(5 * time.Second):
+		t.Fatal("termination was not announced")
+	}
+}
+
+func TestHare_TerminationLoopStopsOnClosedChannel(t *testing.T) {
+	h := New(cfg, generatePubKey(t), service.NewSimulator().NewNode(), NewMockSigning(), roundRoleOracle{},
+		FixedActiveSet(cfg.N), newMockMesh(1, 3), NewOutputStore(database.NewMemDatabase()), make(chan mesh.LayerID))
+	terminations := make(chan service.Message)
+	h.terminations = terminations
+	close(terminations)
+
+	stopped := make(chan struct{})
+	go func() {
+		h.terminationLoop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("termination loop didn't stop when the channel was closed")
+	}
+}
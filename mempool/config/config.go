@@ -0,0 +1,35 @@
+package config
+
+import "time"
+
+const (
+	defaultSize             = 4096
+	defaultPriceBump        = 10
+	defaultMaxNonceGap      = 16
+	defaultMaxInvalidRelays = 10
+	defaultThrottleDuration = time.Minute
+)
+
+// Config defines the mempool params
+type Config struct {
+	Size      int `mapstructure:"mempool-size"`       // the max number of pending transactions
+	PriceBump int `mapstructure:"mempool-price-bump"` // the min price increase in percent to replace a pending transaction
+
+	MaxNonceGap uint64 `mapstructure:"mempool-max-nonce-gap"` // the max number of nonces a transaction is ahead of its sender's state nonce
+
+	MaxInvalidRelays int           `mapstructure:"max-invalid-relays"` // the number of invalid transactions a peer relays before it is throttled
+	ThrottleDuration time.Duration `mapstructure:"throttle-duration"`  // the time the transactions of a throttled peer are dropped
+}
+
+// DefaultConfig defines the default mempool params
+func DefaultConfig() Config {
+	return Config{
+		Size:      defaultSize,
+		PriceBump: defaultPriceBump,
+
+		MaxNonceGap: defaultMaxNonceGap,
+
+		MaxInvalidRelays: defaultMaxInvalidRelays,
+		ThrottleDuration: defaultThrottleDuration,
+	}
+}
@@ -0,0 +1,183 @@
+package mempool
+
+import (
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mempool/config"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/rlp"
+	"github.com/spacemeshos/go-spacemesh/state"
+	"sync"
+	"time"
+)
+
+const TxProtocol = "newTx"
+
+// the number of recently seen transaction hashes kept for deduplication
+const seenCacheSize = 10000
+
+// Network is the gossip network the transactions are propagated over
+type Network interface {
+	RegisterGossipProtocol(protocol string) chan service.GossipMessage
+	Broadcast(protocol string, payload []byte) error
+}
+
+// peerRecord tracks the invalid transactions relayed by a peer
+type peerRecord struct {
+	invalid        int
+	throttledUntil time.Time
+}
+
+// TxGossip propagates transactions over the gossip network. Gossiped transactions are validated and added to the
+// pool before they are relayed, a transaction is handled once by its hash. A peer which relays too many invalid
+// transactions is throttled, its transactions are dropped without validation for a while
+type TxGossip struct {
+	conf    config.Config
+	pool    *Pool
+	network Network
+	txs     chan service.GossipMessage
+	now     func() time.Time
+
+	mutex     sync.Mutex
+	seen      map[common.Hash]struct{}
+	seenOrder []common.Hash
+	peers     map[string]*peerRecord
+
+	exit chan struct{}
+}
+
+func NewTxGossip(conf config.Config, pool *Pool, network Network) *TxGossip {
+	return &TxGossip{
+		conf:    conf,
+		pool:    pool,
+		network: network,
+		txs:     network.RegisterGossipProtocol(TxProtocol),
+		now:     time.Now,
+		seen:    make(map[common.Hash]struct{}),
+		peers:   make(map[string]*peerRecord),
+		exit:    make(chan struct{}),
+	}
+}
+
+func (tg *TxGossip) Start() {
+	go tg.run()
+}
+
+func (tg *TxGossip) Close() {
+	close(tg.exit)
+}
+
+func (tg *TxGossip) run() {
+	for {
+		select {
+		case <-tg.exit:
+			return
+		case msg := <-tg.txs:
+			tg.handleTx(msg)
+		}
+	}
+}
+
+// BroadcastTransaction adds a local transaction to the pool and gossips it to the network
+func (tg *TxGossip) BroadcastTransaction(tx *state.Transaction) error {
+	payload, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return err
+	}
+
+	if err := tg.pool.Add(tx); err != nil {
+		return err
+	}
+	tg.markSeen(tx.Hash())
+
+	return tg.network.Broadcast(TxProtocol, payload)
+}
+
+// handleTx validates a gossiped transaction and adds it to the pool. The validation result is reported back so only
+// new transactions accepted by the pool are relayed
+func (tg *TxGossip) handleTx(msg service.GossipMessage) {
+	if msg == nil {
+		log.Error("got nil gossip transaction message")
+		return
+	}
+
+	// the relaying peer is blamed for the invalid transactions, the sender is the author of the gossip message
+	peer := msg.RelayedBy().String()
+	if tg.isThrottled(peer) {
+		log.Debug("dropped gossip transaction of throttled peer %v", peer)
+		msg.ReportValidation(false)
+		return
+	}
+
+	tx := &state.Transaction{}
+	if err := rlp.DecodeBytes(msg.Bytes(), tx); err != nil {
+		log.Error("could not decode gossip transaction %v", err)
+		tg.reportInvalid(peer)
+		msg.ReportValidation(false)
+		return
+	}
+
+	if !tg.markSeen(tx.Hash()) {
+		msg.ReportValidation(false)
+		return
+	}
+
+	err := tg.pool.Add(tx)
+	switch err {
+	case nil:
+		msg.ReportValidation(true)
+	case ErrSignature, ErrGasLimit, ErrRecipient:
+		log.Error("gossip transaction %x is invalid: %v", tx.Hash(), err)
+		tg.reportInvalid(peer)
+		msg.ReportValidation(false)
+	default:
+		// the transaction may be valid on the state of the peer or was outbid, it isn't relayed but the peer isn't blamed
+		log.Debug("gossip transaction %x was not added: %v", tx.Hash(), err)
+		msg.ReportValidation(false)
+	}
+}
+
+// markSeen records the hash, it returns false if the hash was already seen
+func (tg *TxGossip) markSeen(hash common.Hash) bool {
+	tg.mutex.Lock()
+	defer tg.mutex.Unlock()
+
+	if _, ok := tg.seen[hash]; ok {
+		return false
+	}
+
+	if len(tg.seenOrder) >= seenCacheSize {
+		delete(tg.seen, tg.seenOrder[0])
+		tg.seenOrder = tg.seenOrder[1:]
+	}
+	tg.seen[hash] = struct{}{}
+	tg.seenOrder = append(tg.seenOrder, hash)
+	return true
+}
+
+func (tg *TxGossip) isThrottled(peer string) bool {
+	tg.mutex.Lock()
+	defer tg.mutex.Unlock()
+
+	record, ok := tg.peers[peer]
+	return ok && tg.now().Before(record.throttledUntil)
+}
+
+// reportInvalid counts an invalid transaction relayed by the peer and throttles it when it relayed the max allowed
+func (tg *TxGossip) reportInvalid(peer string) {
+	tg.mutex.Lock()
+	defer tg.mutex.Unlock()
+
+	record, ok := tg.peers[peer]
+	if !ok {
+		record = &peerRecord{}
+		tg.peers[peer] = record
+	}
+
+	record.invalid++
+	if record.invalid >= tg.conf.MaxInvalidRelays {
+		log.Info("throttling peer %v for %v after %v invalid transactions", peer, tg.conf.ThrottleDuration, record.invalid)
+		record.invalid = 0
+		record.throttledUntil = tg.now().Add(tg.conf.ThrottleDuration)
+	}
+}
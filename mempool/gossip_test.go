@@ -0,0 +1,197 @@
+package mempool
+
+import (
+	"github.com/spacemeshos/go-spacemesh/mempool/config"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/rlp"
+	"github.com/spacemeshos/go-spacemesh/state"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// mockGossipMessage records the validation reported for it
+type mockGossipMessage struct {
+	sender    node.Node
+	relayedBy node.Node
+	data      []byte
+	reported  []bool
+}
+
+func (mgm *mockGossipMessage) Sender() node.Node {
+	return mgm.sender
+}
+
+func (mgm *mockGossipMessage) RelayedBy() node.Node {
+	return mgm.relayedBy
+}
+
+func (mgm *mockGossipMessage) Bytes() []byte {
+	return mgm.data
+}
+
+func (mgm *mockGossipMessage) ReportValidation(isValid bool) {
+	mgm.reported = append(mgm.reported, isValid)
+}
+
+func encodeTx(t *testing.T, tx *state.Transaction) []byte {
+	data, err := rlp.EncodeToBytes(tx)
+	assert.NoError(t, err)
+	return data
+}
+
+func gossipTx(tg *TxGossip, sender node.Node, data []byte) []bool {
+	return relayTx(tg, sender, sender, data)
+}
+
+func relayTx(tg *TxGossip, sender node.Node, relayedBy node.Node, data []byte) []bool {
+	msg := &mockGossipMessage{sender: sender, relayedBy: relayedBy, data: data}
+	tg.handleTx(msg)
+	return msg.reported
+}
+
+func testGossipConfig() config.Config {
+	conf := config.DefaultConfig()
+	conf.MaxInvalidRelays = 2
+	conf.ThrottleDuration = time.Minute
+	return conf
+}
+
+func TestTxGossip_Validation(t *testing.T) {
+	st := newState(t)
+	acc := newAccount(t, st, 100)
+	conf := testGossipConfig()
+	pool := New(conf, testGas, st)
+	tg := NewTxGossip(conf, pool, service.NewSimulator().NewNode())
+	peer := node.GenerateRandomNodeData()
+
+	tx := acc.tx(t, 0, 10, 1)
+	assert.Equal(t, []bool{true}, gossipTx(tg, peer, encodeTx(t, tx)))
+	received := pool.Get(tx.Hash())
+	assert.NotNil(t, received)
+	origin, err := received.Origin()
+	assert.NoError(t, err)
+	assert.Equal(t, acc.addr, origin)
+
+	// a transaction is handled once
+	assert.Equal(t, []bool{false}, gossipTx(tg, node.GenerateRandomNodeData(), encodeTx(t, tx)))
+
+	// not accepted by the pool
+	assert.Equal(t, []bool{false}, gossipTx(tg, peer, encodeTx(t, acc.tx(t, 1, 100, 1))))
+	assert.Equal(t, []bool{false}, gossipTx(tg, peer, []byte{0x01, 0x02}))
+	assert.Equal(t, 1, pool.Len())
+}
+
+func TestTxGossip_ThrottleInvalidRelays(t *testing.T) {
+	st := newState(t)
+	acc := newAccount(t, st, 100)
+	conf := testGossipConfig()
+	pool := New(conf, testGas, st)
+	tg := NewTxGossip(conf, pool, service.NewSimulator().NewNode())
+	now := time.Now()
+	tg.now = func() time.Time { return now }
+	bad, good := node.GenerateRandomNodeData(), node.GenerateRandomNodeData()
+
+	// an insufficient balance isn't blamed on the relaying peer
+	assert.Equal(t, []bool{false}, gossipTx(tg, bad, encodeTx(t, acc.tx(t, 0, 100, 1))))
+	unsigned := acc.tx(t, 0, 10, 1)
+	unsigned.Signature = nil
+	assert.Equal(t, []bool{false}, gossipTx(tg, bad, encodeTx(t, unsigned)))
+	assert.Equal(t, []bool{false}, gossipTx(tg, bad, []byte{0x01, 0x02}))
+
+	// the valid transactions of the throttled peer are dropped
+	tx1 := acc.tx(t, 0, 10, 1)
+	assert.Equal(t, []bool{false}, gossipTx(tg, bad, encodeTx(t, tx1)))
+	assert.Nil(t, pool.Get(tx1.Hash()))
+	assert.Equal(t, []bool{true}, gossipTx(tg, good, encodeTx(t, tx1)))
+
+	now = now.Add(conf.ThrottleDuration)
+	tx2 := acc.tx(t, 1, 10, 1)
+	assert.Equal(t, []bool{true}, gossipTx(tg, bad, encodeTx(t, tx2)))
+	assert.Equal(t, 2, pool.Len())
+}
+
+func TestTxGossip_ThrottleRelayingPeer(t *testing.T) {
+	st := newState(t)
+	acc := newAccount(t, st, 100)
+	conf := testGossipConfig()
+	pool := New(conf, testGas, st)
+	tg := NewTxGossip(conf, pool, service.NewSimulator().NewNode())
+	author, relayer := node.GenerateRandomNodeData(), node.GenerateRandomNodeData()
+
+	// the relaying peer is throttled for the invalid transactions it relays, not the author of the messages
+	for i := 0; i < conf.MaxInvalidRelays; i++ {
+		unsigned := acc.tx(t, uint64(i), 10, 1)
+		unsigned.Signature = nil
+		assert.Equal(t, []bool{false}, relayTx(tg, author, relayer, encodeTx(t, unsigned)))
+	}
+	assert.True(t, tg.isThrottled(relayer.String()))
+	assert.False(t, tg.isThrottled(author.String()))
+
+	tx := acc.tx(t, 0, 10, 1)
+	assert.Equal(t, []bool{false}, relayTx(tg, author, relayer, encodeTx(t, tx)))
+	assert.Nil(t, pool.Get(tx.Hash()))
+	assert.Equal(t, []bool{true}, relayTx(tg, author, node.GenerateRandomNodeData(), encodeTx(t, tx)))
+}
+
+func TestTxGossip_MissingRecipient(t *testing.T) {
+	st := newState(t)
+	acc := newAccount(t, st, 100)
+	conf := testGossipConfig()
+	pool := New(conf, testGas, st)
+	tg := NewTxGossip(conf, pool, service.NewSimulator().NewNode())
+	peer := node.GenerateRandomNodeData()
+
+	// a transaction without a recipient can't be applied, it isn't relayed and is blamed on the relaying peer
+	for nonce := uint64(0); nonce < uint64(conf.MaxInvalidRelays); nonce++ {
+		tx := &state.Transaction{AccountNonce: nonce, Amount: big.NewInt(10), Price: big.NewInt(1), GasLimit: testGas}
+		assert.NoError(t, state.SignTransaction(tx, acc.key))
+		assert.Equal(t, []bool{false}, gossipTx(tg, peer, encodeTx(t, tx)))
+		assert.Nil(t, pool.Get(tx.Hash()))
+	}
+
+	assert.Equal(t, []bool{false}, gossipTx(tg, peer, encodeTx(t, acc.tx(t, 0, 10, 1))))
+	assert.Equal(t, 0, pool.Len())
+}
+
+func TestTxGossip_Propagation(t *testing.T) {
+	sim := service.NewSimulator()
+	conf := testGossipConfig()
+	origin := newAccount(t, newState(t), 0)
+	pools := make([]*Pool, 3)
+	gossips := make([]*TxGossip, 3)
+	for i := range gossips {
+		st := newState(t)
+		st.AddBalance(origin.addr, big.NewInt(100))
+		pools[i] = New(conf, testGas, st)
+		gossips[i] = NewTxGossip(conf, pools[i], sim.NewNode())
+		gossips[i].Start()
+		defer gossips[i].Close()
+	}
+
+	tx := origin.tx(t, 0, 10, 1)
+	assert.NoError(t, gossips[0].BroadcastTransaction(tx))
+	assert.Equal(t, ErrKnown, gossips[0].BroadcastTransaction(tx))
+
+	timeout := time.After(5 * time.Second)
+	for _, pool := range pools {
+		for pool.Get(tx.Hash()) == nil {
+			select {
+			case <-timeout:
+				t.Fatal("transaction was not propagated")
+			default:
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+
+	// an invalid local transaction isn't broadcast
+	invalid := origin.tx(t, 1, 10, 1)
+	invalid.GasLimit = 0
+	assert.Equal(t, ErrGasLimit, gossips[0].BroadcastTransaction(invalid))
+	for _, pool := range pools {
+		assert.Equal(t, 1, pool.Len())
+	}
+}
@@ -0,0 +1,283 @@
+// Package mempool holds the pending transactions between their arrival and their inclusion in a layer
+package mempool
+
+import (
+	"bytes"
+	"errors"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mempool/config"
+	"github.com/spacemeshos/go-spacemesh/state"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrSignature   = errors.New("invalid signature")
+	ErrKnown       = errors.New("known transaction")
+	ErrNonce       = errors.New("nonce too low")
+	ErrNonceGap    = errors.New("nonce too far ahead")
+	ErrGasLimit    = errors.New("gas limit too low")
+	ErrRecipient   = errors.New("missing recipient")
+	ErrFunds       = errors.New("insufficient funds")
+	ErrUnderpriced = errors.New("replacement transaction underpriced")
+	ErrPoolFull    = errors.New("mempool is full")
+)
+
+// State is the global state the pending transactions are validated against
+type State interface {
+	GetBalance(addr common.Address) *big.Int
+	GetNonce(addr common.Address) uint64
+}
+
+// Pool holds the valid pending transactions ordered per sender by their nonces.
+// A pending transaction of a sender is replaced by a transaction of the same nonce which pays a higher enough price
+type Pool struct {
+	mutex   sync.Mutex
+	conf    config.Config
+	gas     uint64
+	state   State
+	senders map[common.Address]map[uint64]*state.Transaction // the pending transactions of each sender by nonce
+	all     map[common.Hash]*state.Transaction
+}
+
+// New returns an empty pool which validates the transactions against the state. The gas is the gas charged
+// for applying a transaction
+func New(conf config.Config, gas uint64, st State) *Pool {
+	return &Pool{
+		conf:    conf,
+		gas:     gas,
+		state:   st,
+		senders: make(map[common.Address]map[uint64]*state.Transaction),
+		all:     make(map[common.Hash]*state.Transaction),
+	}
+}
+
+// Len returns the number of pending transactions
+func (pool *Pool) Len() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return len(pool.all)
+}
+
+// Get returns the pending transaction of the hash or nil if it isn't pending
+func (pool *Pool) Get(hash common.Hash) *state.Transaction {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.all[hash]
+}
+
+// validate checks the transaction can be applied on the current state once the pending transactions of its sender
+// with lower nonces were applied, needs to be called under mutex lock
+func (pool *Pool) validate(tx *state.Transaction) (common.Address, error) {
+	origin, err := tx.Origin()
+	if err != nil {
+		return origin, ErrSignature
+	}
+
+	if _, ok := pool.all[tx.Hash()]; ok {
+		return origin, ErrKnown
+	}
+
+	if tx.AccountNonce < pool.state.GetNonce(origin) {
+		return origin, ErrNonce
+	}
+
+	// a transaction far ahead of the state nonce can't be applied for a long while and would only take the pool's space
+	if tx.AccountNonce > pool.state.GetNonce(origin)+pool.conf.MaxNonceGap {
+		return origin, ErrNonceGap
+	}
+
+	if tx.GasLimit < pool.gas {
+		return origin, ErrGasLimit
+	}
+
+	if tx.Recipient == nil {
+		return origin, ErrRecipient
+	}
+
+	// the transaction is paid after the pending transactions of the sender with lower nonces
+	cost := tx.Cost(pool.gas)
+	for nonce, pending := range pool.senders[origin] {
+		if nonce >= pool.state.GetNonce(origin) && nonce < tx.AccountNonce {
+			cost.Add(cost, pending.Cost(pool.gas))
+		}
+	}
+	if pool.state.GetBalance(origin).Cmp(cost) <= 0 {
+		return origin, ErrFunds
+	}
+
+	return origin, nil
+}
+
+// Add validates the transaction and adds it to the pool. A pending transaction of the same sender and nonce is replaced
+// if the price of the transaction is higher by the configured price bump. When the pool is full the cheapest
+// last transaction of a sender is evicted, unless the transaction doesn't pay more than it. The pending transactions
+// of the sender which the balance no longer pays for after the transaction are dropped
+func (pool *Pool) Add(tx *state.Transaction) error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	origin, err := pool.validate(tx)
+	if err != nil {
+		return err
+	}
+
+	if old, ok := pool.senders[origin][tx.AccountNonce]; ok {
+		if !pool.replaces(tx, old) {
+			return ErrUnderpriced
+		}
+		pool.remove(origin, old)
+		pool.insert(origin, tx)
+		log.Debug("replaced pending transaction %x by %x", old.Hash(), tx.Hash())
+		// a more expensive replacement may leave the following transactions of the sender unpaid
+		pool.prune(origin)
+		return nil
+	}
+
+	if len(pool.all) >= pool.conf.Size {
+		evicted := pool.cheapest(origin, tx.AccountNonce)
+		if evicted == nil || price(tx).Cmp(price(evicted)) <= 0 {
+			return ErrPoolFull
+		}
+		evictedOrigin, _ := evicted.Origin()
+		pool.remove(evictedOrigin, evicted)
+		log.Debug("evicted pending transaction %x", evicted.Hash())
+	}
+
+	pool.insert(origin, tx)
+	pool.prune(origin)
+	return nil
+}
+
+// replaces returns true if the transaction pays enough to replace the pending transaction
+func (pool *Pool) replaces(tx, old *state.Transaction) bool {
+	// price * 100 >= old price * (100 + bump) and price > old price
+	bumped := new(big.Int).Mul(price(old), big.NewInt(int64(100+pool.conf.PriceBump)))
+	return price(tx).Cmp(price(old)) > 0 && new(big.Int).Mul(price(tx), big.NewInt(100)).Cmp(bumped) >= 0
+}
+
+// cheapest returns the lowest priced transaction out of the last pending transactions of each sender, evicting the
+// last transaction of a sender leaves the rest of its transactions executable. The last transaction of the origin
+// isn't evicted if it precedes the nonce of the added transaction, which would leave a nonce gap before it
+func (pool *Pool) cheapest(origin common.Address, nonce uint64) *state.Transaction {
+	var cheapest *state.Transaction
+	for sender, txs := range pool.senders {
+		last := txs[lastNonce(txs)]
+		if sender == origin && last.AccountNonce < nonce {
+			continue
+		}
+		if cheapest == nil || price(last).Cmp(price(cheapest)) < 0 ||
+			(price(last).Cmp(price(cheapest)) == 0 && bytes.Compare(last.Hash().Bytes(), cheapest.Hash().Bytes()) < 0) {
+			cheapest = last
+		}
+	}
+	return cheapest
+}
+
+func (pool *Pool) insert(origin common.Address, tx *state.Transaction) {
+	if _, ok := pool.senders[origin]; !ok {
+		pool.senders[origin] = make(map[uint64]*state.Transaction)
+	}
+	pool.senders[origin][tx.AccountNonce] = tx
+	pool.all[tx.Hash()] = tx
+}
+
+func (pool *Pool) remove(origin common.Address, tx *state.Transaction) {
+	delete(pool.all, tx.Hash())
+	delete(pool.senders[origin], tx.AccountNonce)
+	if len(pool.senders[origin]) == 0 {
+		delete(pool.senders, origin)
+	}
+}
+
+// Pending returns the transactions which can be applied on the current state, for each sender the transactions
+// from its current nonce without gaps ordered by nonce. The senders are ordered by address
+func (pool *Pool) Pending() state.Transactions {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	origins := make([]common.Address, 0, len(pool.senders))
+	for origin := range pool.senders {
+		origins = append(origins, origin)
+	}
+	sort.Slice(origins, func(i, j int) bool {
+		return bytes.Compare(origins[i].Bytes(), origins[j].Bytes()) < 0
+	})
+
+	pending := make(state.Transactions, 0, len(pool.all))
+	for _, origin := range origins {
+		txs := pool.senders[origin]
+		for nonce := pool.state.GetNonce(origin); ; nonce++ {
+			tx, ok := txs[nonce]
+			if !ok {
+				break
+			}
+			pending = append(pending, tx)
+		}
+	}
+	return pending
+}
+
+// Applied removes the transactions applied in a layer, then drops the pending transactions which became stale
+// on the updated state: the transactions with used nonces or whose running cost exceeds the balance of their sender
+func (pool *Pool) Applied(txs state.Transactions) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for _, tx := range txs {
+		if pending, ok := pool.all[tx.Hash()]; ok {
+			origin, _ := pending.Origin()
+			pool.remove(origin, pending)
+		}
+	}
+
+	for origin := range pool.senders {
+		pool.prune(origin)
+	}
+}
+
+// prune drops the pending transactions of the sender with used nonces and the transactions from the first one whose
+// running cost, the sum of its cost and the costs of the transactions with lower nonces, exceeds the balance of the
+// sender. Needs to be called under mutex lock
+func (pool *Pool) prune(origin common.Address) {
+	pending := pool.senders[origin]
+	nonces := make([]uint64, 0, len(pending))
+	for nonce := range pending {
+		nonces = append(nonces, nonce)
+	}
+	sort.Slice(nonces, func(i, j int) bool { return nonces[i] < nonces[j] })
+
+	next := pool.state.GetNonce(origin)
+	balance := pool.state.GetBalance(origin)
+	cost := new(big.Int)
+	for _, nonce := range nonces {
+		tx := pending[nonce]
+		if nonce < next {
+			pool.remove(origin, tx)
+			continue
+		}
+		cost.Add(cost, tx.Cost(pool.gas))
+		if balance.Cmp(cost) <= 0 {
+			pool.remove(origin, tx)
+		}
+	}
+}
+
+func price(tx *state.Transaction) *big.Int {
+	if tx.Price == nil {
+		return new(big.Int)
+	}
+	return tx.Price
+}
+
+func lastNonce(txs map[uint64]*state.Transaction) uint64 {
+	var last uint64
+	for nonce := range txs {
+		if nonce > last {
+			last = nonce
+		}
+	}
+	return last
+}
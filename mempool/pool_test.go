@@ -0,0 +1,242 @@
+package mempool
+
+import (
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/mempool/config"
+	"github.com/spacemeshos/go-spacemesh/state"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+const testGas = 2
+
+var recipient = common.BytesToAddress([]byte{0x01})
+
+type account struct {
+	key  crypto.PrivateKey
+	addr common.Address
+}
+
+func newState(t *testing.T) *state.StateDB {
+	st, err := state.New(common.Hash{}, state.NewDatabase(database.NewMemDatabase()))
+	assert.NoError(t, err)
+	return st
+}
+
+func newAccount(t *testing.T, st *state.StateDB, balance int64) *account {
+	key, pub, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	addr := state.PublicKeyToAddress(pub)
+	st.AddBalance(addr, big.NewInt(balance))
+	return &account{key, addr}
+}
+
+func (acc *account) tx(t *testing.T, nonce uint64, amount int64, price int64) *state.Transaction {
+	tx := &state.Transaction{AccountNonce: nonce, Recipient: &recipient, Amount: big.NewInt(amount),
+		Price: big.NewInt(price), GasLimit: testGas}
+	assert.NoError(t, state.SignTransaction(tx, acc.key))
+	return tx
+}
+
+func newTestPool(st State, size int) *Pool {
+	return New(config.Config{Size: size, PriceBump: 10, MaxNonceGap: 16}, testGas, st)
+}
+
+func TestPool_Add(t *testing.T) {
+	st := newState(t)
+	acc := newAccount(t, st, 100)
+	st.SetNonce(acc.addr, 3)
+	pool := newTestPool(st, 10)
+
+	tx := acc.tx(t, 3, 10, 1)
+	assert.NoError(t, pool.Add(tx))
+	assert.Equal(t, ErrKnown, pool.Add(tx))
+	assert.Equal(t, tx, pool.Get(tx.Hash()))
+
+	assert.Equal(t, ErrNonce, pool.Add(acc.tx(t, 2, 10, 1)))
+	assert.Equal(t, ErrFunds, pool.Add(acc.tx(t, 4, 90, 5)))
+
+	low := acc.tx(t, 4, 10, 1)
+	low.GasLimit = testGas - 1
+	assert.NoError(t, state.SignTransaction(low, acc.key))
+	assert.Equal(t, ErrGasLimit, pool.Add(low))
+
+	unsigned := &state.Transaction{AccountNonce: 4, Recipient: &recipient, Amount: big.NewInt(1), Price: big.NewInt(1),
+		GasLimit: testGas}
+	assert.Equal(t, ErrSignature, pool.Add(unsigned))
+
+	missing := &state.Transaction{AccountNonce: 4, Amount: big.NewInt(1), Price: big.NewInt(1), GasLimit: testGas}
+	assert.NoError(t, state.SignTransaction(missing, acc.key))
+	assert.Equal(t, ErrRecipient, pool.Add(missing))
+
+	// the origin account doesn't have a balance
+	other := newAccount(t, st, 0)
+	assert.Equal(t, ErrFunds, pool.Add(other.tx(t, 0, 1, 1)))
+
+	assert.Equal(t, 1, pool.Len())
+}
+
+func TestPool_NonceGap(t *testing.T) {
+	st := newState(t)
+	acc := newAccount(t, st, 100)
+	st.SetNonce(acc.addr, 3)
+	pool := New(config.Config{Size: 10, PriceBump: 10, MaxNonceGap: 2}, testGas, st)
+
+	assert.NoError(t, pool.Add(acc.tx(t, 5, 1, 1)))
+	assert.Equal(t, ErrNonceGap, pool.Add(acc.tx(t, 6, 1, 1)))
+
+	// the gap is counted from the state nonce, not from the pending transactions
+	assert.NoError(t, pool.Add(acc.tx(t, 3, 1, 1)))
+	assert.NoError(t, pool.Add(acc.tx(t, 4, 1, 1)))
+	assert.Equal(t, ErrNonceGap, pool.Add(acc.tx(t, 6, 1, 1)))
+
+	st.SetNonce(acc.addr, 4)
+	assert.NoError(t, pool.Add(acc.tx(t, 6, 1, 1)))
+}
+
+func TestPool_Pending(t *testing.T) {
+	st := newState(t)
+	acc1 := newAccount(t, st, 100)
+	acc2 := newAccount(t, st, 100)
+	st.SetNonce(acc2.addr, 5)
+	pool := newTestPool(st, 10)
+
+	txs := []*state.Transaction{acc1.tx(t, 2, 1, 1), acc1.tx(t, 0, 1, 1), acc1.tx(t, 1, 1, 1),
+		acc2.tx(t, 5, 1, 1), acc2.tx(t, 7, 1, 1)}
+	for _, tx := range txs {
+		assert.NoError(t, pool.Add(tx))
+	}
+
+	pending := pool.Pending()
+	// the transaction after the nonce gap isn't pending
+	assert.Equal(t, 4, len(pending))
+	byOrigin := make(map[common.Address][]uint64)
+	for _, tx := range pending {
+		origin, err := tx.Origin()
+		assert.NoError(t, err)
+		byOrigin[origin] = append(byOrigin[origin], tx.AccountNonce)
+	}
+	assert.Equal(t, []uint64{0, 1, 2}, byOrigin[acc1.addr])
+	assert.Equal(t, []uint64{5}, byOrigin[acc2.addr])
+	assert.Equal(t, pending, pool.Pending())
+}
+
+func TestPool_Replacement(t *testing.T) {
+	st := newState(t)
+	acc := newAccount(t, st, 1000)
+	pool := newTestPool(st, 10)
+
+	old := acc.tx(t, 0, 10, 10)
+	assert.NoError(t, pool.Add(old))
+
+	// a higher price which isn't bumped enough
+	assert.Equal(t, ErrUnderpriced, pool.Add(acc.tx(t, 0, 11, 10)))
+	assert.Equal(t, ErrUnderpriced, pool.Add(acc.tx(t, 0, 10, 9)))
+	assert.Equal(t, ErrUnderpriced, pool.Add(acc.tx(t, 0, 12, 10)))
+
+	replacement := acc.tx(t, 0, 20, 11)
+	assert.NoError(t, pool.Add(replacement))
+	assert.Equal(t, 1, pool.Len())
+	assert.Nil(t, pool.Get(old.Hash()))
+	assert.Equal(t, state.Transactions{replacement}, pool.Pending())
+}
+
+func TestPool_Eviction(t *testing.T) {
+	st := newState(t)
+	acc1 := newAccount(t, st, 1000)
+	acc2 := newAccount(t, st, 1000)
+	acc3 := newAccount(t, st, 1000)
+	pool := newTestPool(st, 3)
+
+	first := acc1.tx(t, 0, 1, 1)
+	evicted := acc2.tx(t, 0, 1, 2)
+	assert.NoError(t, pool.Add(first))
+	assert.NoError(t, pool.Add(acc1.tx(t, 1, 1, 3)))
+	assert.NoError(t, pool.Add(evicted))
+
+	// doesn't pay more than the cheapest last transaction of a sender
+	assert.Equal(t, ErrPoolFull, pool.Add(acc3.tx(t, 0, 1, 2)))
+
+	// the first transaction of a sender is kept although it is cheaper, evicting it would leave a nonce gap
+	assert.NoError(t, pool.Add(acc3.tx(t, 0, 1, 4)))
+	assert.Equal(t, 3, pool.Len())
+	assert.Nil(t, pool.Get(evicted.Hash()))
+	assert.Equal(t, first, pool.Get(first.Hash()))
+
+	// a replacement is accepted when the pool is full
+	assert.NoError(t, pool.Add(acc1.tx(t, 0, 1, 5)))
+	assert.Equal(t, 3, pool.Len())
+}
+
+func TestPool_Applied(t *testing.T) {
+	st := newState(t)
+	acc1 := newAccount(t, st, 100)
+	acc2 := newAccount(t, st, 100)
+	pool := newTestPool(st, 10)
+
+	applied := acc1.tx(t, 0, 10, 1)
+	conflicting := acc1.tx(t, 0, 20, 5) // will be replaced out of the pool by a layer including the other one
+	next := acc1.tx(t, 1, 10, 1)
+	expensive := acc2.tx(t, 0, 80, 1)
+	assert.NoError(t, pool.Add(conflicting))
+	assert.NoError(t, pool.Add(next))
+	assert.NoError(t, pool.Add(expensive))
+
+	// a layer applied the other transaction of the nonce and the second sender spent its balance elsewhere
+	st.SetNonce(acc1.addr, 1)
+	st.SubBalance(acc1.addr, applied.Cost(testGas))
+	st.SubBalance(acc2.addr, big.NewInt(50))
+	pool.Applied(state.Transactions{applied})
+
+	assert.Nil(t, pool.Get(conflicting.Hash()))
+	assert.Nil(t, pool.Get(expensive.Hash()))
+	assert.Equal(t, state.Transactions{next}, pool.Pending())
+
+	st.SetNonce(acc1.addr, 2)
+	pool.Applied(state.Transactions{next})
+	assert.Equal(t, 0, pool.Len())
+}
+
+func TestPool_RunningCost(t *testing.T) {
+	st := newState(t)
+	acc := newAccount(t, st, 100)
+	pool := newTestPool(st, 10)
+
+	// each transaction costs 42, the balance pays for two of them
+	assert.NoError(t, pool.Add(acc.tx(t, 0, 40, 1)))
+	assert.NoError(t, pool.Add(acc.tx(t, 1, 40, 1)))
+	assert.Equal(t, ErrFunds, pool.Add(acc.tx(t, 2, 40, 1)))
+	assert.NoError(t, pool.Add(acc.tx(t, 2, 10, 1)))
+
+	// a more expensive replacement drops the following transactions it leaves unpaid
+	replacement := acc.tx(t, 0, 80, 2)
+	assert.NoError(t, pool.Add(replacement))
+	assert.Equal(t, state.Transactions{replacement}, pool.Pending())
+
+	// the pending transactions are dropped by their running cost on the updated balance
+	second := acc.tx(t, 1, 5, 1)
+	assert.NoError(t, pool.Add(second))
+	assert.NoError(t, pool.Add(acc.tx(t, 2, 5, 1)))
+	st.SubBalance(acc.addr, big.NewInt(5))
+	pool.Applied(nil)
+	assert.Equal(t, state.Transactions{replacement, second}, pool.Pending())
+}
+
+func TestPool_EvictionKeepsPredecessors(t *testing.T) {
+	st := newState(t)
+	acc1 := newAccount(t, st, 1000)
+	acc2 := newAccount(t, st, 1000)
+	pool := newTestPool(st, 2)
+
+	first := acc1.tx(t, 0, 1, 1)
+	assert.NoError(t, pool.Add(first))
+	assert.NoError(t, pool.Add(acc2.tx(t, 0, 1, 6)))
+
+	// evicting the cheaper first transaction of the sender would leave a nonce gap before the added one
+	assert.Equal(t, ErrPoolFull, pool.Add(acc1.tx(t, 1, 1, 5)))
+	assert.Equal(t, first, pool.Get(first.Hash()))
+	assert.Equal(t, 2, pool.Len())
+}
@@ -2,6 +2,7 @@ package mesh
 
 import (
 	"github.com/google/uuid"
+	"github.com/spacemeshos/go-spacemesh/common"
 	"time"
 )
 
@@ -19,6 +20,7 @@ type Block struct {
 	ProVotes   uint64
 	ConVotes   uint64
 	BlockVotes map[BlockID]bool
+	Coinbase   common.Address // the account of the author which is paid the layer reward and fees for the block
 }
 
 func (b Block) ID() BlockID {
@@ -66,6 +68,15 @@ func (l *Layer) Blocks() []*Block {
 	return l.blocks
 }
 
+// Coinbases returns the coinbases of the layer's blocks, which are paid the layer reward and the fees of the layer
+func (l *Layer) Coinbases() []common.Address {
+	coinbases := make([]common.Address, 0, len(l.blocks))
+	for _, b := range l.blocks {
+		coinbases = append(coinbases, b.Coinbase)
+	}
+	return coinbases
+}
+
 func (l *Layer) Hash() []byte {
 	return []byte("some hash representing the layer")
 }
@@ -15,6 +15,7 @@ type Mesh interface {
 	AddLayer(layer *Layer) error
 	GetLayer(i LayerID) (*Layer, error)
 	GetBlock(id BlockID) (*Block, error)
+	LayerBlockIds(i LayerID) ([]BlockID, error)
 	AddBlock(block *Block) error
 	GetContextualValidity(id BlockID) (bool, error)
 	LatestIrreversible() uint32
@@ -99,6 +100,11 @@ func (m *mesh) GetLayer(i LayerID) (*Layer, error) {
 	return m.mDB.getLayer(i)
 }
 
+// LayerBlockIds returns the ids of the blocks stored for layer i, including blocks of layers that were not verified yet
+func (m *mesh) LayerBlockIds(i LayerID) ([]BlockID, error) {
+	return m.mDB.layerBlockIds(i)
+}
+
 func (m *mesh) AddBlock(block *Block) error {
 	log.Debug("add block ", block.ID())
 	if err := m.mDB.addBlock(block); err != nil {
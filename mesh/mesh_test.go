@@ -1,6 +1,7 @@
 package mesh
 
 import (
+	"github.com/spacemeshos/go-spacemesh/common"
 	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -106,9 +107,50 @@ func TestLayers_LatestKnownLayer(t *testing.T) {
 	assert.True(t, layers.LatestKnownLayer() == 10, "wrong layer")
 }
 
+func TestLayers_LayerBlockIds(t *testing.T) {
+	layers := getMesh("t7")
+	defer layers.Close()
+	block1 := NewBlock(true, nil, time.Now(), 5)
+	block2 := NewBlock(true, nil, time.Now(), 5)
+	layers.AddBlock(block1)
+	layers.AddBlock(block2)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		ids, err := layers.LayerBlockIds(5)
+		if err == nil && len(ids) == 2 {
+			assert.ElementsMatch(t, []BlockID{block1.ID(), block2.ID()}, ids)
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatal("blocks of unverified layer were not recorded")
+		default:
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	_, err := layers.LayerBlockIds(6)
+	assert.Error(t, err)
+}
+
 func TestLayers_WakeUp(t *testing.T) {
 	//layers := getMesh(make(chan Peer),  "t5")
 	//defer layers.Close()
 	//layers.SetLatestKnownLayer(10)
 	//assert.True(t, layers.LocalLayerCount() == 10, "wrong layer")
 }
+
+func TestLayers_Coinbases(t *testing.T) {
+	layers := NewMesh(database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase())
+	defer layers.Close()
+	block1 := NewBlock(true, nil, time.Now(), 1)
+	block1.Coinbase = common.BytesToAddress([]byte{0x01})
+	block2 := NewBlock(true, nil, time.Now(), 1)
+	block2.Coinbase = common.BytesToAddress([]byte{0x02})
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(1, []*Block{block1, block2})))
+
+	l, err := layers.GetLayer(1)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []common.Address{block1.Coinbase, block2.Coinbase}, l.Coinbases())
+}
@@ -58,6 +58,24 @@ func (m *meshDB) getLayer(index LayerID) (*Layer, error) {
 	return &Layer{index: LayerID(index), blocks: blocks}, nil
 }
 
+func (m *meshDB) layerBlockIds(index LayerID) ([]BlockID, error) {
+	ids, err := m.layers.Get(index.ToBytes())
+	if err != nil {
+		return nil, errors.New("error getting layer from database ")
+	}
+
+	blockIds, err := bytesToBlockIds(ids)
+	if err != nil {
+		return nil, errors.New("could not get all blocks from database ")
+	}
+
+	res := make([]BlockID, 0, len(blockIds))
+	for id := range blockIds {
+		res = append(res, id)
+	}
+	return res, nil
+}
+
 func (m *meshDB) addBlock(block *Block) error {
 	_, err := m.blocks.Get(block.ID().ToBytes())
 	if err == nil {
@@ -114,10 +132,11 @@ func (m *meshDB) addLayer(layer *Layer) error {
 }
 
 func (m *meshDB) updateLayerIds(block *Block) error {
-	ids, err := m.layers.Get(block.LayerIndex.ToBytes())
-	blockIds, err := bytesToBlockIds(ids)
-	if err != nil {
-		return errors.New("could not get all blocks from database ")
+	blockIds := make(map[BlockID]bool)
+	if ids, err := m.layers.Get(block.LayerIndex.ToBytes()); err == nil { //first block of the layer otherwise
+		if blockIds, err = bytesToBlockIds(ids); err != nil {
+			return errors.New("could not get all blocks from database ")
+		}
 	}
 
 	blockIds[block.ID()] = true
@@ -188,3 +207,25 @@ func (m *meshDB) getLayerHandler(index LayerID, counter int32) *layerHandler {
 	}
 	return ll
 }
+
+// DbReader reads layers and blocks directly from mesh databases, e.g. the databases of another node
+type DbReader struct {
+	mdb *meshDB
+}
+
+func NewDbReader(layers database.DB, blocks database.DB) *DbReader {
+	return &DbReader{&meshDB{layers: layers, blocks: blocks}}
+}
+
+func (r *DbReader) GetLayer(index LayerID) (*Layer, error) {
+	return r.mdb.getLayer(index)
+}
+
+func (r *DbReader) GetBlock(id BlockID) (*Block, error) {
+	return r.mdb.getBlock(id)
+}
+
+func (r *DbReader) Close() {
+	r.mdb.layers.Close()
+	r.mdb.blocks.Close()
+}
@@ -17,6 +17,9 @@ import (
 
 const messageQBufferSize = 100
 
+// validationTimeout is the time we wait for a gossip protocol to report whether a message is valid before dropping it
+const validationTimeout = 10 * time.Second
+
 const ProtocolName = "/p2p/1.0/gossip"
 const protocolVer = "0"
 
@@ -35,6 +38,7 @@ type baseNetwork interface {
 	RegisterProtocol(protocol string) chan service.Message
 	SubscribePeerEvents() (conn chan crypto.PublicKey, disc chan crypto.PublicKey)
 	ProcessProtocolMessage(sender node.Node, protocol string, data service.Data) error
+	ProcessGossipProtocolMessage(sender node.Node, relayedBy node.Node, protocol string, data service.Data, validationChan chan bool) error
 }
 
 type signer interface {
@@ -58,7 +62,7 @@ type Protocol struct {
 	shutdown chan struct{}
 
 	oldMessageMu sync.RWMutex
-	oldMessageQ  map[hash]struct{}
+	oldMessageQ  map[hash]bool // true for the messages pending validation or rejected, which aren't relayed
 	peersMutex   sync.RWMutex
 
 	relayQ   chan service.Message
@@ -76,7 +80,7 @@ func NewProtocol(config config.SwarmConfig, base baseNetwork, signer signer, log
 		signer:      signer,
 		peers:       make(map[string]*peer),
 		shutdown:    make(chan struct{}),
-		oldMessageQ: make(map[hash]struct{}), // todo : remember to drain this
+		oldMessageQ: make(map[hash]bool), // todo : remember to drain this
 		peersMutex:  sync.RWMutex{},
 		relayQ:      relayChan,
 		messageQ:    make(chan protocolMessage, messageQBufferSize),
@@ -141,7 +145,29 @@ func (prot *Protocol) Close() {
 // markMessage adds the calcHash to the old message queue so the message won't be processed in case received again
 func (prot *Protocol) markMessage(h hash) {
 	prot.oldMessageMu.Lock()
-	prot.oldMessageQ[h] = struct{}{}
+	if _, ok := prot.oldMessageQ[h]; !ok {
+		prot.oldMessageQ[h] = false
+	}
+	prot.oldMessageMu.Unlock()
+}
+
+// markReceived adds the calcHash of a received message to the old message queue marked as not relayed, the message
+// is relayed only once it is validated. The message is checked and marked under the same lock so a copy received
+// before the validation isn't relayed. It returns whether the message is old and if so, whether it isn't relayed
+func (prot *Protocol) markReceived(h hash) (old bool, noRelay bool) {
+	prot.oldMessageMu.Lock()
+	defer prot.oldMessageMu.Unlock()
+	if noRelay, ok := prot.oldMessageQ[h]; ok {
+		return true, noRelay
+	}
+	prot.oldMessageQ[h] = true
+	return false, false
+}
+
+// markRelay marks a validated message as relayed in case received again
+func (prot *Protocol) markRelay(h hash) {
+	prot.oldMessageMu.Lock()
+	prot.oldMessageQ[h] = false
 	prot.oldMessageMu.Unlock()
 }
 
@@ -245,14 +271,18 @@ func (prot *Protocol) isOldMessage(h hash) bool {
 	return oldmessage
 }
 
-func (prot *Protocol) handleRelayMessage(msgB []byte) error {
+// handleRelayMessage processes a message relayed to this node by the relayedBy neighbor and relays it onwards
+func (prot *Protocol) handleRelayMessage(relayedBy node.Node, msgB []byte) error {
 	hash := calcHash(msgB)
 
 	// in case the message was received through the relay channel we need to remove the Gossip layer and hand the payload for the next protocol to process
-	if prot.isOldMessage(hash) {
+	if old, noRelay := prot.markReceived(hash); old {
 		// todo : - have some more metrics for termination
 		// todo	: - maybe tell the peer weg ot this message already?
 		prot.Log.Debug("got old message, hash %d", hash)
+		if noRelay {
+			return nil
+		}
 	} else {
 
 		msg := &pb.ProtocolMessage{}
@@ -268,8 +298,6 @@ func (prot *Protocol) handleRelayMessage(msgB []byte) error {
 			return err
 		}
 
-		prot.markMessage(hash)
-
 		var data service.Data
 
 		if payload := msg.GetPayload(); payload != nil {
@@ -284,7 +312,19 @@ func (prot *Protocol) handleRelayMessage(msgB []byte) error {
 			return err
 		}
 
-		go prot.net.ProcessProtocolMessage(node.New(authKey, ""), msg.Metadata.NextProtocol, data)
+		sender := node.New(authKey, "")
+		validationChan := make(chan bool, 1)
+		err = prot.net.ProcessGossipProtocolMessage(sender, relayedBy, msg.Metadata.NextProtocol, data, validationChan)
+		if err == nil {
+			// the next protocol validates the message, relay it only if it was found valid
+			if !prot.waitForValidation(validationChan) {
+				prot.Log.Debug("message failed validation by protocol %v, not relaying. hash %d", msg.Metadata.NextProtocol, hash)
+				return nil
+			}
+		} else {
+			go prot.net.ProcessProtocolMessage(sender, msg.Metadata.NextProtocol, data)
+		}
+		prot.markRelay(hash)
 	}
 
 	prot.propagateMessage(msgB, hash)
@@ -292,6 +332,19 @@ func (prot *Protocol) handleRelayMessage(msgB []byte) error {
 	return nil
 }
 
+// waitForValidation blocks until the validation result is reported, returns false if it wasn't reported in time
+func (prot *Protocol) waitForValidation(validationChan chan bool) bool {
+	select {
+	case isValid := <-validationChan:
+		return isValid
+	case <-time.After(validationTimeout):
+		prot.Log.Warning("timed out waiting for gossip message validation")
+		return false
+	case <-prot.shutdown:
+		return false
+	}
+}
+
 func (prot *Protocol) eventLoop(peerConn chan crypto.PublicKey, peerDisc chan crypto.PublicKey) {
 	var err error
 loop:
@@ -305,7 +358,7 @@ loop:
 			// incoming messages from p2p layer for process and relay
 			go func() {
 				//  [todo some err handling
-				prot.handleRelayMessage(msg.Bytes())
+				prot.handleRelayMessage(msg.Sender(), msg.Bytes())
 			}()
 		case peer := <-peerConn:
 			go prot.addPeer(peer)
@@ -1,6 +1,7 @@
 package gossip
 
 import (
+	"errors"
 	"github.com/gogo/protobuf/proto"
 	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/log"
@@ -25,6 +26,8 @@ type mockBaseNetwork struct {
 	pcountwg             *sync.WaitGroup
 	msgwg                *sync.WaitGroup
 	lastMsg              []byte
+	gossipProtocols      map[string]bool // gossip protocols mapped to the validation result they report
+	pendingValidations   chan chan bool  // when set, the validation of the gossip messages is reported by the test
 }
 
 func newMockBaseNetwork() *mockBaseNetwork {
@@ -39,6 +42,8 @@ func newMockBaseNetwork() *mockBaseNetwork {
 		&sync.WaitGroup{},
 		&sync.WaitGroup{},
 		[]byte(nil),
+		make(map[string]bool),
+		nil,
 	}
 }
 
@@ -94,6 +99,21 @@ func (mbn *mockBaseNetwork) ProcessProtocolMessage(sender node.Node, protocol st
 	return nil
 }
 
+func (mbn *mockBaseNetwork) ProcessGossipProtocolMessage(sender node.Node, relayedBy node.Node, protocol string, data service.Data, validationChan chan bool) error {
+	isValid, ok := mbn.gossipProtocols[protocol]
+	if !ok {
+		return errors.New("unknown protocol")
+	}
+	mbn.processProtocolCount++
+	if mbn.pendingValidations != nil {
+		mbn.pendingValidations <- validationChan
+		return nil
+	}
+	validationChan <- isValid
+	releaseWaiters(mbn.pcountwg)
+	return nil
+}
+
 func (mbn *mockBaseNetwork) addRandomPeers(cnt int) {
 	for i := 0; i < cnt; i++ {
 		_, pub, _ := crypto.GenerateKeyPair()
@@ -259,6 +279,111 @@ func TestNeighborhood_Relay(t *testing.T) {
 	assert.Equal(t, 20, net.totalMsgCount)
 }
 
+func testRelayGossipProtocol(t *testing.T, isValid bool) *mockBaseNetwork {
+	net := newMockBaseNetwork()
+	net.gossipProtocols["validated"] = isValid
+	n := NewProtocol(config.DefaultConfig().SwarmConfig, net, newTestSigner(t), log.New("tesT", "", ""))
+	n.Start()
+
+	addPeersAndTest(t, 20, n, net, true)
+
+	signer := newTestSigner(t)
+	pm := &pb.ProtocolMessage{
+		Metadata: &pb.Metadata{
+			NextProtocol:  "validated",
+			AuthPubKey:    signer.PublicKey().Bytes(),
+			Timestamp:     time.Now().Unix(),
+			ClientVersion: protocolVer,
+		},
+		Data: &pb.ProtocolMessage_Payload{Payload: []byte("LOL")},
+	}
+
+	var msg service.Message = TestMessage{signedMessage(t, signer, pm)}
+	net.pcountwg.Add(1)
+	if isValid {
+		net.msgwg.Add(20)
+	}
+	net.inbox <- msg
+	passOrDeadlock(t, net.pcountwg)
+	passOrDeadlock(t, net.msgwg)
+	time.Sleep(50 * time.Millisecond) // allow sending goroutines to run
+	return net
+}
+
+func TestNeighborhood_RelayValidGossipMessage(t *testing.T) {
+	net := testRelayGossipProtocol(t, true)
+	assert.Equal(t, 1, net.processProtocolCount)
+	assert.Equal(t, 20, net.totalMessageSent())
+}
+
+func TestNeighborhood_DontRelayInvalidGossipMessage(t *testing.T) {
+	net := testRelayGossipProtocol(t, false)
+	assert.Equal(t, 1, net.processProtocolCount)
+	assert.Equal(t, 0, net.totalMessageSent())
+}
+
+func TestNeighborhood_DontRelayMessagePendingValidation(t *testing.T) {
+	net := newMockBaseNetwork()
+	net.gossipProtocols["validated"] = true
+	net.pendingValidations = make(chan chan bool, 1)
+	n := NewProtocol(config.DefaultConfig().SwarmConfig, net, newTestSigner(t), log.New("tesT", "", ""))
+	for i := 0; i < 20; i++ {
+		_, pub, _ := crypto.GenerateKeyPair()
+		n.addPeer(pub)
+	}
+
+	signer := newTestSigner(t)
+	pm := &pb.ProtocolMessage{
+		Metadata: &pb.Metadata{
+			NextProtocol:  "validated",
+			AuthPubKey:    signer.PublicKey().Bytes(),
+			Timestamp:     time.Now().Unix(),
+			ClientVersion: protocolVer,
+		},
+		Data: &pb.ProtocolMessage_Payload{Payload: []byte("LOL")},
+	}
+	data := signedMessage(t, signer, pm).Bytes()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- n.handleRelayMessage(node.Node{}, data)
+	}()
+	validationChan := <-net.pendingValidations
+
+	// a copy received while the message is validated isn't relayed
+	assert.NoError(t, n.handleRelayMessage(node.Node{}, data))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, net.totalMessageSent())
+	assert.Equal(t, 1, net.processProtocolCount)
+
+	net.msgwg.Add(20)
+	validationChan <- true
+	assert.NoError(t, <-done)
+	passOrDeadlock(t, net.msgwg)
+	assert.Equal(t, 20, net.totalMessageSent())
+}
+
+func TestProtocol_markReceived(t *testing.T) {
+	n := NewProtocol(config.DefaultConfig().SwarmConfig, newMockBaseNetwork(), newTestSigner(t), log.New("tesT", "", ""))
+
+	old, noRelay := n.markReceived(1)
+	assert.False(t, old)
+	assert.False(t, noRelay)
+
+	// pending validation or rejected
+	old, noRelay = n.markReceived(1)
+	assert.True(t, old)
+	assert.True(t, noRelay)
+
+	n.markRelay(1)
+	old, noRelay = n.markReceived(1)
+	assert.True(t, old)
+	assert.False(t, noRelay)
+
+	// the no relay mark is kept in the old message queue, it doesn't outlive the entry of the message
+	assert.Equal(t, 1, len(n.oldMessageQ))
+}
+
 func TestNeighborhood_Broadcast(t *testing.T) {
 	net := newMockBaseNetwork()
 	n := NewProtocol(config.DefaultConfig().SwarmConfig, net, newTestSigner(t), log.New("tesT", "", ""))
@@ -22,6 +22,9 @@ type Message interface {
 	Data() service.Data
 }
 
+// PeerMsgHandler handles a request from sender and returns the response payload (nil for no response)
+type PeerMsgHandler func(sender crypto.PublicKey, msg []byte) []byte
+
 type Item struct {
 	id        uint64
 	timestamp time.Time
@@ -32,11 +35,11 @@ type MessageServer struct {
 	name               string //server name
 	network            Service
 	pendMutex          sync.RWMutex
-	pendingQueue       *list.List                              //queue of pending messages
-	resHandlers        map[uint64]func(msg []byte)             //response handlers by request ReqId
-	msgRequestHandlers map[MessageType]func(msg []byte) []byte //request handlers by request type
-	ingressChannel     chan service.Message                    //chan to relay messages into the server
-	requestLifetime    time.Duration                           //time a request can stay in the pending queue until evicted
+	pendingQueue       *list.List                     //queue of pending messages
+	resHandlers        map[uint64]func(msg []byte)    //response handlers by request ReqId
+	msgRequestHandlers map[MessageType]PeerMsgHandler //request handlers by request type
+	ingressChannel     chan service.Message           //chan to relay messages into the server
+	requestLifetime    time.Duration                  //time a request can stay in the pending queue until evicted
 	workerCount        sync.WaitGroup
 	exit               chan struct{}
 }
@@ -48,7 +51,7 @@ func NewMsgServer(network Service, name string, requestLifetime time.Duration) *
 		pendingQueue:       list.New(),
 		network:            network,
 		ingressChannel:     network.RegisterProtocol(name),
-		msgRequestHandlers: make(map[MessageType]func(msg []byte) []byte),
+		msgRequestHandlers: make(map[MessageType]PeerMsgHandler),
 		requestLifetime:    requestLifetime,
 		exit:               make(chan struct{}),
 	}
@@ -130,7 +133,7 @@ func (p *MessageServer) handleMessage(msg Message) {
 }
 
 func (p *MessageServer) handleRequestMessage(sender crypto.PublicKey, headers *service.DataMsgWrapper) {
-	if payload := p.msgRequestHandlers[MessageType(headers.MsgType)](headers.Payload); payload != nil {
+	if payload := p.msgRequestHandlers[MessageType(headers.MsgType)](sender, headers.Payload); payload != nil {
 		rmsg := &service.DataMsgWrapper{MsgType: headers.MsgType, ReqID: headers.ReqID, Payload: payload}
 		sendErr := p.network.SendWrappedMessage(sender.String(), p.name, rmsg)
 		if sendErr != nil {
@@ -151,6 +154,13 @@ func (p *MessageServer) handleResponseMessage(headers *service.DataMsgWrapper) {
 }
 
 func (p *MessageServer) RegisterMsgHandler(msgType MessageType, reqHandler func(msg []byte) []byte) {
+	p.msgRequestHandlers[msgType] = func(sender crypto.PublicKey, msg []byte) []byte {
+		return reqHandler(msg)
+	}
+}
+
+// RegisterPeerMsgHandler registers a request handler that is also given the public key of the requesting peer
+func (p *MessageServer) RegisterPeerMsgHandler(msgType MessageType, reqHandler PeerMsgHandler) {
 	p.msgRequestHandlers[msgType] = reqHandler
 }
 
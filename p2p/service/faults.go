@@ -0,0 +1,270 @@
+package service
+
+import (
+	"encoding/binary"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// LatencyDistribution draws the latency of a single message
+type LatencyDistribution func(rng *rand.Rand) time.Duration
+
+// FixedLatency delays every message by d
+func FixedLatency(d time.Duration) LatencyDistribution {
+	return func(rng *rand.Rand) time.Duration {
+		return d
+	}
+}
+
+// UniformLatency draws latencies uniformly from [min, max]
+func UniformLatency(min, max time.Duration) LatencyDistribution {
+	return func(rng *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(rng.Int63n(int64(max-min)+1))
+	}
+}
+
+// NormalLatency draws latencies from a normal distribution, negative draws are truncated to zero
+func NormalLatency(mean, stddev time.Duration) LatencyDistribution {
+	return func(rng *rand.Rand) time.Duration {
+		d := time.Duration(rng.NormFloat64()*float64(stddev)) + mean
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+}
+
+// LinkConfig describes the faults injected into messages sent over a directed link between two simulated nodes
+type LinkConfig struct {
+	Latency       LatencyDistribution // nil for instant delivery
+	DropRate      float64             // probability a message is lost
+	DuplicateRate float64             // probability a message is delivered twice
+	ReorderRate   float64             // probability a message is held back so that later messages overtake it
+	ReorderDelay  time.Duration       // extra delay of held back messages
+}
+
+func (lc LinkConfig) isReliable() bool {
+	return lc.Latency == nil && lc.DropRate == 0 && lc.DuplicateRate == 0 && lc.ReorderRate == 0
+}
+
+type link struct {
+	from string
+	to   string
+}
+
+// delivery tracks the delayed messages in flight to a node so they are dropped instead of sent when it shuts down
+type delivery struct {
+	mutex  sync.RWMutex // held for reading while a delayed message is sent, shutdown waits for the sends to return
+	done   chan struct{}
+	timers map[*time.Timer]struct{}
+}
+
+// NewSimulatorWithSeed creates a simulator whose fault injection is driven by RNGs seeded with seed, so runs are
+// reproducible. Every link draws from its own RNG, the faults of a link only depend on the order of the messages
+// sent over it and not on the scheduling of the goroutines sending on other links
+func NewSimulatorWithSeed(seed int64) *Simulator {
+	s := NewSimulator()
+	s.seed = seed
+	return s
+}
+
+// SetDefaultLink sets the faults of every link that wasn't configured with SetLink
+func (s *Simulator) SetDefaultLink(config LinkConfig) {
+	s.faultMutex.Lock()
+	s.defaultLink = config
+	s.faultMutex.Unlock()
+}
+
+// SetLink sets the faults of messages sent from node from to node to
+func (s *Simulator) SetLink(from, to *Node, config LinkConfig) {
+	s.faultMutex.Lock()
+	s.links[link{from.String(), to.String()}] = config
+	s.faultMutex.Unlock()
+}
+
+// PartitionOneWay drops all messages sent from nodes in from to nodes in to until healed
+func (s *Simulator) PartitionOneWay(from, to []*Node) {
+	s.faultMutex.Lock()
+	for _, f := range from {
+		for _, t := range to {
+			s.partitions[link{f.String(), t.String()}] = struct{}{}
+		}
+	}
+	s.faultMutex.Unlock()
+}
+
+// Partition drops all messages between nodes in a and nodes in b, in both directions, until healed
+func (s *Simulator) Partition(a, b []*Node) {
+	s.PartitionOneWay(a, b)
+	s.PartitionOneWay(b, a)
+}
+
+// HealOneWay removes a partition created from nodes in from to nodes in to
+func (s *Simulator) HealOneWay(from, to []*Node) {
+	s.faultMutex.Lock()
+	for _, f := range from {
+		for _, t := range to {
+			delete(s.partitions, link{f.String(), t.String()})
+		}
+	}
+	s.faultMutex.Unlock()
+}
+
+// Heal removes the partitions between nodes in a and nodes in b, in both directions
+func (s *Simulator) Heal(a, b []*Node) {
+	s.HealOneWay(a, b)
+	s.HealOneWay(b, a)
+}
+
+// HealAll removes all partitions
+func (s *Simulator) HealAll() {
+	s.faultMutex.Lock()
+	s.partitions = make(map[link]struct{})
+	s.faultMutex.Unlock()
+}
+
+// linkRng returns the RNG of the link, it must be called with rngMutex held. The RNG is seeded from the order the
+// nodes of the link were created in rather than their random ids, so a seed reproduces the same faults
+func (s *Simulator) linkRng(l link) *rand.Rand {
+	rng, ok := s.rngs[l]
+	if !ok {
+		s.mutex.RLock()
+		from, to := s.nodeOrder[l.from], s.nodeOrder[l.to]
+		s.mutex.RUnlock()
+		h := fnv.New64a()
+		binary.Write(h, binary.BigEndian, [2]int64{int64(from), int64(to)})
+		rng = rand.New(rand.NewSource(s.seed ^ int64(h.Sum64())))
+		s.rngs[l] = rng
+	}
+	return rng
+}
+
+// deliveryDelays returns the delays of the copies of a message sent over the link, no copies if the message is dropped
+func (s *Simulator) deliveryDelays(l link, config LinkConfig) []time.Duration {
+	s.rngMutex.Lock()
+	defer s.rngMutex.Unlock()
+
+	rng := s.linkRng(l)
+	if rng.Float64() < config.DropRate {
+		return nil
+	}
+	copies := 1
+	if rng.Float64() < config.DuplicateRate {
+		copies = 2
+	}
+	delays := make([]time.Duration, 0, copies)
+	for i := 0; i < copies; i++ {
+		var d time.Duration
+		if config.Latency != nil {
+			d = config.Latency(rng)
+		}
+		if rng.Float64() < config.ReorderRate {
+			d += config.ReorderDelay
+		}
+		delays = append(delays, d)
+	}
+	return delays
+}
+
+// deliver passes a message from node from to node to through the faults of the link between them.
+// send is called synchronously for messages that aren't delayed and from a timer otherwise, a delayed send must
+// give up when done is closed
+func (s *Simulator) deliver(from, to string, send func(done <-chan struct{})) {
+	if from == to {
+		send(nil)
+		return
+	}
+
+	s.faultMutex.RLock()
+	_, partitioned := s.partitions[link{from, to}]
+	config, ok := s.links[link{from, to}]
+	if !ok {
+		config = s.defaultLink
+	}
+	s.faultMutex.RUnlock()
+
+	if partitioned {
+		log.Debug("%v >> %v dropped message (partitioned)", from, to)
+		return
+	}
+
+	if config.isReliable() {
+		send(nil)
+		return
+	}
+
+	for _, d := range s.deliveryDelays(link{from, to}, config) {
+		if d == 0 {
+			send(nil)
+			continue
+		}
+		s.deliverAfter(from, to, d, send)
+	}
+}
+
+// nodeDelivery returns the delayed messages in flight to the node, it must be called with faultMutex held
+func (s *Simulator) nodeDelivery(node string) *delivery {
+	dl, ok := s.deliveries[node]
+	if !ok {
+		dl = &delivery{done: make(chan struct{}), timers: make(map[*time.Timer]struct{})}
+		s.deliveries[node] = dl
+	}
+	return dl
+}
+
+// deliverAfter sends the message from a timer after d unless the recipient shuts down first
+func (s *Simulator) deliverAfter(from, to string, d time.Duration, send func(done <-chan struct{})) {
+	s.faultMutex.Lock()
+	dl := s.nodeDelivery(to)
+	select {
+	case <-dl.done:
+		s.faultMutex.Unlock()
+		log.Debug("%v >> %v dropped message (node was shut down)", from, to)
+		return
+	default:
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		s.faultMutex.Lock()
+		delete(dl.timers, timer)
+		s.faultMutex.Unlock()
+
+		dl.mutex.RLock()
+		defer dl.mutex.RUnlock()
+		select {
+		case <-dl.done:
+			log.Debug("%v >> %v dropped message (node was shut down)", from, to)
+		default:
+			send(dl.done)
+		}
+	})
+	dl.timers[timer] = struct{}{}
+	s.faultMutex.Unlock()
+}
+
+// stopDeliveries stops the timers of the messages in flight to the node and waits for the sends that already
+// started, so the node's channels can be closed. Messages sent to the node afterwards are dropped
+func (s *Simulator) stopDeliveries(node string) {
+	s.faultMutex.Lock()
+	dl := s.nodeDelivery(node)
+	select {
+	case <-dl.done:
+	default:
+		close(dl.done)
+	}
+	for timer := range dl.timers {
+		timer.Stop()
+	}
+	dl.timers = make(map[*time.Timer]struct{})
+	s.faultMutex.Unlock()
+
+	dl.mutex.Lock()
+	dl.mutex.Unlock()
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const testProtocol = "/test/1.0/"
+
+// receiveAll reads messages from c until no message arrives for wait
+func receiveAll(c chan Message, wait time.Duration) []string {
+	msgs := make([]string, 0)
+	for {
+		select {
+		case msg := <-c:
+			msgs = append(msgs, string(msg.Bytes()))
+		case <-time.After(wait):
+			return msgs
+		}
+	}
+}
+
+func sendAll(t *testing.T, from, to *Node, msgs ...string) {
+	for _, m := range msgs {
+		assert.NoError(t, from.SendMessage(to.String(), testProtocol, []byte(m)))
+	}
+}
+
+func TestSimulator_Latency(t *testing.T) {
+	sim := NewSimulatorWithSeed(1)
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	c := n2.RegisterProtocol(testProtocol)
+	sim.SetLink(n1, n2, LinkConfig{Latency: FixedLatency(100 * time.Millisecond)})
+
+	start := time.Now()
+	sendAll(t, n1, n2, "hello")
+	msg := <-c
+	assert.Equal(t, "hello", string(msg.Bytes()))
+	assert.True(t, time.Since(start) >= 100*time.Millisecond, "message was delivered before its latency")
+}
+
+func TestSimulator_DropIsReproducible(t *testing.T) {
+	run := func() []string {
+		sim := NewSimulatorWithSeed(42)
+		n1 := sim.NewNode()
+		n2 := sim.NewNode()
+		c := n2.RegisterProtocol(testProtocol)
+		sim.SetDefaultLink(LinkConfig{DropRate: 0.5})
+		go sendAll(t, n1, n2, "1", "2", "3", "4", "5", "6", "7", "8", "9", "10")
+		return receiveAll(c, 100*time.Millisecond)
+	}
+
+	first := run()
+	assert.True(t, len(first) > 0 && len(first) < 10, "drop rate wasn't applied")
+	assert.Equal(t, first, run(), "same seed produced different drops")
+}
+
+func TestSimulator_Duplicate(t *testing.T) {
+	sim := NewSimulatorWithSeed(1)
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	c := n2.RegisterProtocol(testProtocol)
+	sim.SetLink(n1, n2, LinkConfig{DuplicateRate: 1})
+
+	go sendAll(t, n1, n2, "dup")
+	assert.Equal(t, []string{"dup", "dup"}, receiveAll(c, 100*time.Millisecond))
+}
+
+func TestSimulator_Reorder(t *testing.T) {
+	sim := NewSimulatorWithSeed(1)
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	c := n2.RegisterProtocol(testProtocol)
+
+	sim.SetLink(n1, n2, LinkConfig{ReorderRate: 1, ReorderDelay: 100 * time.Millisecond})
+	sendAll(t, n1, n2, "first")
+	sim.SetLink(n1, n2, LinkConfig{Latency: FixedLatency(0)})
+	go sendAll(t, n1, n2, "second")
+
+	assert.Equal(t, []string{"second", "first"}, receiveAll(c, 200*time.Millisecond))
+}
+
+func TestSimulator_Partition(t *testing.T) {
+	sim := NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	c1 := n1.RegisterProtocol(testProtocol)
+	c2 := n2.RegisterProtocol(testProtocol)
+
+	sim.Partition([]*Node{n1}, []*Node{n2})
+	sendAll(t, n1, n2, "lost")
+	sendAll(t, n2, n1, "lost")
+	assert.Empty(t, receiveAll(c1, 50*time.Millisecond))
+	assert.Empty(t, receiveAll(c2, 50*time.Millisecond))
+
+	sim.Heal([]*Node{n1}, []*Node{n2})
+	go sendAll(t, n1, n2, "healed")
+	assert.Equal(t, []string{"healed"}, receiveAll(c2, 50*time.Millisecond))
+}
+
+func TestSimulator_PartitionOneWay(t *testing.T) {
+	sim := NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	c1 := n1.RegisterProtocol(testProtocol)
+	c2 := n2.RegisterProtocol(testProtocol)
+
+	sim.PartitionOneWay([]*Node{n1}, []*Node{n2})
+	sendAll(t, n1, n2, "lost")
+	go sendAll(t, n2, n1, "delivered")
+	assert.Empty(t, receiveAll(c2, 50*time.Millisecond))
+	assert.Equal(t, []string{"delivered"}, receiveAll(c1, 50*time.Millisecond))
+
+	sim.HealAll()
+	go sendAll(t, n1, n2, "healed")
+	assert.Equal(t, []string{"healed"}, receiveAll(c2, 50*time.Millisecond))
+}
+
+func TestSimulator_BroadcastPartition(t *testing.T) {
+	sim := NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	n3 := sim.NewNode()
+	c1 := n1.RegisterProtocol(testProtocol)
+	c2 := n2.RegisterProtocol(testProtocol)
+	c3 := n3.RegisterProtocol(testProtocol)
+
+	sim.Partition([]*Node{n1, n2}, []*Node{n3})
+	go n1.Broadcast(testProtocol, []byte("gossip"))
+	assert.Equal(t, []string{"gossip"}, receiveAll(c1, 50*time.Millisecond))
+	assert.Equal(t, []string{"gossip"}, receiveAll(c2, 50*time.Millisecond))
+	assert.Empty(t, receiveAll(c3, 50*time.Millisecond))
+}
+
+func TestSimulator_ShutdownWithMessagesInFlight(t *testing.T) {
+	sim := NewSimulatorWithSeed(1)
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	n2.RegisterProtocol(testProtocol)
+	sim.SetLink(n1, n2, LinkConfig{Latency: FixedLatency(20 * time.Millisecond)})
+
+	// the first message is sent by its timer but nobody reads it, the second is still waiting for its timer
+	sendAll(t, n1, n2, "blocked")
+	time.Sleep(40 * time.Millisecond)
+	sim.SetLink(n1, n2, LinkConfig{Latency: FixedLatency(50 * time.Millisecond)})
+	sendAll(t, n1, n2, "in flight")
+
+	done := make(chan struct{})
+	go func() {
+		n2.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown waited for a message nobody reads")
+	}
+
+	// the timer of the second message was stopped, nothing is sent on the closed channels
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestSimulator_ConcurrentSendersAreReproducible(t *testing.T) {
+	run := func() ([]string, []string) {
+		sim := NewSimulatorWithSeed(7)
+		n1 := sim.NewNode()
+		n2 := sim.NewNode()
+		n3 := sim.NewNode()
+		c3 := n3.RegisterProtocol(testProtocol)
+		sim.SetDefaultLink(LinkConfig{DropRate: 0.5})
+
+		// the senders draw from the RNGs concurrently, each link's drops only depend on the messages sent over it
+		go sendAll(t, n1, n3, "a1", "a2", "a3", "a4", "a5", "a6", "a7", "a8")
+		go sendAll(t, n2, n3, "b1", "b2", "b3", "b4", "b5", "b6", "b7", "b8")
+		var fromN1, fromN2 []string
+		for _, m := range receiveAll(c3, 100*time.Millisecond) {
+			if m[0] == 'a' {
+				fromN1 = append(fromN1, m)
+			} else {
+				fromN2 = append(fromN2, m)
+			}
+		}
+		return fromN1, fromN2
+	}
+
+	first1, first2 := run()
+	for i := 0; i < 3; i++ {
+		second1, second2 := run()
+		assert.Equal(t, first1, second1, "same seed produced different drops")
+		assert.Equal(t, first2, second2, "same seed produced different drops")
+	}
+}
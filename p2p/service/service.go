@@ -11,10 +11,18 @@ type Message interface {
 	Bytes() []byte
 }
 
+// GossipMessage is a gossip message that the receiving protocol must validate before the gossip protocol relays it
+type GossipMessage interface {
+	Message
+	RelayedBy() node.Node // the neighbor which relayed the message, Sender is the author who signed it
+	ReportValidation(isValid bool)
+}
+
 // Service is an interface that represents a networking service (ideally p2p) that we can use to send messages or listen to incoming messages
 type Service interface {
 	Start() error
 	RegisterProtocol(protocol string) chan Message
+	RegisterGossipProtocol(protocol string) chan GossipMessage
 	SendMessage(nodeID string, protocol string, payload []byte) error
 	SubscribePeerEvents() (new chan crypto.PublicKey, del chan crypto.PublicKey)
 	ProcessProtocolMessage(sender node.Node, protocol string, payload Data) error
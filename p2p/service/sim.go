@@ -6,17 +6,29 @@ import (
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p/node"
 	"io"
+	"math/rand"
 	"sync"
+	"time"
 )
 
-// TODO : implmement delays?
-
 // Simulator is a p2p node factory and message bridge
 type Simulator struct {
 	io.Closer
 	mutex           sync.RWMutex
 	protocolHandler map[string]map[string]chan Message // maps peerPubkey -> protocol -> handler
+	gossipHandler   map[string]map[string]chan GossipMessage
 	nodes           map[string]*Node
+	nodeOrder       map[string]int // the order nodes were created in, the fault RNGs of links are derived from it
+
+	// fault injection, see faults.go
+	faultMutex  sync.RWMutex
+	defaultLink LinkConfig
+	links       map[link]LinkConfig
+	partitions  map[link]struct{}
+	deliveries  map[string]*delivery
+	rngMutex    sync.Mutex
+	seed        int64
+	rngs        map[link]*rand.Rand
 
 	subLock      sync.Mutex
 	newPeersSubs []chan crypto.PublicKey
@@ -40,7 +52,14 @@ type Node struct {
 func NewSimulator() *Simulator {
 	s := &Simulator{
 		protocolHandler: make(map[string]map[string]chan Message),
+		gossipHandler:   make(map[string]map[string]chan GossipMessage),
 		nodes:           make(map[string]*Node),
+		nodeOrder:       make(map[string]int),
+		links:           make(map[link]LinkConfig),
+		partitions:      make(map[link]struct{}),
+		deliveries:      make(map[string]*delivery),
+		seed:            time.Now().UnixNano(),
+		rngs:            make(map[link]*rand.Rand),
 	}
 	return s
 }
@@ -74,7 +93,11 @@ func (s *Simulator) publishDelPeer(peer crypto.PublicKey) {
 func (s *Simulator) createdNode(n *Node) {
 	s.mutex.Lock()
 	s.protocolHandler[n.PublicKey().String()] = make(map[string]chan Message)
+	s.gossipHandler[n.PublicKey().String()] = make(map[string]chan GossipMessage)
 	s.nodes[n.PublicKey().String()] = n
+	if _, ok := s.nodeOrder[n.PublicKey().String()]; !ok {
+		s.nodeOrder[n.PublicKey().String()] = len(s.nodeOrder)
+	}
 	s.mutex.Unlock()
 	s.publishNewPeer(n.PublicKey())
 }
@@ -131,6 +154,18 @@ func (sm simMessage) Sender() node.Node {
 	return sm.sender
 }
 
+type simGossipMessage struct {
+	simMessage
+}
+
+// RelayedBy is the sender since the simulator delivers broadcasts directly to every node
+func (sgm simGossipMessage) RelayedBy() node.Node {
+	return sgm.sender
+}
+
+// ReportValidation is a no-op since the simulator delivers broadcasts directly to every node
+func (sgm simGossipMessage) ReportValidation(isValid bool) {}
+
 func (sn *Node) Start() error {
 	// on simulation this doesn't really matter yet.
 	return nil
@@ -164,7 +199,12 @@ func (sn *Node) sendMessageImpl(nodeID string, protocol string, payload Data) er
 	thec, ok := sn.sim.protocolHandler[nodeID][protocol]
 	sn.sim.mutex.RUnlock()
 	if ok {
-		thec <- simMessage{payload, sn.Node}
+		sn.sim.deliver(sn.Node.String(), nodeID, func(done <-chan struct{}) {
+			select {
+			case thec <- simMessage{payload, sn.Node}:
+			case <-done:
+			}
+		})
 		sn.sim.updateNode(nodeID, sn)
 		return nil
 	}
@@ -174,13 +214,34 @@ func (sn *Node) sendMessageImpl(nodeID string, protocol string, payload Data) er
 
 // Broadcast
 func (sn *Node) Broadcast(protocol string, payload []byte) error {
+	type recipient struct {
+		node string
+		send func(done <-chan struct{})
+	}
 	sn.sim.mutex.RLock()
+	recipients := make([]recipient, 0, len(sn.sim.protocolHandler))
 	for n := range sn.sim.protocolHandler {
 		if c, ok := sn.sim.protocolHandler[n][protocol]; ok {
-			c <- simMessage{DataBytes{Payload: payload}, sn.Node}
+			recipients = append(recipients, recipient{n, func(done <-chan struct{}) {
+				select {
+				case c <- simMessage{DataBytes{Payload: payload}, sn.Node}:
+				case <-done:
+				}
+			}})
+		}
+		if c, ok := sn.sim.gossipHandler[n][protocol]; ok {
+			recipients = append(recipients, recipient{n, func(done <-chan struct{}) {
+				select {
+				case c <- simGossipMessage{simMessage{DataBytes{Payload: payload}, sn.Node}}:
+				case <-done:
+				}
+			}})
 		}
 	}
 	sn.sim.mutex.RUnlock()
+	for _, r := range recipients {
+		sn.sim.deliver(sn.Node.String(), r.node, r.send)
+	}
 	log.Debug("%v >> All ( Gossip ) (%v)", sn.Node.PublicKey(), payload)
 	return nil
 }
@@ -198,6 +259,15 @@ func (sn *Node) RegisterProtocol(protocol string) chan Message {
 	return c
 }
 
+// RegisterGossipProtocol creates and returns a channel for a given gossip protocol.
+func (sn *Node) RegisterGossipProtocol(protocol string) chan GossipMessage {
+	c := make(chan GossipMessage)
+	sn.sim.mutex.Lock()
+	sn.sim.gossipHandler[sn.Node.String()][protocol] = c
+	sn.sim.mutex.Unlock()
+	return c
+}
+
 // AttachDHT attaches a dht for the update function of the simulation node
 func (sn *Node) AttachDHT(dht dht) {
 	sn.dht = dht
@@ -213,10 +283,15 @@ func (sn *Node) Update(node2 node.Node) {
 
 // Shutdown closes all node channels are remove it from the Simulator map
 func (sn *Node) Shutdown() {
+	sn.sim.stopDeliveries(sn.Node.String())
 	sn.sim.mutex.Lock()
 	for _, c := range sn.sim.protocolHandler[sn.Node.String()] {
 		close(c)
 	}
 	delete(sn.sim.protocolHandler, sn.Node.String())
+	for _, c := range sn.sim.gossipHandler[sn.Node.String()] {
+		close(c)
+	}
+	delete(sn.sim.gossipHandler, sn.Node.String())
 	sn.sim.mutex.Unlock()
 }
@@ -45,6 +45,26 @@ func (pm protocolMessage) Bytes() []byte {
 	return pm.data.Bytes()
 }
 
+type gossipProtocolMessage struct {
+	protocolMessage
+	relayedBy      node.Node
+	validationChan chan bool
+}
+
+// RelayedBy returns the neighbor which relayed the message to this node
+func (gpm gossipProtocolMessage) RelayedBy() node.Node {
+	return gpm.relayedBy
+}
+
+// ReportValidation reports the validation result back to the gossip protocol so it can decide whether to relay the message
+func (gpm gossipProtocolMessage) ReportValidation(isValid bool) {
+	select {
+	case gpm.validationChan <- isValid:
+	default:
+		// validation was already reported
+	}
+}
+
 type cPool interface {
 	GetConnection(address string, pk crypto.PublicKey) (net.Connection, error)
 	RemoteConnectionsChannel() chan net.NewConnectionEvent
@@ -71,8 +91,9 @@ type swarm struct {
 
 	// map between protocol names to listening protocol handlers
 	// NOTE: maybe let more than one handler register on a protocol ?
-	protocolHandlers     map[string]chan service.Message
-	protocolHandlerMutex sync.RWMutex
+	protocolHandlers       map[string]chan service.Message
+	gossipProtocolHandlers map[string]chan service.GossipMessage
+	protocolHandlerMutex   sync.RWMutex
 
 	gossip  *gossip.Protocol
 	network *net.Net
@@ -154,9 +175,10 @@ func newSwarm(ctx context.Context, config config.Config, newNode bool, persist b
 		delPeerSub:        make([]chan crypto.PublicKey, 0, 10),
 		connectingTimeout: ConnectingTimeout,
 
-		protocolHandlers: make(map[string]chan service.Message),
-		network:          n,
-		cPool:            connectionpool.NewConnectionPool(n, l.PublicKey()),
+		protocolHandlers:       make(map[string]chan service.Message),
+		gossipProtocolHandlers: make(map[string]chan service.GossipMessage),
+		network:                n,
+		cPool:                  connectionpool.NewConnectionPool(n, l.PublicKey()),
 	}
 
 	s.dht = dht.New(l, config.SwarmConfig, s)
@@ -342,6 +364,16 @@ func (s *swarm) RegisterProtocol(protocol string) chan service.Message {
 	return mchan
 }
 
+// RegisterGossipProtocol registers an handler for `protocol`, messages from this protocol are relayed by gossip only
+// after the handler reports them as valid
+func (s *swarm) RegisterGossipProtocol(protocol string) chan service.GossipMessage {
+	mchan := make(chan service.GossipMessage, 100)
+	s.protocolHandlerMutex.Lock()
+	s.gossipProtocolHandlers[protocol] = mchan
+	s.protocolHandlerMutex.Unlock()
+	return mchan
+}
+
 // Shutdown sends a shutdown signal to all running services of swarm and then runs an internal shutdown to cleanup.
 func (s *swarm) Shutdown() {
 	close(s.shutdown)
@@ -556,6 +588,22 @@ func (s *swarm) ProcessProtocolMessage(sender node.Node, protocol string, data s
 	return nil
 }
 
+// ProcessGossipProtocolMessage passes a gossip message authored by sender and relayed by relayedBy to a registered
+// gossip protocol, the validation result reported by the protocol is written to validationChan
+func (s *swarm) ProcessGossipProtocolMessage(sender node.Node, relayedBy node.Node, protocol string, data service.Data, validationChan chan bool) error {
+	s.protocolHandlerMutex.RLock()
+	msgchan := s.gossipProtocolHandlers[protocol]
+	s.protocolHandlerMutex.RUnlock()
+	if msgchan == nil {
+		return ErrNoProtocol
+	}
+	s.lNode.Debug("Forwarding gossip message to %v protocol", protocol)
+
+	msgchan <- gossipProtocolMessage{protocolMessage{sender, data}, relayedBy, validationChan}
+
+	return nil
+}
+
 // Broadcast creates a gossip message signs it and disseminate it to neighbors.
 func (s *swarm) Broadcast(protocol string, payload []byte) error {
 	return s.gossip.Broadcast(payload, protocol)
@@ -0,0 +1,29 @@
+package config
+
+const (
+	defaultBaseReward       = 50000000000
+	defaultHalvingInterval  = 2100000
+	defaultTransferGas      = 1
+	defaultHistoryRetention = 1000
+)
+
+// Config defines the fee, reward and state history params of the transaction processor. The fee and reward params
+// are network params set by the genesis, they aren't read from the node config so all nodes apply the layers the
+// same way. The history retention is set by the node config, it limits how deep the state can be reverted but not
+// the disk usage of the state
+type Config struct {
+	BaseReward       int64  `mapstructure:"-" json:"base-reward"`             // the reward of a layer before the first halving
+	HalvingInterval  uint64 `mapstructure:"-" json:"reward-halving-interval"` // the number of layers between reward halvings, 0 never halves
+	TransferGas      uint64 `mapstructure:"-" json:"transfer-gas"`            // the gas charged for applying a transaction
+	HistoryRetention uint64 `mapstructure:"history-retention" json:"-"`       // the number of layers the state can be reverted to, 0 keeps all layers
+}
+
+// DefaultConfig defines the default fee, reward and state history params
+func DefaultConfig() Config {
+	return Config{
+		BaseReward:       defaultBaseReward,
+		HalvingInterval:  defaultHalvingInterval,
+		TransferGas:      defaultTransferGas,
+		HistoryRetention: defaultHistoryRetention,
+	}
+}
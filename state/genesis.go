@@ -0,0 +1,185 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/state/config"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"time"
+)
+
+var ErrGenesisMismatch = errors.New("data directory was created from another genesis")
+
+var (
+	genesisHashKey = []byte("genesis-hash")
+	genesisRootKey = []byte("genesis-root")
+)
+
+// Genesis is the specification of the initial state of the network, the accounts are given by hex address
+// in the format of the state dump
+type Genesis struct {
+	Time     time.Time              `json:"time"`
+	Config   config.Config          `json:"config"`
+	Accounts map[string]DumpAccount `json:"accounts"`
+}
+
+// DefaultGenesis returns a genesis without accounts and with the default network params
+func DefaultGenesis() *Genesis {
+	return &Genesis{
+		Config:   config.DefaultConfig(),
+		Accounts: make(map[string]DumpAccount),
+	}
+}
+
+// LoadGenesis reads a genesis specification from a json file, the params missing from the file get their defaults
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	genesis := DefaultGenesis()
+	if err := json.Unmarshal(data, genesis); err != nil {
+		return nil, fmt.Errorf("failed to parse genesis file %v: %v", path, err)
+	}
+
+	if _, err := genesis.accounts(); err != nil {
+		return nil, err
+	}
+	return genesis, nil
+}
+
+type genesisAccount struct {
+	address common.Address
+	balance *big.Int
+	nonce   uint64
+}
+
+// accounts parses the genesis accounts and orders them by address. An address given more than once, e.g. in upper
+// and lower case hex, is rejected
+func (g *Genesis) accounts() ([]genesisAccount, error) {
+	accounts := make([]genesisAccount, 0, len(g.Accounts))
+	spellings := make(map[common.Address]string, len(g.Accounts))
+	for hex, account := range g.Accounts {
+		addr := common.FromHex(hex)
+		if len(addr) != common.AddressLength {
+			return nil, fmt.Errorf("invalid genesis account address %v", hex)
+		}
+		if other, ok := spellings[common.BytesToAddress(addr)]; ok {
+			return nil, fmt.Errorf("duplicate genesis account address %v and %v", other, hex)
+		}
+		spellings[common.BytesToAddress(addr)] = hex
+
+		balance, ok := new(big.Int).SetString(account.Balance, 10)
+		if !ok || balance.Sign() < 0 {
+			return nil, fmt.Errorf("invalid balance %v of genesis account %v", account.Balance, hex)
+		}
+
+		accounts = append(accounts, genesisAccount{common.BytesToAddress(addr), balance, account.Nonce})
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].address.Big().Cmp(accounts[j].address.Big()) < 0
+	})
+	return accounts, nil
+}
+
+// commit writes the genesis state to the database and returns it
+func (g *Genesis) commit(db database.Database) (*StateDB, common.Hash, error) {
+	accounts, err := g.accounts()
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+
+	state, err := New(common.Hash{}, NewDatabase(db))
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+
+	for _, account := range accounts {
+		state.SetBalance(account.address, account.balance)
+		state.SetNonce(account.address, account.nonce)
+	}
+
+	root, err := state.Commit(false)
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+
+	if err := state.TrieDB().Commit(root, false); err != nil {
+		return nil, common.Hash{}, err
+	}
+	return state, root, nil
+}
+
+func (g *Genesis) hash(root common.Hash) common.Hash {
+	return rlpHash([]interface{}{
+		uint64(g.Time.Unix()),
+		uint64(g.Config.BaseReward),
+		g.Config.HalvingInterval,
+		g.Config.TransferGas,
+		root,
+	})
+}
+
+// Hash returns the hash identifying the genesis, it covers the genesis time, the network params and the initial state
+func (g *Genesis) Hash() (common.Hash, error) {
+	_, root, err := g.commit(database.NewMemDatabase())
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return g.hash(root), nil
+}
+
+// SetupGenesis returns the genesis state of the database. On the first start the genesis state is created and its
+// hash is recorded, later starts return ErrGenesisMismatch if the database was created from another genesis
+func SetupGenesis(db database.Database, genesis *Genesis) (*StateDB, error) {
+	hash, err := genesis.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	has, err := db.Has(genesisHashKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if has {
+		stored, err := db.Get(genesisHashKey)
+		if err != nil {
+			return nil, err
+		}
+		if common.BytesToHash(stored) != hash {
+			log.Error("genesis hash %x doesn't match the hash %x of the data directory", hash, stored)
+			return nil, ErrGenesisMismatch
+		}
+
+		root, err := db.Get(genesisRootKey)
+		if err != nil {
+			return nil, err
+		}
+		return New(common.BytesToHash(root), NewDatabase(db))
+	}
+
+	state, root, err := genesis.commit(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// the hash is written last so an interrupted setup is done again on the next start
+	if err := db.Put(genesisRootKey, root[:]); err != nil {
+		return nil, err
+	}
+	if err := db.Put(genesisHashKey, hash[:]); err != nil {
+		return nil, err
+	}
+
+	log.Info("created genesis state %x with %v accounts, genesis hash %x", root, len(genesis.Accounts), hash)
+	return state, nil
+}
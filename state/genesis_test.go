@@ -0,0 +1,132 @@
+package state
+
+import (
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/state/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testGenesis = `{
+	"time": "2019-01-01T00:00:00Z",
+	"config": {
+		"base-reward": 100,
+		"transfer-gas": 2
+	},
+	"accounts": {
+		"7034adcca06964c0dffdb3eae70e8666faa5a63d": {
+			"balance": "1000000000000000000000",
+			"nonce": 2
+		},
+		"0xd7dae24b1886aff022fa4d8283a28a7a9209583f": {
+			"balance": "33"
+		}
+	}
+}`
+
+func writeGenesis(t *testing.T, dir string, data string) string {
+	path := filepath.Join(dir, "genesis.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func TestLoadGenesis(t *testing.T) {
+	dir, err := ioutil.TempDir("", "genesis")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	genesis, err := LoadGenesis(writeGenesis(t, dir, testGenesis))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), genesis.Time)
+	// the params missing from the file get their defaults
+	expected := config.DefaultConfig()
+	expected.BaseReward = 100
+	expected.TransferGas = 2
+	assert.Equal(t, expected, genesis.Config)
+	assert.Equal(t, 2, len(genesis.Accounts))
+
+	_, err = LoadGenesis(writeGenesis(t, dir, `{"accounts": {"0x1234": {"balance": "1"}}}`))
+	assert.Error(t, err)
+	_, err = LoadGenesis(writeGenesis(t, dir, `{"accounts": {"7034adcca06964c0dffdb3eae70e8666faa5a63d": {"balance": "-1"}}}`))
+	assert.Error(t, err)
+	// the same address in another spelling
+	_, err = LoadGenesis(writeGenesis(t, dir, `{"accounts": {"7034adcca06964c0dffdb3eae70e8666faa5a63d": {"balance": "1"},
+		"0x7034ADCCA06964C0DFFDB3EAE70E8666FAA5A63D": {"balance": "2"}}}`))
+	assert.Error(t, err)
+	_, err = LoadGenesis(writeGenesis(t, dir, `{"accounts": [}`))
+	assert.Error(t, err)
+	_, err = LoadGenesis(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestSetupGenesis(t *testing.T) {
+	dir, err := ioutil.TempDir("", "genesis")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	genesis, err := LoadGenesis(writeGenesis(t, dir, testGenesis))
+	assert.NoError(t, err)
+	addr1 := common.HexToAddress("7034adcca06964c0dffdb3eae70e8666faa5a63d")
+	addr2 := common.HexToAddress("d7dae24b1886aff022fa4d8283a28a7a9209583f")
+
+	dbDir := filepath.Join(dir, "state")
+	db, err := database.NewLDBDatabase(dbDir, 0, 0)
+	assert.NoError(t, err)
+	state, err := SetupGenesis(db, genesis)
+	assert.NoError(t, err)
+	balance, _ := new(big.Int).SetString("1000000000000000000000", 10)
+	assert.Equal(t, balance, state.GetBalance(addr1))
+	assert.Equal(t, uint64(2), state.GetNonce(addr1))
+	assert.Equal(t, big.NewInt(33), state.GetBalance(addr2))
+	root := state.IntermediateRoot(false)
+	db.Close()
+
+	// the genesis state is found on a restart
+	db, err = database.NewLDBDatabase(dbDir, 0, 0)
+	assert.NoError(t, err)
+	defer db.Close()
+	state, err = SetupGenesis(db, genesis)
+	assert.NoError(t, err)
+	assert.Equal(t, root, state.IntermediateRoot(false))
+	assert.Equal(t, big.NewInt(33), state.GetBalance(addr2))
+
+	// a data directory of another genesis is refused
+	other := *genesis
+	other.Accounts = map[string]DumpAccount{"7034adcca06964c0dffdb3eae70e8666faa5a63d": {Balance: "1"}}
+	_, err = SetupGenesis(db, &other)
+	assert.Equal(t, ErrGenesisMismatch, err)
+
+	other = *genesis
+	other.Time = genesis.Time.Add(time.Second)
+	_, err = SetupGenesis(db, &other)
+	assert.Equal(t, ErrGenesisMismatch, err)
+
+	other = *genesis
+	other.Config.TransferGas = 3
+	_, err = SetupGenesis(db, &other)
+	assert.Equal(t, ErrGenesisMismatch, err)
+}
+
+func TestGenesis_Hash(t *testing.T) {
+	hash, err := DefaultGenesis().Hash()
+	assert.NoError(t, err)
+
+	genesis := DefaultGenesis()
+	genesis.Accounts["7034adcca06964c0dffdb3eae70e8666faa5a63d"] = DumpAccount{Balance: "1"}
+	genesis.Accounts["d7dae24b1886aff022fa4d8283a28a7a9209583f"] = DumpAccount{Balance: "2", Nonce: 1}
+	withAccounts, err := genesis.Hash()
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, withAccounts)
+
+	// the hash is the same on every node
+	for i := 0; i < 10; i++ {
+		again, err := genesis.Hash()
+		assert.NoError(t, err)
+		assert.Equal(t, withAccounts, again)
+	}
+}
@@ -0,0 +1,165 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+)
+
+var ErrLayerNotFound = errors.New("state of the layer isn't retained")
+
+var (
+	historyPrefix  = []byte("h") // state root by layer
+	latestLayerKey = []byte("history-latest")
+	oldestLayerKey = []byte("history-oldest")
+)
+
+// StateHistory persists the state roots of the applied layers so the state can be reverted to a past layer after a
+// restart. Only the roots of the last retained layers are kept, the retention limits how deep the state can be
+// reverted, not the disk usage: the trie nodes of the pruned layers stay on disk
+// todo: prune the trie nodes which are only referenced by the state of pruned layers
+type StateHistory struct {
+	db        database.Database
+	retention uint64
+}
+
+// NewStateHistory returns the history of the database keeping the roots of the last retention layers, 0 keeps all
+func NewStateHistory(db database.Database, retention uint64) *StateHistory {
+	return &StateHistory{db: db, retention: retention}
+}
+
+func encodeLayer(layer LayerID) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(layer))
+	return data
+}
+
+func historyKey(layer LayerID) []byte {
+	return prefixed(historyPrefix, encodeLayer(layer))
+}
+
+func (sh *StateHistory) getLayer(key []byte) (LayerID, bool, error) {
+	has, err := sh.db.Has(key)
+	if err != nil || !has {
+		return 0, false, err
+	}
+
+	data, err := sh.db.Get(key)
+	if err != nil {
+		return 0, false, err
+	}
+	return LayerID(binary.BigEndian.Uint64(data)), true, nil
+}
+
+// Put records the state root of an applied layer as the latest layer and prunes the layers out of retention
+func (sh *StateHistory) Put(layer LayerID, root common.Hash) error {
+	oldest, ok, err := sh.getLayer(oldestLayerKey)
+	if err != nil {
+		return err
+	}
+	if !ok || layer < oldest {
+		oldest = layer
+	}
+
+	batch := sh.db.NewBatch()
+	if err := batch.Put(historyKey(layer), root[:]); err != nil {
+		return err
+	}
+	if err := batch.Put(latestLayerKey, encodeLayer(layer)); err != nil {
+		return err
+	}
+
+	if sh.retention > 0 {
+		for ; uint64(oldest)+sh.retention <= uint64(layer); oldest++ {
+			if err := batch.Delete(historyKey(oldest)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := batch.Put(oldestLayerKey, encodeLayer(oldest)); err != nil {
+		return err
+	}
+
+	return batch.Write()
+}
+
+// Get returns the state root of the layer or ErrLayerNotFound if the layer isn't retained
+func (sh *StateHistory) Get(layer LayerID) (common.Hash, error) {
+	key := historyKey(layer)
+	has, err := sh.db.Has(key)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if !has {
+		return common.Hash{}, ErrLayerNotFound
+	}
+
+	root, err := sh.db.Get(key)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(root), nil
+}
+
+// Latest returns the last applied layer and its state root or ErrLayerNotFound if no layer was applied
+func (sh *StateHistory) Latest() (LayerID, common.Hash, error) {
+	latest, ok, err := sh.getLayer(latestLayerKey)
+	if err != nil {
+		return 0, common.Hash{}, err
+	}
+	if !ok {
+		return 0, common.Hash{}, ErrLayerNotFound
+	}
+
+	root, err := sh.Get(latest)
+	return latest, root, err
+}
+
+// After returns the layers applied after the retained layer, the layers a revert to the layer removes
+func (sh *StateHistory) After(layer LayerID) ([]LayerID, error) {
+	if _, err := sh.Get(layer); err != nil {
+		return nil, err
+	}
+
+	latest, _, err := sh.getLayer(latestLayerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var after []LayerID
+	for l := layer + 1; l <= latest; l++ {
+		has, err := sh.db.Has(historyKey(l))
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			after = append(after, l)
+		}
+	}
+	return after, nil
+}
+
+// Revert makes the retained layer the latest layer, it removes the roots of the layers applied after it and
+// returns these layers
+func (sh *StateHistory) Revert(layer LayerID) ([]LayerID, error) {
+	reverted, err := sh.After(layer)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := sh.db.NewBatch()
+	for _, l := range reverted {
+		if err := batch.Delete(historyKey(l)); err != nil {
+			return nil, err
+		}
+	}
+	if err := batch.Put(latestLayerKey, encodeLayer(layer)); err != nil {
+		return nil, err
+	}
+
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+	return reverted, nil
+}
@@ -0,0 +1,178 @@
+package state
+
+import (
+	"errors"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/state/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+)
+
+func TestStateHistory_Retention(t *testing.T) {
+	history := NewStateHistory(database.NewMemDatabase(), 3)
+	_, _, err := history.Latest()
+	assert.Equal(t, ErrLayerNotFound, err)
+
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, history.Put(LayerID(i), common.BytesToHash([]byte{byte(i)})))
+	}
+
+	// only the last 3 layers are retained
+	for i := 1; i <= 2; i++ {
+		_, err := history.Get(LayerID(i))
+		assert.Equal(t, ErrLayerNotFound, err)
+	}
+	for i := 3; i <= 5; i++ {
+		root, err := history.Get(LayerID(i))
+		assert.NoError(t, err)
+		assert.Equal(t, common.BytesToHash([]byte{byte(i)}), root)
+	}
+	latest, root, err := history.Latest()
+	assert.NoError(t, err)
+	assert.Equal(t, LayerID(5), latest)
+	assert.Equal(t, common.BytesToHash([]byte{0x05}), root)
+
+	// all layers are retained without a retention
+	history = NewStateHistory(database.NewMemDatabase(), 0)
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, history.Put(LayerID(i), common.BytesToHash([]byte{byte(i)})))
+	}
+	_, err = history.Get(1)
+	assert.NoError(t, err)
+}
+
+func TestStateHistory_Revert(t *testing.T) {
+	history := NewStateHistory(database.NewMemDatabase(), 0)
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, history.Put(LayerID(i), common.BytesToHash([]byte{byte(i)})))
+	}
+
+	_, err := history.Revert(7)
+	assert.Equal(t, ErrLayerNotFound, err)
+
+	after, err := history.After(2)
+	assert.NoError(t, err)
+	assert.Equal(t, []LayerID{3, 4, 5}, after)
+
+	reverted, err := history.Revert(2)
+	assert.NoError(t, err)
+	assert.Equal(t, []LayerID{3, 4, 5}, reverted)
+	latest, _, err := history.Latest()
+	assert.NoError(t, err)
+	assert.Equal(t, LayerID(2), latest)
+	_, err = history.Get(3)
+	assert.Equal(t, ErrLayerNotFound, err)
+
+	assert.NoError(t, history.Put(3, common.BytesToHash([]byte{0x33})))
+	root, err := history.Get(3)
+	assert.NoError(t, err)
+	assert.Equal(t, common.BytesToHash([]byte{0x33}), root)
+}
+
+func TestTransactionProcessor_ResetAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	newProcessor := func(db *database.LDBDatabase) *TransactionProcessor {
+		state, err := New(common.Hash{}, NewDatabase(db))
+		assert.NoError(t, err)
+		return NewTransactionProcessor(state, NewStateHistory(db, 3), NewReceiptStore(db), config.Config{})
+	}
+
+	db, err := database.NewLDBDatabase(dir, 0, 0)
+	assert.NoError(t, err)
+	processor := newProcessor(db)
+	obj1 := createAccount(processor.globalState, []byte{0x41}, 100, 0)
+	obj2 := createAccount(processor.globalState, []byte{0x42}, 0, 0)
+	processor.globalState.Commit(false)
+
+	for i := 1; i <= 5; i++ {
+		_, err := processor.ApplyTransactions(LayerID(i), testBeacon, nil,
+			Transactions{createTransaction(uint64(i-1), obj1.address, obj2.address, 1)})
+		assert.NoError(t, err)
+	}
+	db.Close()
+
+	db, err = database.NewLDBDatabase(dir, 0, 0)
+	assert.NoError(t, err)
+	defer db.Close()
+	processor = newProcessor(db)
+
+	// the restarted processor resumes from the latest layer
+	latest, _, err := processor.history.Latest()
+	assert.NoError(t, err)
+	assert.Equal(t, LayerID(5), latest)
+	assert.NoError(t, processor.Reset(latest))
+	assert.Equal(t, big.NewInt(5), processor.globalState.GetBalance(obj2.address))
+
+	// the layers out of retention can't be reverted to
+	assert.Equal(t, ErrLayerNotFound, processor.Reset(2))
+	assert.Equal(t, ErrLayerNotFound, processor.Reset(9))
+	assert.Equal(t, big.NewInt(5), processor.globalState.GetBalance(obj2.address))
+
+	assert.NoError(t, processor.Reset(3))
+	assert.Equal(t, big.NewInt(3), processor.globalState.GetBalance(obj2.address))
+	assert.Equal(t, uint64(3), processor.globalState.GetNonce(obj1.address))
+	assert.Equal(t, ErrLayerNotFound, processor.Reset(4))
+}
+
+// failingDB fails the batch writes while fail is set
+type failingDB struct {
+	*database.MemDatabase
+	fail bool
+}
+
+type failingBatch struct {
+	database.Batch
+	db *failingDB
+}
+
+func (db *failingDB) NewBatch() database.Batch {
+	return &failingBatch{db.MemDatabase.NewBatch(), db}
+}
+
+func (b *failingBatch) Write() error {
+	if b.db.fail {
+		return errors.New("write failed")
+	}
+	return b.Batch.Write()
+}
+
+func TestTransactionProcessor_ResetReceiptsFailure(t *testing.T) {
+	state, _ := New(common.Hash{}, NewDatabase(database.NewMemDatabase()))
+	db := &failingDB{MemDatabase: database.NewMemDatabase()}
+	receipts := NewReceiptStore(db)
+	processor := NewTransactionProcessor(state, NewStateHistory(database.NewMemDatabase(), 0), receipts, config.Config{})
+	obj1 := createAccount(state, []byte{0x41}, 100, 0)
+	obj2 := createAccount(state, []byte{0x42}, 0, 0)
+	state.Commit(false)
+
+	tx1 := createTransaction(0, obj1.address, obj2.address, 1)
+	tx2 := createTransaction(1, obj1.address, obj2.address, 1)
+	_, err := processor.ApplyTransactions(1, testBeacon, nil, Transactions{tx1})
+	assert.NoError(t, err)
+	_, err = processor.ApplyTransactions(2, testBeacon, nil, Transactions{tx2})
+	assert.NoError(t, err)
+
+	// the history isn't reverted when the receipts of the reverted layers can't be deleted
+	db.fail = true
+	assert.Error(t, processor.Reset(1))
+	latest, _, err := processor.history.Latest()
+	assert.NoError(t, err)
+	assert.Equal(t, LayerID(2), latest)
+	assert.Equal(t, big.NewInt(2), processor.globalState.GetBalance(obj2.address))
+
+	db.fail = false
+	assert.NoError(t, processor.Reset(1))
+	latest, _, err = processor.history.Latest()
+	assert.NoError(t, err)
+	assert.Equal(t, LayerID(1), latest)
+	_, err = receipts.Get(tx2.Hash())
+	assert.Equal(t, ErrReceiptNotFound, err)
+	assert.Equal(t, big.NewInt(1), processor.globalState.GetBalance(obj2.address))
+}
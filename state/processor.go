@@ -1,12 +1,16 @@
 package state
 
 import (
-	"container/list"
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"github.com/seehuhn/mt19937"
 	"github.com/spacemeshos/go-spacemesh/common"
 	"github.com/spacemeshos/go-spacemesh/crypto/sha3"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/rlp"
+	"github.com/spacemeshos/go-spacemesh/state/config"
 	"github.com/spacemeshos/go-spacemesh/trie"
 	"math/big"
 	"sort"
@@ -21,14 +25,14 @@ type Transaction struct {
 	AccountNonce 	uint64
 	Price			*big.Int
 	GasLimit		uint64
-	Recipient 		*common.Address
-	Origin			common.Address //todo: remove this, should be calculated from sig.
+	Recipient 		*common.Address `rlp:"nil"`
 	Amount       	*big.Int
 	Payload      	[]byte
+	Signature		[]byte // a recoverable signature over the transaction by its origin
 
-	//todo: add signatures
-
+	// caches of the hash and the origin, the fields of a transaction mustn't be modified once it's signed
 	hash *common.Hash
+	origin *common.Address
 }
 
 func rlpHash(x interface{}) (h common.Hash) {
@@ -46,52 +50,66 @@ func (tx *Transaction) Hash() common.Hash{
 	return *tx.hash
 }
 
-type Transactions []*Transaction
+// Fee returns the fee charged for applying the transaction for the gas
+func (tx *Transaction) Fee(gas uint64) *big.Int {
+	if tx.Price == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Mul(tx.Price, new(big.Int).SetUint64(gas))
+}
 
-type PseudoRandomizer interface {
-	Uint32() uint32
-	Uint64() uint64
+// Cost returns the amount of the transaction and its fee for the gas
+func (tx *Transaction) Cost(gas uint64) *big.Int {
+	return new(big.Int).Add(tx.Amount, tx.Fee(gas))
 }
 
+type Transactions []*Transaction
+
 type StatePreImages struct {
 	rootHash common.Hash
 	preImages Transactions
 }
 
 type TransactionProcessor struct {
-	rand PseudoRandomizer
+	conf config.Config
+	fees *big.Int // collected from the transactions applied since the last rewards were paid
+	receipts *ReceiptStore
+	history *StateHistory
 	globalState *StateDB
-	prevStates map[LayerID]common.Hash
-	currentLayer LayerID
-	rootHash common.Hash
-	stateQueue list.List
 	db *trie.Database
 	mu sync.Mutex
 }
 
-const maxPastStates = 20
-
-func NewTransactionProcessor(rnd PseudoRandomizer, db *StateDB) *TransactionProcessor{
+// NewTransactionProcessor returns a processor applying the layers on the state. The state roots of the applied layers
+// are recorded in the history, a processor of a restarted node resumes from its latest layer by a Reset
+func NewTransactionProcessor(db *StateDB, history *StateHistory, receipts *ReceiptStore, conf config.Config) *TransactionProcessor{
 	return &TransactionProcessor{
-		rand: rnd,
+		conf: conf,
+		fees: new(big.Int),
+		receipts: receipts,
+		history: history,
 		globalState:db,
-		prevStates : make(map[LayerID]common.Hash),
-		currentLayer: 0,
-		rootHash: common.Hash{},
-		stateQueue: list.List{},
 		db : db.TrieDB(),
 		mu : sync.Mutex{}, //sync between reset and apply transactions
 	}
 }
 
 //should receive sort predicate
-func (tp *TransactionProcessor) ApplyTransactions(layer LayerID, transactions Transactions) (uint32, error){
-	//todo: need to seed the mersenne twister with random beacon seed
-
+// ApplyTransactions applies the transactions of the layer in the order seeded by the beacon of the layer, see
+// LayerBeacon, and pays the layer reward and the fees to the coinbases of the layer's valid blocks. A receipt of each
+// transaction is persisted with the state root of the layer, the number of failed transactions is returned
+func (tp *TransactionProcessor) ApplyTransactions(layer LayerID, beacon common.Hash, coinbases []common.Address, transactions Transactions) (uint32, error){
 	txs := tp.mergeDoubles(transactions)
 	tp.mu.Lock()
 	defer tp.mu.Unlock()
-	failed := tp.Process(tp.randomSort(txs), tp.coalesceTransactionsBySender(txs))
+	receipts := tp.Process(tp.randomSort(beacon, txs), tp.coalesceTransactionsBySender(txs))
+	failed := uint32(0)
+	for _, receipt := range receipts {
+		if receipt.Status.Failed() {
+			failed++
+		}
+	}
+	tp.payRewards(layer, coinbases)
 	newHash , err := tp.globalState.Commit(false)
 	log.Info("new state root for layer %v is %x", layer, newHash)
 	if err != nil {
@@ -99,30 +117,66 @@ func (tp *TransactionProcessor) ApplyTransactions(layer LayerID, transactions Tr
 		return failed,err
 	}
 
-	tp.stateQueue.PushBack(newHash)
-	if tp.stateQueue.Len() > maxPastStates {
-		hash := tp.stateQueue.Remove(tp.stateQueue.Back())
-		tp.db.Commit(hash.(common.Hash),false)
+	for _, receipt := range receipts {
+		receipt.Layer = layer
+		receipt.Root = newHash
+	}
+	if err := tp.receipts.PutLayer(layer, receipts); err != nil {
+		log.Error("could not write receipts of layer %v: %v", layer, err)
+		return failed, err
+	}
+
+	// the state of every layer is written to disk so it can be reverted to after a restart
+	if err := tp.db.Commit(newHash, false); err != nil {
+		log.Error("db write error %v", err)
+		return failed, err
+	}
+	if err := tp.history.Put(layer, newHash); err != nil {
+		log.Error("could not record the state root of layer %v: %v", layer, err)
+		return failed, err
 	}
-	tp.prevStates[layer] = newHash
-	tp.db.Reference(newHash, common.Hash{})
 
 	return failed, nil
 }
 
-func (tp *TransactionProcessor) Reset(layer LayerID){
+// Reset reverts the state to the state after the layer was applied, it returns ErrLayerNotFound if the layer isn't
+// retained in the history. The receipts of the reverted layers are removed
+func (tp *TransactionProcessor) Reset(layer LayerID) error {
 	tp.mu.Lock()
 	defer tp.mu.Unlock()
-	if state, ok := tp.prevStates[layer]; ok {
-		newState, err := New(state, tp.globalState.db)
-		log.Info("reverted, new root %x", newState.IntermediateRoot(false))
-		if err != nil {
-			panic("cannot revert- improper state")
+	root, err := tp.history.Get(layer)
+	if err != nil {
+		log.Error("cannot revert to layer %v: %v", layer, err)
+		return err
+	}
+
+	newState, err := New(root, tp.globalState.db)
+	if err != nil {
+		log.Error("cannot revert to layer %v, improper state %x: %v", layer, root, err)
+		return err
+	}
+
+	// the receipts are deleted before the history is reverted, a failed Reset leaves the layers in the history
+	// so it can be retried
+	reverted, err := tp.history.After(layer)
+	if err != nil {
+		return err
+	}
+	for _, l := range reverted {
+		if err := tp.receipts.DeleteLayer(l); err != nil {
+			log.Error("could not delete receipts of reverted layer %v: %v", l, err)
+			return err
 		}
+	}
 
-		tp.globalState = newState
-		tp.pruneAfterRevert(layer)
+	if _, err := tp.history.Revert(layer); err != nil {
+		return err
 	}
+
+	log.Info("reverted to layer %v, new root %x", layer, root)
+	tp.globalState = newState
+	tp.fees = new(big.Int)
+	return nil
 }
 
 
@@ -139,19 +193,57 @@ func (tp *TransactionProcessor) mergeDoubles(transactions Transactions) Transact
 	return merged
 }
 
-func (tp *TransactionProcessor) randomSort(transactions Transactions) Transactions{
-	vecLen := len(transactions)
-	for i := range transactions {
-		swp := int(tp.rand.Uint32()) % vecLen
+// LayerBeacon returns the seed of the transaction order of a layer, it is derived from the layer id and the ids of
+// the layer's valid blocks agreed on by the hare consensus so all nodes applying the layer agree on it. The beacon
+// doesn't depend on the layer's transactions, a sender can't grind the hashes of its transactions to get a preferred
+// position in the order
+func LayerBeacon(layer LayerID, blocks []uint64) common.Hash {
+	ids := append([]uint64{}, blocks...)
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return rlpHash([]interface{}{uint64(layer), ids})
+}
+
+// randomSort orders the transactions by hash and then shuffles them with a Fisher-Yates shuffle seeded by the beacon,
+// the order doesn't depend on the order the transactions were received in
+func (tp *TransactionProcessor) randomSort(beacon common.Hash, transactions Transactions) Transactions{
+	sort.Slice(transactions, func(i, j int) bool {
+		return bytes.Compare(transactions[i].Hash().Bytes(), transactions[j].Hash().Bytes()) < 0
+	})
+
+	rng := mt19937.New()
+	seed := make([]uint64, len(beacon)/8)
+	for i := range seed {
+		seed[i] = binary.BigEndian.Uint64(beacon[i*8:])
+	}
+	rng.SeedFromSlice(seed)
+
+	for i := len(transactions) - 1; i > 0; i-- {
+		swp := uniform(rng, uint64(i+1))
 		transactions[i], transactions[swp] = transactions[swp], transactions[i]
 	}
 	return transactions
 }
 
+// uniform returns a uniformly distributed number in [0, n), the values of the generator above the largest multiple
+// of n are rejected so no number is more likely than the others
+func uniform(rng *mt19937.MT19937, n uint64) uint64 {
+	max := ^uint64(0)
+	limit := max - max%n
+	for {
+		if v := rng.Uint64(); v < limit {
+			return v % n
+		}
+	}
+}
+
 func (tp *TransactionProcessor) coalesceTransactionsBySender(transactions Transactions) map[common.Address][]*Transaction {
 	trnsBySender := make(map[common.Address][]*Transaction)
 	for _, trns := range transactions {
-		trnsBySender[trns.Origin] = append(trnsBySender[trns.Origin], trns)
+		// transactions without a valid signature are grouped under the zero address and rejected when applied
+		origin, _ := trns.Origin()
+		trnsBySender[origin] = append(trnsBySender[origin], trns)
 	}
 
 	for key := range trnsBySender{
@@ -163,16 +255,18 @@ func (tp *TransactionProcessor) coalesceTransactionsBySender(transactions Transa
 	return trnsBySender
 }
 
-func (tp *TransactionProcessor) Process(transactions Transactions, trnsBySender map[common.Address][]*Transaction) (errors uint32){
+// Process applies the transactions grouped by sender and returns a receipt of each transaction
+func (tp *TransactionProcessor) Process(transactions Transactions, trnsBySender map[common.Address][]*Transaction) Receipts{
 	senderPut := make(map[common.Address]struct{})
 	sortedOriginByTransactions := make([]common.Address, 0,10)
-	errors = 0
+	receipts := make(Receipts, 0, len(transactions))
 	// The order of the transactions determines the order addresses by which we take transactions
 	// Maybe refactor this
 	for _, trans := range transactions {
-		if _, ok := senderPut[trans.Origin]; !ok {
-			sortedOriginByTransactions = append(sortedOriginByTransactions, trans.Origin)
-			senderPut[trans.Origin] = struct{}{}
+		origin, _ := trans.Origin()
+		if _, ok := senderPut[origin]; !ok {
+			sortedOriginByTransactions = append(sortedOriginByTransactions, origin)
+			senderPut[origin] = struct{}{}
 		}
 	}
 
@@ -180,62 +274,78 @@ func (tp *TransactionProcessor) Process(transactions Transactions, trnsBySender
 	for _, origin := range sortedOriginByTransactions {
 		for _, trns := range trnsBySender[origin] {
 			//todo: should we abort all transaction processing if we failed this one?
+			receipt := &Receipt{TxHash: trns.Hash(), Status: ReceiptApplied, Fee: trns.Fee(tp.conf.TransferGas), Origin: origin}
+			if trns.Recipient != nil {
+				receipt.Recipient = *trns.Recipient
+			}
 			err := tp.ApplyTransaction(trns)
 			if err != nil {
-				errors++
+				receipt.Status = receiptStatus(err)
+				receipt.Fee = new(big.Int)
 				log.Error("transaction aborted: %v", err)
 			}
-
+			receipts = append(receipts, receipt)
 		}
 	}
-	return errors
+	return receipts
 }
 
 
-func (tp *TransactionProcessor) pruneAfterRevert(targetLayerID LayerID){
-	//needs to be called under mutex lock
-	for i:= tp.currentLayer; i >= targetLayerID; i-- {
-		if hash, ok := tp.prevStates[i]; ok {
-			if tp.stateQueue.Front().Value != hash {
-				panic("old state wasn't found")
-			}
-			tp.stateQueue.Remove(tp.stateQueue.Front())
-			tp.db.Dereference(hash)
-			delete(tp.prevStates, i)
-		}
-	}
-}
-
-func (tp *TransactionProcessor) checkNonce(trns *Transaction) bool{
-	return tp.globalState.GetNonce(trns.Origin) == trns.AccountNonce
+func (tp *TransactionProcessor) checkNonce(origin common.Address, trns *Transaction) bool{
+	return tp.globalState.GetNonce(origin) == trns.AccountNonce
 }
 
 var(
+	ErrSignature = "invalid signature"
 	ErrOrigin = "origin account doesnt exist"
 	ErrFunds = "insufficient funds"
 	ErrNonce = "incorrect nonce"
+	ErrGasLimit = "gas limit too low"
+	ErrRecipient = "missing recipient"
 )
-//todo: mining fees...
+
+// ApplyTransaction transfers the amount of the transaction and charges its fee from the origin account,
+// the fee is paid to the coinbases of the layer
 func (tp *TransactionProcessor) ApplyTransaction(trans *Transaction) error{
-	if !tp.globalState.Exist(trans.Origin) {
+	addr, err := trans.Origin()
+	if err != nil {
+		log.Error(ErrSignature + ": %v", err)
+		return  errors.New(ErrSignature)
+	}
+
+	if !tp.globalState.Exist(addr) {
 		return  fmt.Errorf(ErrOrigin)
 	}
 
-	origin := tp.globalState.GetOrNewStateObj(trans.Origin)
+	if trans.GasLimit < tp.conf.TransferGas {
+		log.Error(ErrGasLimit + " have: %v need: %v", trans.GasLimit, tp.conf.TransferGas)
+		return  errors.New(ErrGasLimit)
+	}
+
+	if trans.Recipient == nil {
+		log.Error(ErrRecipient + " in transaction %x", trans.Hash())
+		return  errors.New(ErrRecipient)
+	}
+
+	origin := tp.globalState.GetOrNewStateObj(addr)
+	fee := trans.Fee(tp.conf.TransferGas)
+	cost := trans.Cost(tp.conf.TransferGas)
 
 	//todo: should we allow to spend all accounts data?
-	if origin.Balance().Cmp(trans.Amount) <= 0 {
-		log.Error(ErrFunds + " have: %v need: %v", origin.Balance(), trans.Amount)
+	if origin.Balance().Cmp(cost) <= 0 {
+		log.Error(ErrFunds + " have: %v need: %v", origin.Balance(), cost)
 		return  fmt.Errorf(ErrFunds)
 	}
 
-	if !tp.checkNonce(trans) {
-		log.Error(ErrNonce + " should be %v actual %v", tp.globalState.GetNonce(trans.Origin), trans.AccountNonce)
+	if !tp.checkNonce(addr, trans) {
+		log.Error(ErrNonce + " should be %v actual %v", tp.globalState.GetNonce(addr), trans.AccountNonce)
 		return  fmt.Errorf(ErrNonce)
 	}
 
-	tp.globalState.SetNonce(trans.Origin, tp.globalState.GetNonce(trans.Origin) + 1)
-	transfer(tp.globalState,trans.Origin, *trans.Recipient, trans.Amount)
+	tp.globalState.SetNonce(addr, tp.globalState.GetNonce(addr) + 1)
+	transfer(tp.globalState, addr, *trans.Recipient, trans.Amount)
+	tp.globalState.SubBalance(addr, fee)
+	tp.fees.Add(tp.fees, fee)
 
 	return nil
 }
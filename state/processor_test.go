@@ -1,10 +1,12 @@
 package state
 
 import (
-	"github.com/seehuhn/mt19937"
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/state/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"math/big"
@@ -20,16 +22,28 @@ type ProcessorStateSuite struct {
 }
 
 func (s *ProcessorStateSuite) SetupTest() {
-	rng := rand.New(mt19937.New())
-
 	s.db = database.NewMemDatabase()
 	s.state, _ = New(common.Hash{}, NewDatabase(s.db))
 
-	s.processor = NewTransactionProcessor(rng, s.state)
+	// the balances of the suite's tests don't account for fees and rewards
+	s.processor = NewTransactionProcessor(s.state, NewStateHistory(database.NewMemDatabase(), 0), NewReceiptStore(database.NewMemDatabase()), config.Config{})
+}
+
+// testKeys are the keys of the test accounts by their addresses
+var testKeys = make(map[common.Address]crypto.PrivateKey)
+
+// accountAddress returns the address of a test account, its key is derived from the seed
+func accountAddress(seed []byte) common.Address {
+	data := make([]byte, 32)
+	copy(data[32-len(seed):], seed)
+	key, _ := crypto.NewPrivateKey(data)
+	addr := PublicKeyToAddress(key.GetPublicKey())
+	testKeys[addr] = key
+	return addr
 }
 
-func createAccount(state *StateDB, addr []byte, balance int64, nonce uint64) *StateObj{
-	addr1 := toAddr(addr)
+func createAccount(state *StateDB, seed []byte, balance int64, nonce uint64) *StateObj{
+	addr1 := accountAddress(seed)
 	obj1 := state.GetOrNewStateObj(addr1)
 	obj1.AddBalance(big.NewInt(balance))
 	obj1.SetNonce(nonce)
@@ -37,11 +51,13 @@ func createAccount(state *StateDB, addr []byte, balance int64, nonce uint64) *St
 	return  obj1
 }
 
+// testBeacon seeds the transaction order of the layers applied in tests
+var testBeacon = LayerBeacon(1, []uint64{1})
+
 func createTransaction(nonce uint64,
 	origin common.Address, destination common.Address, amount int64) *Transaction{
-	return &Transaction{
+	tx := &Transaction{
 		AccountNonce: nonce,
-		Origin: origin,
 		Recipient:&destination,
 		Amount: big.NewInt(amount),
 		GasLimit:10,
@@ -49,6 +65,10 @@ func createTransaction(nonce uint64,
 		hash:nil,
 		Payload:nil,
 	}
+	if key, ok := testKeys[origin]; ok {
+		SignTransaction(tx, key)
+	}
+	return tx
 }
 
 func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction() {
@@ -67,7 +87,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction() {
 	}
 
 
-	failed, err := s.processor.ApplyTransactions(1, transactions)
+	failed, err := s.processor.ApplyTransactions(1, testBeacon, nil, transactions)
 	assert.NoError(s.T(), err)
 	assert.True(s.T(), failed == 0)
 
@@ -77,17 +97,17 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction() {
 	assert.Equal(s.T(), uint64(1),s.state.GetNonce(obj1.address))
 
 	want := `{
-	"root": "7ed462059ad2df6754b5aa1f3d8a150bb9b0e1c4eb50b6217a8fc4ecbec7fb28",
+	"root": "203a44c3814790fb60f3d32d354df7cd5971fca0ad51272388184d324fab4f91",
 	"accounts": {
-		"0000000000000000000000000000000000000001": {
-			"balance": "20",
-			"nonce": 1
-		},
-		"0000000000000000000000000000000000000002": {
+		"5ff73eaeec7013c9f18f2b954cd83e33c5234b3b": {
 			"balance": "44",
 			"nonce": 0
 		},
-		"0000000000000000000000000000000000000102": {
+		"7034adcca06964c0dffdb3eae70e8666faa5a63d": {
+			"balance": "20",
+			"nonce": 1
+		},
+		"d7dae24b1886aff022fa4d8283a28a7a9209583f": {
 			"balance": "2",
 			"nonce": 10
 		}
@@ -117,7 +137,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_DoubleTr
 	}
 
 
-	failed, err := s.processor.ApplyTransactions(1, transactions)
+	failed, err := s.processor.ApplyTransactions(1, testBeacon, nil, transactions)
 	assert.NoError(s.T(), err)
 	assert.True(s.T(), failed == 0)
 
@@ -126,17 +146,17 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_DoubleTr
 	assert.Equal(s.T(), big.NewInt(20),s.state.GetBalance(obj1.address))
 
 	want := `{
-	"root": "7ed462059ad2df6754b5aa1f3d8a150bb9b0e1c4eb50b6217a8fc4ecbec7fb28",
+	"root": "203a44c3814790fb60f3d32d354df7cd5971fca0ad51272388184d324fab4f91",
 	"accounts": {
-		"0000000000000000000000000000000000000001": {
-			"balance": "20",
-			"nonce": 1
-		},
-		"0000000000000000000000000000000000000002": {
+		"5ff73eaeec7013c9f18f2b954cd83e33c5234b3b": {
 			"balance": "44",
 			"nonce": 0
 		},
-		"0000000000000000000000000000000000000102": {
+		"7034adcca06964c0dffdb3eae70e8666faa5a63d": {
+			"balance": "20",
+			"nonce": 1
+		},
+		"d7dae24b1886aff022fa4d8283a28a7a9209583f": {
 			"balance": "2",
 			"nonce": 10
 		}
@@ -158,7 +178,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_Errors()
 	}
 
 
-	failed,err := s.processor.ApplyTransactions(1, transactions)
+	failed,err := s.processor.ApplyTransactions(1, testBeacon, nil, transactions)
 	assert.NoError(s.T(), err)
 	assert.True(s.T(), failed == 0)
 
@@ -171,12 +191,20 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_Errors()
 	assert.Error(s.T(), err)
 	assert.Equal(s.T(), err.Error(), ErrFunds)
 
-	addr := toAddr([]byte{0x01, 0x01})
+	addr := accountAddress([]byte{0x01, 0x01})
 
 	//Test origin
 	err = s.processor.ApplyTransaction(createTransaction(obj1.Nonce(),addr, obj2.address, 21))
 	assert.Error(s.T(), err)
 	assert.Equal(s.T(), err.Error(), ErrOrigin)
+
+	//Test recipient
+	missing := &Transaction{AccountNonce: obj1.Nonce(), Amount: big.NewInt(1), GasLimit: 10, Price: big.NewInt(1)}
+	assert.NoError(s.T(), SignTransaction(missing, testKeys[obj1.address]))
+	err = s.processor.ApplyTransaction(missing)
+	assert.Error(s.T(), err)
+	assert.Equal(s.T(), err.Error(), ErrRecipient)
+	assert.Equal(s.T(), obj1.Nonce(), s.state.GetNonce(obj1.address))
 }
 
 
@@ -193,7 +221,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_OrderByN
 		createTransaction(obj1.Nonce(), obj1.address, obj2.address, 1),
 	}
 
-	s.processor.ApplyTransactions(1, transactions)
+	s.processor.ApplyTransactions(1, testBeacon, nil, transactions)
 	//assert.Error(s.T(), err)
 
 	got := string(s.state.Dump())
@@ -202,17 +230,17 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_OrderByN
 	assert.Equal(s.T(), big.NewInt(2),s.state.GetBalance(obj2.address))
 
 	want := `{
-	"root": "e5212ec1f253fc4d7f77a591f66770ccae676ece70823287638ad7c5f988bced",
+	"root": "b095f175c4f34c1d459cfc343b988fd499b1e5d83f4f659f0921ef3a6448f83e",
 	"accounts": {
-		"0000000000000000000000000000000000000001": {
-			"balance": "1",
-			"nonce": 4
-		},
-		"0000000000000000000000000000000000000002": {
+		"5ff73eaeec7013c9f18f2b954cd83e33c5234b3b": {
 			"balance": "47",
 			"nonce": 0
 		},
-		"0000000000000000000000000000000000000102": {
+		"7034adcca06964c0dffdb3eae70e8666faa5a63d": {
+			"balance": "1",
+			"nonce": 4
+		},
+		"d7dae24b1886aff022fa4d8283a28a7a9209583f": {
 			"balance": "2",
 			"nonce": 10
 		}
@@ -235,7 +263,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_Reset() {
 	}
 
 
-	failed, err := s.processor.ApplyTransactions(1, transactions)
+	failed, err := s.processor.ApplyTransactions(1, testBeacon, nil, transactions)
 	assert.NoError(s.T(), err)
 	assert.True(s.T(), failed == 0)
 
@@ -245,24 +273,24 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_Reset() {
 	}
 
 
-	failed, err = s.processor.ApplyTransactions(2, transactions)
+	failed, err = s.processor.ApplyTransactions(2, testBeacon, nil, transactions)
 	assert.True(s.T(), failed == 0)
 	assert.NoError(s.T(), err)
 
 	got := string(s.processor.globalState.Dump())
 
 	want := `{
-	"root": "ad5e89c8f94168027dc1df5da112c76c330ffcbc7df3e73c29f1728e488b77b2",
+	"root": "27ffe346868922243270a990adfab32e5dfb74281c1fefd5f91256cfbd86887f",
 	"accounts": {
-		"0000000000000000000000000000000000000001": {
-			"balance": "29",
-			"nonce": 2
-		},
-		"0000000000000000000000000000000000000002": {
+		"5ff73eaeec7013c9f18f2b954cd83e33c5234b3b": {
 			"balance": "44",
 			"nonce": 0
 		},
-		"0000000000000000000000000000000000000102": {
+		"7034adcca06964c0dffdb3eae70e8666faa5a63d": {
+			"balance": "29",
+			"nonce": 2
+		},
+		"d7dae24b1886aff022fa4d8283a28a7a9209583f": {
 			"balance": "33",
 			"nonce": 11
 		}
@@ -272,24 +300,24 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_Reset() {
 		s.T().Errorf("dump mismatch:\ngot: %s\nwant: %s\n", got, want)
 	}
 
-	s.processor.Reset(1)
+	assert.NoError(s.T(), s.processor.Reset(1))
 
 	got = string(s.processor.globalState.Dump())
 
 	assert.Equal(s.T(), big.NewInt(20),s.processor.globalState.GetBalance(obj1.address))
 
 	want = `{
-	"root": "0f8918fdba9e0a1542ac89ff9a60c657ff4c5800a700266ecfdcfc2d4e1fad3e",
+	"root": "a6826f2ec0459587f2862065f0c1d199254e3f9aa1d15c97c615cf7dc5910cad",
 	"accounts": {
-		"0000000000000000000000000000000000000001": {
-			"balance": "20",
-			"nonce": 1
-		},
-		"0000000000000000000000000000000000000002": {
+		"5ff73eaeec7013c9f18f2b954cd83e33c5234b3b": {
 			"balance": "44",
 			"nonce": 0
 		},
-		"0000000000000000000000000000000000000102": {
+		"7034adcca06964c0dffdb3eae70e8666faa5a63d": {
+			"balance": "20",
+			"nonce": 1
+		},
+		"d7dae24b1886aff022fa4d8283a28a7a9209583f": {
 			"balance": "42",
 			"nonce": 10
 		}
@@ -349,9 +377,9 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_Multilayer() {
 				srcAccount.address, dstAccount.address, int64(rand.Uint64() % srcAccount.Balance().Uint64() )/100)
 			trns = append(trns,  t)
 
-			log.Info("transaction %v nonce %v amount %v", t.Origin.Hex(), t.AccountNonce, t.Amount)
+			log.Info("transaction %v nonce %v amount %v", srcAccount.address.Hex(), t.AccountNonce, t.Amount)
 		}
-		failed, err := s.processor.ApplyTransactions(LayerID(i), trns)
+		failed, err := s.processor.ApplyTransactions(LayerID(i), testBeacon, nil, trns)
 		assert.NoError(s.T(),err)
 		assert.True(s.T(), failed == 0)
 
@@ -361,7 +389,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_Multilayer() {
 		}
 
 		if i == revertToLayer + revertAfterLayer {
-			s.processor.Reset(LayerID(revertToLayer))
+			assert.NoError(s.T(), s.processor.Reset(LayerID(revertToLayer)))
 			got := string(s.processor.globalState.Dump())
 
 			if got != want {
@@ -377,17 +405,104 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_Multilayer() {
 }
 
 
+func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_Signature() {
+	obj1 := createAccount(s.state, []byte{0x01}, 21, 0)
+	obj2 := createAccount(s.state, []byte{0x01, 02}, 1, 10)
+	s.state.Commit(false)
+
+	unsigned := &Transaction{AccountNonce: 0, Recipient: &obj2.address, Amount: big.NewInt(1), Price: big.NewInt(1)}
+	err := s.processor.ApplyTransaction(unsigned)
+	assert.Error(s.T(), err)
+	assert.Equal(s.T(), ErrSignature, err.Error())
+
+	garbage := createTransaction(obj1.Nonce(), obj1.address, obj2.address, 1)
+	garbage.Signature = garbage.Signature[1:]
+	err = s.processor.ApplyTransaction(garbage)
+	assert.Error(s.T(), err)
+	assert.Equal(s.T(), ErrSignature, err.Error())
+
+	// a changed transaction isn't signed by the origin account
+	changed := createTransaction(obj1.Nonce(), obj1.address, obj2.address, 1)
+	changed.Amount = big.NewInt(20)
+	assert.Error(s.T(), s.processor.ApplyTransaction(changed))
+
+	failed, err := s.processor.ApplyTransactions(1, testBeacon, nil, Transactions{unsigned, garbage, changed})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), uint32(3), failed)
+	assert.Equal(s.T(), big.NewInt(21), s.state.GetBalance(obj1.address))
+	assert.Equal(s.T(), uint64(0), s.state.GetNonce(obj1.address))
+}
+
+func TestTransaction_Origin(t *testing.T) {
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	recipient := accountAddress([]byte{0x02})
+	tx := &Transaction{AccountNonce: 3, Recipient: &recipient, Amount: big.NewInt(5), Price: big.NewInt(1), GasLimit: 10}
+
+	_, err = tx.Origin()
+	assert.Error(t, err)
+
+	unsignedHash := tx.Hash()
+	assert.NoError(t, SignTransaction(tx, priv))
+	assert.NotEqual(t, unsignedHash, tx.Hash())
+
+	origin, err := tx.Origin()
+	assert.NoError(t, err)
+	assert.Equal(t, PublicKeyToAddress(pub), origin)
+
+	// the origin is recovered by other nodes from the signed fields only
+	received := &Transaction{AccountNonce: 3, Recipient: &recipient, Amount: big.NewInt(5), Price: big.NewInt(1), GasLimit: 10,
+		Signature: tx.Signature}
+	origin, err = received.Origin()
+	assert.NoError(t, err)
+	assert.Equal(t, PublicKeyToAddress(pub), origin)
+	assert.Equal(t, tx.Hash(), received.Hash())
+}
+
+func TestTransaction_MalleatedSignature(t *testing.T) {
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	recipient := accountAddress([]byte{0x02})
+	newTx := func(sig []byte) *Transaction {
+		return &Transaction{AccountNonce: 3, Recipient: &recipient, Amount: big.NewInt(5), Price: big.NewInt(1), GasLimit: 10,
+			Signature: sig}
+	}
+	tx := newTx(nil)
+	assert.NoError(t, SignTransaction(tx, priv))
+
+	// the same signature of an uncompressed key
+	uncompressed := append([]byte{}, tx.Signature...)
+	uncompressed[0] -= 4
+	_, err = newTx(uncompressed).Origin()
+	assert.Error(t, err)
+
+	// the same signature with a high S, which recovers the same key with the other recovery id
+	highS := append([]byte{}, tx.Signature...)
+	s := new(big.Int).Sub(btcec.S256().N, new(big.Int).SetBytes(highS[33:]))
+	copy(highS[33:], common.LeftPadBytes(s.Bytes(), 32))
+	highS[0] ^= 1
+	malleated := newTx(highS)
+	_, err = malleated.Origin()
+	assert.Error(t, err)
+	assert.NotEqual(t, tx.Hash(), malleated.Hash())
+
+	// a high S is canonicalized when signing
+	canonicalizeSignature(highS)
+	assert.Equal(t, tx.Signature, highS)
+	origin, err := newTx(highS).Origin()
+	assert.NoError(t, err)
+	assert.Equal(t, PublicKeyToAddress(pub), origin)
+}
+
 func TestTransactionProcessor_ApplyTransactionTestSuite(t *testing.T){
 	suite.Run(t, new(ProcessorStateSuite))
 }
 
 func TestTransactionProcessor_randomSort(t *testing.T) {
-	rng := rand.New(mt19937.New())
-	rng.Seed(1)
 	db := database.NewMemDatabase()
 	state, _ := New(common.Hash{}, NewDatabase(db))
 
-	processor := NewTransactionProcessor(rng, state)
+	processor := NewTransactionProcessor(state, NewStateHistory(database.NewMemDatabase(), 0), NewReceiptStore(database.NewMemDatabase()), config.Config{})
 
 	obj1 := createAccount(state,[]byte{0x01}, 2, 0)
 	obj2 := createAccount(state,[]byte{0x01, 02}, 1, 10)
@@ -399,16 +514,108 @@ func TestTransactionProcessor_randomSort(t *testing.T) {
 		createTransaction(obj1.Nonce(),obj1.address, obj2.address, 4),
 		createTransaction(obj1.Nonce(),obj2.address, obj1.address, 5),
 	}
+	beacon := LayerBeacon(1, []uint64{7, 3, 5})
+
+	// the order doesn't depend on the order the transactions were received in
+	trans := processor.randomSort(beacon, append(Transactions{}, transactions...))
+	reversed := Transactions{transactions[4], transactions[3], transactions[2], transactions[1], transactions[0]}
+	assert.Equal(t, trans, processor.randomSort(beacon, reversed))
+	assert.ElementsMatch(t, transactions, trans)
+
+	// the beacon doesn't depend on the order of the valid blocks
+	assert.Equal(t, beacon, LayerBeacon(1, []uint64{5, 7, 3}))
+
+	// the beacon of another layer or other valid blocks orders the same transactions differently
+	assert.NotEqual(t, beacon, LayerBeacon(2, []uint64{7, 3, 5}))
+	assert.NotEqual(t, beacon, LayerBeacon(1, []uint64{7, 3}))
+	assert.NotEqual(t, trans, processor.randomSort(LayerBeacon(2, []uint64{7, 3, 5}), append(Transactions{}, transactions...)))
+}
+
+func TestTransactionProcessor_randomSortUnbiased(t *testing.T) {
+	_, processor := newTestProcessor(config.Config{})
+	items := Transactions{
+		createTransaction(0, accountAddress([]byte{0x01}), accountAddress([]byte{0x02}), 1),
+		createTransaction(0, accountAddress([]byte{0x01}), accountAddress([]byte{0x02}), 2),
+		createTransaction(0, accountAddress([]byte{0x01}), accountAddress([]byte{0x02}), 3),
+	}
+
+	const rounds = 6000
+	counts := make(map[string]int)
+	for i := 0; i < rounds; i++ {
+		shuffled := processor.randomSort(LayerBeacon(LayerID(i), nil), append(Transactions{}, items...))
+		permutation := ""
+		for _, trns := range shuffled {
+			permutation += trns.Amount.String()
+		}
+		counts[permutation]++
+	}
 
-	expected := Transactions{
-		transactions[4],
-		transactions[3],
-		transactions[1],
-		transactions[0],
-		transactions[2],
+	// each of the 6 permutations is expected rounds/6 times, a biased shuffle of 3 items is off by rounds/27
+	assert.Equal(t, 6, len(counts))
+	for permutation, count := range counts {
+		assert.InDelta(t, rounds/6, count, rounds/40, "permutation %v", permutation)
 	}
+}
+
+// applyLayers applies the layers on a fresh state of the accounts and returns the state roots and the receipts
+// of the layers as seen by a node
+func applyLayers(t *testing.T, balances map[string]int64, layers []Transactions) ([]common.Hash, *ReceiptStore) {
+	state, _ := New(common.Hash{}, NewDatabase(database.NewMemDatabase()))
+	receipts := NewReceiptStore(database.NewMemDatabase())
+	processor := NewTransactionProcessor(state, NewStateHistory(database.NewMemDatabase(), 0), receipts, config.Config{TransferGas: 1})
+	for seed, balance := range balances {
+		createAccount(state, []byte(seed), balance, 0)
+	}
+	state.Commit(false)
 
-	trans := processor.randomSort(transactions)
+	roots := make([]common.Hash, 0, len(layers))
+	for i, layer := range layers {
+		_, err := processor.ApplyTransactions(LayerID(i+1), LayerBeacon(LayerID(i+1), []uint64{uint64(i)}), nil, layer)
+		assert.NoError(t, err)
+		roots = append(roots, state.IntermediateRoot(false))
+	}
+	return roots, receipts
+}
 
-	assert.Equal(t, expected, trans)
+func TestTransactionProcessor_OrderDeterminism(t *testing.T) {
+	balances := map[string]int64{"a": 100, "b": 5, "c": 50}
+	a, b, c := accountAddress([]byte("a")), accountAddress([]byte("b")), accountAddress([]byte("c"))
+
+	// the transactions conflict so a different order leads to a different state: two transactions of the same nonce
+	// and a transaction which is funded only if the transfer to its origin is applied before it
+	layers := []Transactions{
+		{
+			createPricedTransaction(0, a, b, 30, 1),
+			createPricedTransaction(0, a, c, 40, 1),
+			createPricedTransaction(0, b, c, 20, 1),
+			createPricedTransaction(0, c, a, 10, 1),
+		},
+		{
+			createPricedTransaction(1, a, c, 5, 1),
+			createPricedTransaction(1, a, b, 6, 1),
+			createPricedTransaction(1, c, b, 7, 1),
+			createPricedTransaction(0, b, a, 3, 1),
+		},
+	}
+
+	// another node receives the transactions in another order and with duplicates from several blocks
+	shuffled := make([]Transactions, len(layers))
+	for i, layer := range layers {
+		for j := len(layer) - 1; j >= 0; j-- {
+			shuffled[i] = append(shuffled[i], layer[j], layer[j])
+		}
+	}
+
+	roots1, receipts1 := applyLayers(t, balances, layers)
+	roots2, receipts2 := applyLayers(t, balances, shuffled)
+	assert.Equal(t, roots1, roots2)
+	for _, layer := range layers {
+		for _, trns := range layer {
+			receipt1, err := receipts1.Get(trns.Hash())
+			assert.NoError(t, err)
+			receipt2, err := receipts2.Get(trns.Hash())
+			assert.NoError(t, err)
+			assert.Equal(t, receipt1, receipt2)
+		}
+	}
 }
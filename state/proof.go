@@ -0,0 +1,108 @@
+package state
+
+import (
+	"errors"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/rlp"
+	"github.com/spacemeshos/go-spacemesh/trie"
+	"math/big"
+)
+
+var ErrProofMismatch = errors.New("proof doesn't prove the account balance and nonce")
+
+// AccountProof is the balance and nonce of an account with the trie nodes proving them against the state root of
+// a layer. An account which doesn't exist is proven with a zero balance and nonce
+type AccountProof struct {
+	Address common.Address
+	Layer   LayerID
+	Root    common.Hash
+	Balance *big.Int
+	Nonce   uint64
+	Proof   [][]byte // the rlp encoded trie nodes on the path from the root to the account
+}
+
+// proofList collects the proof nodes written by the trie
+type proofList [][]byte
+
+func (p *proofList) Put(key []byte, value []byte) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// GetProof returns the trie nodes proving the account against the intermediate root of the state
+func (self *StateDB) GetProof(addr common.Address) ([][]byte, error) {
+	self.IntermediateRoot(false)
+
+	var proof proofList
+	if err := self.globalTrie.Prove(crypto.Keccak256(addr[:]), 0, &proof); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// ProveAccount returns the balance and nonce of the account with their proof against the state root of the layer,
+// it returns ErrLayerNotFound if the layer isn't retained in the history
+func (tp *TransactionProcessor) ProveAccount(layer LayerID, addr common.Address) (*AccountProof, error) {
+	root, err := tp.history.Get(layer)
+	if err != nil {
+		return nil, err
+	}
+
+	tp.mu.Lock()
+	db := tp.globalState.db
+	tp.mu.Unlock()
+
+	state, err := New(root, db)
+	if err != nil {
+		return nil, err
+	}
+
+	proof, err := state.GetProof(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccountProof{
+		Address: addr,
+		Layer:   layer,
+		Root:    root,
+		Balance: new(big.Int).Set(state.GetBalance(addr)),
+		Nonce:   state.GetNonce(addr),
+		Proof:   proof,
+	}, nil
+}
+
+// VerifyAccountProof checks the proof against a state root the verifier trusts, e.g. a root agreed on by the network
+// for the layer. It doesn't need access to the state, a light client verifies the balance and nonce of its account
+// received from an untrusted node
+func VerifyAccountProof(root common.Hash, proof *AccountProof) error {
+	if proof.Root != root {
+		return ErrProofMismatch
+	}
+
+	nodes := database.NewMemDatabase()
+	for _, node := range proof.Proof {
+		if err := nodes.Put(crypto.Keccak256(node), node); err != nil {
+			return err
+		}
+	}
+
+	value, _, err := trie.VerifyProof(root, crypto.Keccak256(proof.Address[:]), nodes)
+	if err != nil {
+		return err
+	}
+
+	account := Account{Balance: new(big.Int)}
+	if value != nil {
+		if err := rlp.DecodeBytes(value, &account); err != nil {
+			return err
+		}
+	}
+
+	if proof.Balance == nil || account.Balance.Cmp(proof.Balance) != 0 || account.Nonce != proof.Nonce {
+		return ErrProofMismatch
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package state
+
+import (
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/rlp"
+	"github.com/spacemeshos/go-spacemesh/state/config"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestTransactionProcessor_ProveAccount(t *testing.T) {
+	state, processor := newTestProcessor(config.Config{})
+	obj1 := createAccount(state, []byte{0x51}, 100, 0)
+	obj2 := createAccount(state, []byte{0x52}, 0, 0)
+	createAccount(state, []byte{0x53}, 7, 0)
+	state.Commit(false)
+
+	_, err := processor.ApplyTransactions(1, testBeacon, nil, Transactions{createTransaction(0, obj1.address, obj2.address, 10)})
+	assert.NoError(t, err)
+	root1, err := processor.history.Get(1)
+	assert.NoError(t, err)
+	_, err = processor.ApplyTransactions(2, testBeacon, nil, Transactions{createTransaction(1, obj1.address, obj2.address, 5)})
+	assert.NoError(t, err)
+	root2, err := processor.history.Get(2)
+	assert.NoError(t, err)
+
+	proof, err := processor.ProveAccount(1, obj2.address)
+	assert.NoError(t, err)
+	assert.Equal(t, root1, proof.Root)
+	assert.Equal(t, big.NewInt(10), proof.Balance)
+	assert.NoError(t, VerifyAccountProof(root1, proof))
+
+	proof, err = processor.ProveAccount(2, obj1.address)
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(85), proof.Balance)
+	assert.Equal(t, uint64(2), proof.Nonce)
+	assert.NoError(t, VerifyAccountProof(root2, proof))
+
+	// the proof is verified by a light client which received it encoded
+	data, err := rlp.EncodeToBytes(proof)
+	assert.NoError(t, err)
+	received := &AccountProof{}
+	assert.NoError(t, rlp.DecodeBytes(data, received))
+	assert.NoError(t, VerifyAccountProof(root2, received))
+
+	// the proof isn't valid against the root of another layer
+	assert.Equal(t, ErrProofMismatch, VerifyAccountProof(root1, proof))
+
+	_, err = processor.ProveAccount(3, obj1.address)
+	assert.Equal(t, ErrLayerNotFound, err)
+}
+
+func TestVerifyAccountProof_Absent(t *testing.T) {
+	state, processor := newTestProcessor(config.Config{})
+	createAccount(state, []byte{0x51}, 100, 0)
+	createAccount(state, []byte{0x52}, 3, 0)
+	state.Commit(false)
+	_, err := processor.ApplyTransactions(1, testBeacon, nil, nil)
+	assert.NoError(t, err)
+	root, err := processor.history.Get(1)
+	assert.NoError(t, err)
+
+	// an account which doesn't exist is proven to have no balance
+	proof, err := processor.ProveAccount(1, accountAddress([]byte{0x54}))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, proof.Balance.Sign())
+	assert.NoError(t, VerifyAccountProof(root, proof))
+
+	proof.Balance = big.NewInt(5)
+	assert.Equal(t, ErrProofMismatch, VerifyAccountProof(root, proof))
+}
+
+func TestVerifyAccountProof_Forged(t *testing.T) {
+	state, processor := newTestProcessor(config.Config{})
+	obj1 := createAccount(state, []byte{0x51}, 100, 4)
+	createAccount(state, []byte{0x52}, 3, 0)
+	state.Commit(false)
+	_, err := processor.ApplyTransactions(1, testBeacon, nil, nil)
+	assert.NoError(t, err)
+	root, err := processor.history.Get(1)
+	assert.NoError(t, err)
+
+	prove := func() *AccountProof {
+		proof, err := processor.ProveAccount(1, obj1.address)
+		assert.NoError(t, err)
+		assert.NoError(t, VerifyAccountProof(root, proof))
+		return proof
+	}
+
+	proof := prove()
+	proof.Balance = big.NewInt(1000)
+	assert.Equal(t, ErrProofMismatch, VerifyAccountProof(root, proof))
+
+	proof = prove()
+	proof.Nonce = 3
+	assert.Equal(t, ErrProofMismatch, VerifyAccountProof(root, proof))
+
+	// the proof of one account doesn't prove another
+	proof = prove()
+	proof.Address = accountAddress([]byte{0x52})
+	assert.Error(t, VerifyAccountProof(root, proof))
+
+	// a proof node changed to claim another balance doesn't hash to the root
+	proof = prove()
+	last := proof.Proof[len(proof.Proof)-1]
+	forged := make([]byte, len(last))
+	copy(forged, last)
+	forged[len(forged)-1] ^= 0x01
+	proof.Proof[len(proof.Proof)-1] = forged
+	assert.Error(t, VerifyAccountProof(root, proof))
+
+	proof = prove()
+	proof.Proof = proof.Proof[:len(proof.Proof)-1]
+	assert.Error(t, VerifyAccountProof(root, proof))
+
+	// the proof nodes don't hash to another trusted root
+	proof = prove()
+	proof.Root = common.BytesToHash([]byte{0x01})
+	assert.Equal(t, ErrProofMismatch, VerifyAccountProof(root, proof))
+	assert.Error(t, VerifyAccountProof(proof.Root, proof))
+}
@@ -0,0 +1,307 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/rlp"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"math/big"
+)
+
+// ReceiptStatus is the result of applying a transaction, a failed transaction has the status of the reason it
+// wasn't applied
+type ReceiptStatus uint8
+
+const (
+	ReceiptApplied      ReceiptStatus = iota
+	ReceiptFailed                     // failed for a reason other than the ones below
+	ReceiptErrSignature               // ErrSignature
+	ReceiptErrOrigin                  // ErrOrigin
+	ReceiptErrGasLimit                // ErrGasLimit
+	ReceiptErrRecipient               // ErrRecipient
+	ReceiptErrFunds                   // ErrFunds
+	ReceiptErrNonce                   // ErrNonce
+)
+
+var statusErrors = map[ReceiptStatus]string{
+	ReceiptErrSignature: ErrSignature,
+	ReceiptErrOrigin:    ErrOrigin,
+	ReceiptErrGasLimit:  ErrGasLimit,
+	ReceiptErrRecipient: ErrRecipient,
+	ReceiptErrFunds:     ErrFunds,
+	ReceiptErrNonce:     ErrNonce,
+}
+
+// receiptStatus returns the status of a transaction which failed with the error
+func receiptStatus(err error) ReceiptStatus {
+	for status, reason := range statusErrors {
+		if err.Error() == reason {
+			return status
+		}
+	}
+	return ReceiptFailed
+}
+
+// Failed returns true if the transaction wasn't applied
+func (s ReceiptStatus) Failed() bool {
+	return s != ReceiptApplied
+}
+
+func (s ReceiptStatus) String() string {
+	switch s {
+	case ReceiptApplied:
+		return "applied"
+	case ReceiptFailed:
+		return "failed"
+	}
+	if reason, ok := statusErrors[s]; ok {
+		return reason
+	}
+	return "unknown status"
+}
+
+var ErrReceiptNotFound = errors.New("receipt not found")
+
+var (
+	receiptPrefix = []byte("r") // receipt by transaction hash and layer
+	addressPrefix = []byte("a") // transaction hash by origin and recipient address, layer and index in the layer
+	layerPrefix   = []byte("l") // transaction hashes by layer
+)
+
+// Receipt is the result of applying a transaction in a layer
+type Receipt struct {
+	TxHash    common.Hash
+	Layer     LayerID
+	Status    ReceiptStatus
+	Fee       *big.Int
+	Origin    common.Address
+	Recipient common.Address
+	Root      common.Hash // the state root after the layer was applied
+}
+
+type Receipts []*Receipt
+
+// ReceiptDB is the database the receipts are stored in, the receipts of an address are read by iterating over
+// the keys of its index entries
+type ReceiptDB interface {
+	database.Database
+	NewIteratorWithPrefix(prefix []byte) iterator.Iterator
+}
+
+// ReceiptStore persists the receipts of the applied layers indexed by transaction hash and by address. A transaction
+// included in several layers has a receipt of each layer, so reverting a layer doesn't lose the receipts of the
+// others. Each transaction of a layer has its own index entry per address, so writing a layer doesn't rewrite the
+// entries of the previous layers
+type ReceiptStore struct {
+	db ReceiptDB
+}
+
+func NewReceiptStore(db ReceiptDB) *ReceiptStore {
+	return &ReceiptStore{db: db}
+}
+
+func prefixed(prefix []byte, key []byte) []byte {
+	return append(append(make([]byte, 0, len(prefix)+len(key)), prefix...), key...)
+}
+
+func layerKey(layer LayerID) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(layer))
+	return prefixed(layerPrefix, key)
+}
+
+// receiptKey returns the key of the receipt of the transaction in the layer, the receipts of a transaction are ordered
+// by layer
+func receiptKey(hash common.Hash, layer LayerID) []byte {
+	key := make([]byte, common.HashLength+8)
+	copy(key, hash[:])
+	binary.BigEndian.PutUint64(key[common.HashLength:], uint64(layer))
+	return prefixed(receiptPrefix, key)
+}
+
+// addressKey returns the key of the index entry of the transaction at the index in the layer, the entries of an address
+// are ordered by the layer and the index the transactions were applied in
+func addressKey(addr common.Address, layer LayerID, index int) []byte {
+	key := make([]byte, common.AddressLength+12)
+	copy(key, addr[:])
+	binary.BigEndian.PutUint64(key[common.AddressLength:], uint64(layer))
+	binary.BigEndian.PutUint32(key[common.AddressLength+8:], uint32(index))
+	return prefixed(addressPrefix, key)
+}
+
+func (rs *ReceiptStore) getHashes(key []byte) ([]common.Hash, error) {
+	has, err := rs.db.Has(key)
+	if err != nil || !has {
+		return nil, err
+	}
+
+	data, err := rs.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []common.Hash
+	if err := rlp.DecodeBytes(data, &hashes); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// PutLayer writes the receipts of a layer and indexes them in a single batch. A layer which is applied again
+// overwrites the receipts of its transactions in the layer and replaces its index entries
+func (rs *ReceiptStore) PutLayer(layer LayerID, receipts Receipts) error {
+	batch := rs.db.NewBatch()
+	if err := rs.deleteIndex(batch, layer); err != nil {
+		return err
+	}
+
+	hashes := make([]common.Hash, 0, len(receipts))
+	for i, receipt := range receipts {
+		data, err := rlp.EncodeToBytes(receipt)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(receiptKey(receipt.TxHash, layer), data); err != nil {
+			return err
+		}
+		hashes = append(hashes, receipt.TxHash)
+
+		for _, addr := range []common.Address{receipt.Origin, receipt.Recipient} {
+			if err := batch.Put(addressKey(addr, layer, i), receipt.TxHash[:]); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := rlp.EncodeToBytes(hashes)
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(layerKey(layer), data); err != nil {
+		return err
+	}
+
+	return batch.Write()
+}
+
+// DeleteLayer removes the receipts of a reverted layer and their address index entries
+func (rs *ReceiptStore) DeleteLayer(layer LayerID) error {
+	hashes, err := rs.getHashes(layerKey(layer))
+	if err != nil {
+		return err
+	}
+
+	batch := rs.db.NewBatch()
+	if err := rs.deleteIndex(batch, layer); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if err := batch.Delete(receiptKey(hash, layer)); err != nil {
+			return err
+		}
+	}
+	if err := batch.Delete(layerKey(layer)); err != nil {
+		return err
+	}
+
+	return batch.Write()
+}
+
+// deleteIndex adds the deletion of the address index entries of the layer's transactions to the batch
+func (rs *ReceiptStore) deleteIndex(batch database.Batch, layer LayerID) error {
+	hashes, err := rs.getHashes(layerKey(layer))
+	if err != nil {
+		return err
+	}
+
+	for i, hash := range hashes {
+		receipt, err := rs.receipt(receiptKey(hash, layer))
+		if err == ErrReceiptNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, addr := range []common.Address{receipt.Origin, receipt.Recipient} {
+			if err := batch.Delete(addressKey(addr, layer, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Get returns the receipt of the layer the transaction was applied in, or of the latest layer it failed in if it
+// wasn't applied. ErrReceiptNotFound is returned if the transaction wasn't included in any layer
+func (rs *ReceiptStore) Get(hash common.Hash) (*Receipt, error) {
+	it := rs.db.NewIteratorWithPrefix(prefixed(receiptPrefix, hash[:]))
+	defer it.Release()
+
+	var latest *Receipt
+	for it.Next() {
+		receipt := &Receipt{}
+		if err := rlp.DecodeBytes(it.Value(), receipt); err != nil {
+			return nil, err
+		}
+		if !receipt.Status.Failed() {
+			return receipt, nil
+		}
+		latest = receipt
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return nil, ErrReceiptNotFound
+	}
+	return latest, nil
+}
+
+// receipt returns the receipt stored in the key or ErrReceiptNotFound
+func (rs *ReceiptStore) receipt(key []byte) (*Receipt, error) {
+	has, err := rs.db.Has(key)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, ErrReceiptNotFound
+	}
+
+	data, err := rs.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &Receipt{}
+	if err := rlp.DecodeBytes(data, receipt); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// ByAddress returns the receipts of the transactions sent from or to the address in the order they were applied, a
+// transaction included in several layers has a receipt of each
+func (rs *ReceiptStore) ByAddress(addr common.Address) (Receipts, error) {
+	it := rs.db.NewIteratorWithPrefix(prefixed(addressPrefix, addr[:]))
+	defer it.Release()
+
+	var receipts Receipts
+	for it.Next() {
+		key := it.Key()
+		layer := LayerID(binary.BigEndian.Uint64(key[len(addressPrefix)+common.AddressLength:]))
+		receipt, err := rs.receipt(receiptKey(common.BytesToHash(it.Value()), layer))
+		if err == ErrReceiptNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
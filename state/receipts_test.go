@@ -0,0 +1,212 @@
+package state
+
+import (
+	"bytes"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/state/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+)
+
+func TestTransactionProcessor_Receipts(t *testing.T) {
+	state, _ := New(common.Hash{}, NewDatabase(database.NewMemDatabase()))
+	receipts := NewReceiptStore(database.NewMemDatabase())
+	processor := NewTransactionProcessor(state, NewStateHistory(database.NewMemDatabase(), 0), receipts, config.Config{TransferGas: 2})
+	obj1 := createAccount(state, []byte{0x31}, 100, 0)
+	obj2 := createAccount(state, []byte{0x32}, 1, 0)
+	missing := accountAddress([]byte{0x33})
+	state.Commit(false)
+
+	applied := createPricedTransaction(0, obj1.address, obj2.address, 10, 3)
+	nonce := createPricedTransaction(5, obj1.address, obj2.address, 10, 3)
+	funds := createPricedTransaction(0, obj2.address, obj1.address, 10, 1)
+	origin := createPricedTransaction(0, missing, obj1.address, 10, 1)
+
+	failed, err := processor.ApplyTransactions(1, testBeacon, nil, Transactions{applied, nonce, funds, origin})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), failed)
+	root := state.IntermediateRoot(false)
+
+	receipt, err := receipts.Get(applied.Hash())
+	assert.NoError(t, err)
+	assert.Equal(t, &Receipt{TxHash: applied.Hash(), Layer: 1, Status: ReceiptApplied, Fee: big.NewInt(6),
+		Origin: obj1.address, Recipient: obj2.address, Root: root}, receipt)
+
+	for tx, status := range map[*Transaction]ReceiptStatus{nonce: ReceiptErrNonce, funds: ReceiptErrFunds, origin: ReceiptErrOrigin} {
+		receipt, err := receipts.Get(tx.Hash())
+		assert.NoError(t, err)
+		assert.Equal(t, status, receipt.Status)
+		assert.True(t, receipt.Status.Failed())
+		assert.Equal(t, 0, receipt.Fee.Sign())
+		assert.Equal(t, LayerID(1), receipt.Layer)
+		assert.Equal(t, root, receipt.Root)
+	}
+
+	_, err = receipts.Get(createTransaction(1, obj1.address, obj2.address, 1).Hash())
+	assert.Equal(t, ErrReceiptNotFound, err)
+}
+
+// addressEntries returns the number of address index entries in the database
+func addressEntries(db *database.MemDatabase) int {
+	entries := 0
+	for _, key := range db.Keys() {
+		if bytes.HasPrefix(key, addressPrefix) {
+			entries++
+		}
+	}
+	return entries
+}
+
+func TestReceiptStore_ByAddress(t *testing.T) {
+	db := database.NewMemDatabase()
+	receipts := NewReceiptStore(db)
+	addr1, addr2, addr3 := accountAddress([]byte{0x31}), accountAddress([]byte{0x32}), accountAddress([]byte{0x33})
+	r1 := &Receipt{TxHash: common.BytesToHash([]byte{0x01}), Layer: 1, Status: ReceiptApplied, Fee: big.NewInt(1),
+		Origin: addr1, Recipient: addr2}
+	r2 := &Receipt{TxHash: common.BytesToHash([]byte{0x02}), Layer: 1, Status: ReceiptErrNonce,
+		Fee: new(big.Int), Origin: addr2, Recipient: addr3}
+	r3 := &Receipt{TxHash: common.BytesToHash([]byte{0x03}), Layer: 2, Status: ReceiptApplied, Fee: big.NewInt(1),
+		Origin: addr1, Recipient: addr1}
+	assert.NoError(t, receipts.PutLayer(1, Receipts{r1, r2}))
+	assert.NoError(t, receipts.PutLayer(2, Receipts{r3}))
+
+	byAddress, err := receipts.ByAddress(addr1)
+	assert.NoError(t, err)
+	assert.Equal(t, Receipts{r1, r3}, byAddress)
+	byAddress, err = receipts.ByAddress(addr2)
+	assert.NoError(t, err)
+	assert.Equal(t, Receipts{r1, r2}, byAddress)
+	byAddress, err = receipts.ByAddress(accountAddress([]byte{0x34}))
+	assert.NoError(t, err)
+	assert.Empty(t, byAddress)
+
+	// the receipts of a reverted layer are removed from both indexes
+	assert.NoError(t, receipts.DeleteLayer(2))
+	_, err = receipts.Get(r3.TxHash)
+	assert.Equal(t, ErrReceiptNotFound, err)
+	byAddress, err = receipts.ByAddress(addr1)
+	assert.NoError(t, err)
+	assert.Equal(t, Receipts{r1}, byAddress)
+	assert.Equal(t, 4, addressEntries(db))
+}
+
+func TestReceiptStore_PutLayerAgain(t *testing.T) {
+	db := database.NewMemDatabase()
+	receipts := NewReceiptStore(db)
+	addr1, addr2 := accountAddress([]byte{0x31}), accountAddress([]byte{0x32})
+	r1 := &Receipt{TxHash: common.BytesToHash([]byte{0x01}), Layer: 1, Status: ReceiptApplied, Fee: big.NewInt(1),
+		Origin: addr1, Recipient: addr2}
+	r2 := &Receipt{TxHash: common.BytesToHash([]byte{0x02}), Layer: 1, Status: ReceiptApplied, Fee: big.NewInt(1),
+		Origin: addr1, Recipient: addr2}
+	assert.NoError(t, receipts.PutLayer(1, Receipts{r1, r2}))
+
+	// the layer applied again replaces its index entries
+	assert.NoError(t, receipts.PutLayer(1, Receipts{r2}))
+	byAddress, err := receipts.ByAddress(addr1)
+	assert.NoError(t, err)
+	assert.Equal(t, Receipts{r2}, byAddress)
+	assert.Equal(t, 2, addressEntries(db))
+
+	// a transaction included again in a later layer has a receipt of each layer
+	again := &Receipt{TxHash: r2.TxHash, Layer: 2, Status: ReceiptErrNonce, Fee: new(big.Int),
+		Origin: addr1, Recipient: addr2}
+	assert.NoError(t, receipts.PutLayer(2, Receipts{again}))
+	byAddress, err = receipts.ByAddress(addr2)
+	assert.NoError(t, err)
+	assert.Equal(t, Receipts{r2, again}, byAddress)
+
+	assert.NoError(t, receipts.DeleteLayer(1))
+	assert.NoError(t, receipts.DeleteLayer(2))
+	assert.Equal(t, 0, addressEntries(db))
+}
+
+func TestReceiptStore_RevertReincludedTransaction(t *testing.T) {
+	receipts := NewReceiptStore(database.NewMemDatabase())
+	addr1, addr2 := accountAddress([]byte{0x31}), accountAddress([]byte{0x32})
+	applied := &Receipt{TxHash: common.BytesToHash([]byte{0x01}), Layer: 1, Status: ReceiptApplied, Fee: big.NewInt(1),
+		Origin: addr1, Recipient: addr2}
+	again := &Receipt{TxHash: applied.TxHash, Layer: 3, Status: ReceiptErrNonce, Fee: new(big.Int),
+		Origin: addr1, Recipient: addr2}
+	assert.NoError(t, receipts.PutLayer(1, Receipts{applied}))
+	assert.NoError(t, receipts.PutLayer(3, Receipts{again}))
+
+	// the failed inclusion in a later layer doesn't hide the receipt of the layer the transaction was applied in
+	receipt, err := receipts.Get(applied.TxHash)
+	assert.NoError(t, err)
+	assert.Equal(t, applied, receipt)
+
+	// reverting the later layer keeps the receipt of the layer the transaction was applied in
+	assert.NoError(t, receipts.DeleteLayer(3))
+	receipt, err = receipts.Get(applied.TxHash)
+	assert.NoError(t, err)
+	assert.Equal(t, applied, receipt)
+	byAddress, err := receipts.ByAddress(addr1)
+	assert.NoError(t, err)
+	assert.Equal(t, Receipts{applied}, byAddress)
+
+	// a transaction which only failed has the receipt of the latest layer it failed in
+	assert.NoError(t, receipts.PutLayer(3, Receipts{again}))
+	assert.NoError(t, receipts.DeleteLayer(1))
+	receipt, err = receipts.Get(applied.TxHash)
+	assert.NoError(t, err)
+	assert.Equal(t, again, receipt)
+}
+
+func TestReceiptStore_Restart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "receipts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := database.NewLDBDatabase(dir, 0, 0)
+	assert.NoError(t, err)
+	receipt := &Receipt{TxHash: common.BytesToHash([]byte{0x01}), Layer: 3, Status: ReceiptErrFunds,
+		Fee: new(big.Int), Origin: accountAddress([]byte{0x31}), Recipient: accountAddress([]byte{0x32}),
+		Root: common.BytesToHash([]byte{0x04})}
+	assert.NoError(t, NewReceiptStore(db).PutLayer(3, Receipts{receipt}))
+	db.Close()
+
+	db, err = database.NewLDBDatabase(dir, 0, 0)
+	assert.NoError(t, err)
+	defer db.Close()
+	receipts := NewReceiptStore(db)
+	stored, err := receipts.Get(receipt.TxHash)
+	assert.NoError(t, err)
+	assert.Equal(t, receipt, stored)
+	byAddress, err := receipts.ByAddress(receipt.Recipient)
+	assert.NoError(t, err)
+	assert.Equal(t, Receipts{receipt}, byAddress)
+}
+
+func TestTransactionProcessor_ResetReceipts(t *testing.T) {
+	state, _ := New(common.Hash{}, NewDatabase(database.NewMemDatabase()))
+	receipts := NewReceiptStore(database.NewMemDatabase())
+	processor := NewTransactionProcessor(state, NewStateHistory(database.NewMemDatabase(), 0), receipts, config.Config{})
+	obj1 := createAccount(state, []byte{0x31}, 100, 0)
+	obj2 := createAccount(state, []byte{0x32}, 1, 0)
+	state.Commit(false)
+
+	tx1 := createTransaction(0, obj1.address, obj2.address, 1)
+	tx2 := createTransaction(1, obj1.address, obj2.address, 1)
+	_, err := processor.ApplyTransactions(1, testBeacon, nil, Transactions{tx1})
+	assert.NoError(t, err)
+	_, err = processor.ApplyTransactions(2, testBeacon, nil, Transactions{tx2})
+	assert.NoError(t, err)
+
+	assert.NoError(t, processor.Reset(1))
+	_, err = receipts.Get(tx1.Hash())
+	assert.NoError(t, err)
+	_, err = receipts.Get(tx2.Hash())
+	assert.Equal(t, ErrReceiptNotFound, err)
+
+	// the transaction gets a receipt of the layer it is applied in again
+	_, err = processor.ApplyTransactions(3, testBeacon, nil, Transactions{tx2})
+	assert.NoError(t, err)
+	receipt, err := receipts.Get(tx2.Hash())
+	assert.NoError(t, err)
+	assert.Equal(t, LayerID(3), receipt.Layer)
+	assert.Equal(t, ReceiptApplied, receipt.Status)
+}
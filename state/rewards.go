@@ -0,0 +1,45 @@
+package state
+
+import (
+	"bytes"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"math/big"
+	"sort"
+)
+
+// layerReward returns the reward issued in the layer, it halves every halving interval
+func (tp *TransactionProcessor) layerReward(layer LayerID) *big.Int {
+	reward := big.NewInt(tp.conf.BaseReward)
+	if tp.conf.HalvingInterval > 0 {
+		reward.Rsh(reward, uint(uint64(layer)/tp.conf.HalvingInterval))
+	}
+	return reward
+}
+
+// payRewards splits the layer reward and the collected fees equally between the coinbases of the layer's valid blocks,
+// an author of several blocks gets a share for each of them. The remainder of the split is paid a unit each to the
+// lowest addresses, so the split doesn't depend on the order of the blocks. Without coinbases the fees are burnt
+// and no reward is issued. Needs to be called under mutex lock
+func (tp *TransactionProcessor) payRewards(layer LayerID, coinbases []common.Address) {
+	fees := tp.fees
+	tp.fees = new(big.Int)
+	if len(coinbases) == 0 {
+		return
+	}
+
+	sorted := make([]common.Address, len(coinbases))
+	copy(sorted, coinbases)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+	})
+
+	total := new(big.Int).Add(tp.layerReward(layer), fees)
+	share, remainder := new(big.Int).DivMod(total, big.NewInt(int64(len(sorted))), new(big.Int))
+	for i, coinbase := range sorted {
+		amount := new(big.Int).Set(share)
+		if remainder.Cmp(big.NewInt(int64(i))) > 0 {
+			amount.Add(amount, big.NewInt(1))
+		}
+		tp.globalState.AddBalance(coinbase, amount)
+	}
+}
@@ -0,0 +1,138 @@
+package state
+
+import (
+	"bytes"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/state/config"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"sort"
+	"testing"
+)
+
+func newTestProcessor(conf config.Config) (*StateDB, *TransactionProcessor) {
+	state, _ := New(common.Hash{}, NewDatabase(database.NewMemDatabase()))
+	return state, NewTransactionProcessor(state, NewStateHistory(database.NewMemDatabase(), 0), NewReceiptStore(database.NewMemDatabase()), conf)
+}
+
+func totalBalance(state *StateDB, addrs ...common.Address) *big.Int {
+	total := new(big.Int)
+	for _, addr := range addrs {
+		total.Add(total, state.GetBalance(addr))
+	}
+	return total
+}
+
+func createPricedTransaction(nonce uint64, origin common.Address, destination common.Address, amount int64, price int64) *Transaction {
+	tx := createTransaction(nonce, origin, destination, amount)
+	tx.Price = big.NewInt(price)
+	SignTransaction(tx, testKeys[origin])
+	return tx
+}
+
+func TestTransactionProcessor_FeesAndRewards(t *testing.T) {
+	state, processor := newTestProcessor(config.Config{BaseReward: 100, TransferGas: 2})
+	obj1 := createAccount(state, []byte{0x11}, 100, 0)
+	obj2 := createAccount(state, []byte{0x12}, 10, 0)
+	coinbases := []common.Address{accountAddress([]byte{0x21}), accountAddress([]byte{0x22}), accountAddress([]byte{0x23})}
+	state.Commit(false)
+	all := append([]common.Address{obj1.address, obj2.address}, coinbases...)
+	before := totalBalance(state, all...)
+
+	failed, err := processor.ApplyTransactions(1, testBeacon, coinbases, Transactions{
+		createPricedTransaction(0, obj1.address, obj2.address, 10, 3),
+		createPricedTransaction(0, obj2.address, obj1.address, 1, 1),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), failed)
+
+	assert.Equal(t, big.NewInt(100-10-6+1), state.GetBalance(obj1.address))
+	assert.Equal(t, big.NewInt(10+10-1-2), state.GetBalance(obj2.address))
+	// the reward and the fees are split between the coinbases
+	for _, coinbase := range coinbases {
+		assert.Equal(t, big.NewInt((100+6+2)/3), state.GetBalance(coinbase))
+	}
+	// only the layer reward is issued
+	assert.Equal(t, new(big.Int).Add(before, big.NewInt(100)), totalBalance(state, all...))
+}
+
+func TestTransactionProcessor_FeesWithoutCoinbases(t *testing.T) {
+	state, processor := newTestProcessor(config.Config{BaseReward: 100, TransferGas: 2})
+	obj1 := createAccount(state, []byte{0x11}, 100, 0)
+	obj2 := createAccount(state, []byte{0x12}, 10, 0)
+	state.Commit(false)
+
+	failed, err := processor.ApplyTransactions(1, testBeacon, nil, Transactions{createPricedTransaction(0, obj1.address, obj2.address, 10, 3)})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), failed)
+
+	// the fees are burnt and no reward is issued
+	assert.Equal(t, big.NewInt(100+10-6), totalBalance(state, obj1.address, obj2.address))
+}
+
+func TestTransactionProcessor_RewardSplit(t *testing.T) {
+	coinbases := []common.Address{accountAddress([]byte{0x21}), accountAddress([]byte{0x22}), accountAddress([]byte{0x23})}
+	sorted := make([]common.Address, len(coinbases))
+	copy(sorted, coinbases)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+	})
+
+	conf := config.Config{BaseReward: 11}
+	state1, processor1 := newTestProcessor(conf)
+	_, err := processor1.ApplyTransactions(1, testBeacon, coinbases, nil)
+	assert.NoError(t, err)
+
+	// the remainder goes to the lowest addresses
+	assert.Equal(t, big.NewInt(4), state1.GetBalance(sorted[0]))
+	assert.Equal(t, big.NewInt(4), state1.GetBalance(sorted[1]))
+	assert.Equal(t, big.NewInt(3), state1.GetBalance(sorted[2]))
+
+	// the order of the blocks doesn't change the state
+	state2, processor2 := newTestProcessor(conf)
+	_, err = processor2.ApplyTransactions(1, testBeacon, []common.Address{coinbases[2], coinbases[0], coinbases[1]}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, state1.IntermediateRoot(false), state2.IntermediateRoot(false))
+
+	// an author of two blocks gets two shares
+	state3, processor3 := newTestProcessor(config.Config{BaseReward: 9})
+	_, err = processor3.ApplyTransactions(1, testBeacon, []common.Address{coinbases[0], coinbases[1], coinbases[0]}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(6), state3.GetBalance(coinbases[0]))
+	assert.Equal(t, big.NewInt(3), state3.GetBalance(coinbases[1]))
+}
+
+func TestTransactionProcessor_FeeErrors(t *testing.T) {
+	state, processor := newTestProcessor(config.Config{TransferGas: 2})
+	obj1 := createAccount(state, []byte{0x11}, 21, 0)
+	obj2 := createAccount(state, []byte{0x12}, 1, 0)
+	state.Commit(false)
+
+	low := createTransaction(0, obj1.address, obj2.address, 1)
+	low.GasLimit = 1
+	SignTransaction(low, testKeys[obj1.address])
+	err := processor.ApplyTransaction(low)
+	assert.Error(t, err)
+	assert.Equal(t, ErrGasLimit, err.Error())
+
+	// the amount is covered by the balance but the fee isn't
+	err = processor.ApplyTransaction(createPricedTransaction(0, obj1.address, obj2.address, 15, 3))
+	assert.Error(t, err)
+	assert.Equal(t, ErrFunds, err.Error())
+
+	assert.Equal(t, big.NewInt(21), state.GetBalance(obj1.address))
+	assert.Equal(t, uint64(0), state.GetNonce(obj1.address))
+}
+
+func TestTransactionProcessor_LayerReward(t *testing.T) {
+	_, processor := newTestProcessor(config.Config{BaseReward: 1000, HalvingInterval: 10})
+	assert.Equal(t, big.NewInt(1000), processor.layerReward(0))
+	assert.Equal(t, big.NewInt(1000), processor.layerReward(9))
+	assert.Equal(t, big.NewInt(500), processor.layerReward(10))
+	assert.Equal(t, big.NewInt(250), processor.layerReward(25))
+	assert.Equal(t, 0, processor.layerReward(1000).Sign())
+
+	_, processor = newTestProcessor(config.Config{BaseReward: 1000})
+	assert.Equal(t, big.NewInt(1000), processor.layerReward(1000))
+}
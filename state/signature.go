@@ -0,0 +1,115 @@
+package state
+
+import (
+	"errors"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/crypto/sha3"
+	"math/big"
+)
+
+// a compact signature is a header byte followed by R and S, the header encodes the recovery id and whether the key
+// is compressed. Only the canonical encoding of a compressed key and a low S is valid, so the transaction hash which
+// covers the signature can't be changed by re-encoding the same signature
+const (
+	signatureLength    = 65
+	compressedHeaderLo = 27 + 4
+	compressedHeaderHi = compressedHeaderLo + 3
+)
+
+var halfOrder = new(big.Int).Rsh(btcec.S256().N, 1)
+
+// canonicalSignature returns an error unless the signature has a compressed key header and a low S
+func canonicalSignature(sig []byte) error {
+	if len(sig) != signatureLength {
+		return errors.New("invalid signature length")
+	}
+	if sig[0] < compressedHeaderLo || sig[0] > compressedHeaderHi {
+		return errors.New("signature is not of a compressed key")
+	}
+	if new(big.Int).SetBytes(sig[33:]).Cmp(halfOrder) > 0 {
+		return errors.New("signature S is not in the lower half of the order")
+	}
+	return nil
+}
+
+// canonicalizeSignature replaces a high S of the signature with its low counterpart N-S, which flips the recovery id
+func canonicalizeSignature(sig []byte) {
+	s := new(big.Int).SetBytes(sig[33:])
+	if s.Cmp(halfOrder) <= 0 {
+		return
+	}
+
+	s.Sub(btcec.S256().N, s)
+	for i := range sig[33:] {
+		sig[33+i] = 0
+	}
+	b := s.Bytes()
+	copy(sig[signatureLength-len(b):], b)
+	sig[0] ^= 1
+}
+
+// PublicKeyToAddress returns the address of the account owned by the key,
+// which is the last 20 bytes of the keccak256 hash of the compressed public key
+func PublicKeyToAddress(pub crypto.PublicKey) common.Address {
+	hw := sha3.NewKeccak256()
+	hw.Write(pub.Bytes())
+	return common.BytesToAddress(hw.Sum(nil)[12:])
+}
+
+// signingHash is the hash of the RLP encoding of the transaction without its signature
+func (tx *Transaction) signingHash() common.Hash {
+	return rlpHash([]interface{}{
+		tx.AccountNonce,
+		tx.Price,
+		tx.GasLimit,
+		tx.Recipient,
+		tx.Amount,
+		tx.Payload,
+	})
+}
+
+// SignTransaction signs the transaction by the key of its origin account, the origin is recovered from the signature
+func SignTransaction(tx *Transaction, key crypto.PrivateKey) error {
+	hash := tx.signingHash()
+	sig, err := btcec.SignCompact(btcec.S256(), key.InternalKey(), hash[:], true)
+	if err != nil {
+		return err
+	}
+	canonicalizeSignature(sig)
+
+	tx.Signature = sig
+	tx.hash = nil
+	tx.origin = nil
+	return nil
+}
+
+// Origin returns the address of the account which signed the transaction
+func (tx *Transaction) Origin() (common.Address, error) {
+	if tx.origin != nil {
+		return *tx.origin, nil
+	}
+
+	if len(tx.Signature) == 0 {
+		return common.Address{}, errors.New("transaction is not signed")
+	}
+	if err := canonicalSignature(tx.Signature); err != nil {
+		return common.Address{}, err
+	}
+
+	hash := tx.signingHash()
+	pub, _, err := btcec.RecoverCompact(btcec.S256(), tx.Signature, hash[:])
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	key, err := crypto.NewPublicKey(pub.SerializeCompressed())
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	origin := PublicKeyToAddress(key)
+	tx.origin = &origin
+	return origin, nil
+}
@@ -1,9 +1,12 @@
 package sync
 
 import (
+	"github.com/gogo/protobuf/proto"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/mesh"
 	"github.com/spacemeshos/go-spacemesh/p2p/server"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/sync/pb"
 	"sync/atomic"
 	"time"
 )
@@ -11,6 +14,7 @@ import (
 type MessageServer server.MessageServer
 
 const blockProtocol = "/blocks/1.0/"
+const NewBlockProtocol = "newBlock"
 
 type BlockListener struct {
 	*server.MessageServer
@@ -22,6 +26,8 @@ type BlockListener struct {
 	unknownQueue chan mesh.BlockID //todo consider benefits of changing to stack
 	startLock    uint32
 	timeout      time.Duration
+	network      server.Service
+	newBlocks    chan service.GossipMessage
 	exit         chan struct {
 	}
 }
@@ -40,7 +46,9 @@ func (bl *BlockListener) OnNewBlock(b *mesh.Block) {
 	bl.addUnknownToQueue(b)
 }
 
-func NewBlockListener(net server.Service, bv BlockValidator, layers mesh.Mesh, timeout time.Duration, concurrency int) *BlockListener {
+// NewBlockListener creates a listener serving block requests through the limiter, the limiter of the syncer is shared
+// so a peer can't bypass its request rate by sending its requests over the block protocol
+func NewBlockListener(net server.Service, bv BlockValidator, layers mesh.Mesh, limiter *RequestLimiter, timeout time.Duration, concurrency int) *BlockListener {
 	bl := BlockListener{
 		BlockValidator: bv,
 		Mesh:           layers,
@@ -48,8 +56,10 @@ func NewBlockListener(net server.Service, bv BlockValidator, layers mesh.Mesh, t
 		MessageServer:  server.NewMsgServer(net, blockProtocol, timeout),
 		semaphore:      make(chan struct{}, concurrency),
 		unknownQueue:   make(chan mesh.BlockID, 200), //todo tune buffer size + get buffer from config
+		network:        net,
+		newBlocks:      net.RegisterGossipProtocol(NewBlockProtocol),
 		exit:           make(chan struct{})}
-	bl.RegisterMsgHandler(BLOCK, newBlockRequestHandler(layers))
+	bl.RegisterPeerMsgHandler(BLOCK, limiter.limit(newBlockRequestHandler(layers), busyResponse(BLOCK)))
 	return &bl
 }
 
@@ -59,6 +69,16 @@ func (bl *BlockListener) run() {
 		case <-bl.exit:
 			log.Info("run stoped")
 			return
+		case data, ok := <-bl.newBlocks:
+			if !ok {
+				log.Info("new blocks channel closed, run stopped")
+				return
+			}
+			bl.semaphore <- struct{}{}
+			go func() {
+				defer func() { <-bl.semaphore }()
+				bl.handleNewBlock(data)
+			}()
 		case id := <-bl.unknownQueue:
 			log.Debug("fetch block ", id, "buffer is at ", len(bl.unknownQueue)/cap(bl.unknownQueue), " capacity")
 			bl.semaphore <- struct{}{}
@@ -70,6 +90,45 @@ func (bl *BlockListener) run() {
 	}
 }
 
+// BroadcastBlock gossips a newly created block to the network
+func (bl *BlockListener) BroadcastBlock(b *mesh.Block) error {
+	payload, err := proto.Marshal(blockAsPb(b))
+	if err != nil {
+		log.Error("could not marshal block ", b.ID(), " ", err)
+		return err
+	}
+	return bl.network.Broadcast(NewBlockProtocol, payload)
+}
+
+// handleNewBlock validates a gossiped block, adds it to the mesh and queues its unknown referenced blocks for fetching.
+// the validation result is reported back so only valid blocks are relayed
+func (bl *BlockListener) handleNewBlock(data service.GossipMessage) {
+	if data == nil {
+		log.Error("got nil gossip block message")
+		return
+	}
+	msg := &pb.Block{}
+	if err := proto.Unmarshal(data.Bytes(), msg); err != nil {
+		log.Error("could not unmarshal gossip block ", err)
+		data.ReportValidation(false)
+		return
+	}
+
+	block := pbAsBlock(msg)
+	if !bl.ValidateBlock(block) {
+		log.Error("gossip block ", block.ID(), " is not valid")
+		data.ReportValidation(false)
+		return
+	}
+
+	data.ReportValidation(true)
+	if err := bl.AddBlock(block); err != nil {
+		log.Debug("could not add gossip block ", block.ID(), " ", err)
+		return
+	}
+	bl.addUnknownToQueue(block)
+}
+
 //todo handle case where no peer knows the block
 func (bl *BlockListener) FetchBlock(id mesh.BlockID) {
 	for _, p := range bl.GetPeers() {
@@ -2,9 +2,11 @@ package sync
 
 import (
 	"fmt"
+	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/spacemeshos/go-spacemesh/mesh"
 	"github.com/spacemeshos/go-spacemesh/p2p/server"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
 )
@@ -21,7 +23,7 @@ func (pm PeersMock) Close() {
 	return
 }
 func ListenerFactory(serv server.Service, peers Peers, name string) *BlockListener {
-	nbl := NewBlockListener(serv, BlockValidatorMock{}, getMesh("TestBlockListener_"+name), 1*time.Second, 2)
+	nbl := NewBlockListener(serv, BlockValidatorMock{}, getMesh("TestBlockListener_"+name), NewRequestLimiter(0, 0, 0), 1*time.Second, 2)
 	nbl.Peers = peers //override peers with mock
 	return nbl
 }
@@ -132,3 +134,119 @@ loop:
 }
 
 //todo integration testing
+
+type BlockValidatorRejectMock struct {
+}
+
+func (BlockValidatorRejectMock) ValidateBlock(block *mesh.Block) bool {
+	return false
+}
+
+func TestBlockListener_ReceiveGossipBlock(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	bl1 := ListenerFactory(n1, PeersMock{func() []Peer { return []Peer{n2.PublicKey()} }}, "5")
+	bl2 := ListenerFactory(n2, PeersMock{func() []Peer { return []Peer{n1.PublicKey()} }}, "6")
+	bl1.Start()
+	bl2.Start()
+
+	block1 := mesh.NewExistingBlock(mesh.BlockID(1001), 0, nil)
+	block2 := mesh.NewExistingBlock(mesh.BlockID(1002), 1, nil)
+	block2.BlockVotes[block1.ID()] = true
+
+	bl1.AddBlock(block1)
+	bl1.AddBlock(block2)
+	assert.NoError(t, bl1.BroadcastBlock(block2))
+
+	timeout := time.After(10 * time.Second)
+loop:
+	for {
+		select {
+		case <-timeout:
+			t.Error("timed out ")
+			break loop
+		default:
+			// block1 is fetched since the gossiped block2 references it
+			if _, err := bl2.GetBlock(block1.Id); err == nil {
+				break loop
+			}
+		}
+	}
+
+	_, err := bl2.GetBlock(block2.Id)
+	assert.NoError(t, err)
+}
+
+func TestBlockListener_RejectInvalidGossipBlock(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	bl1 := ListenerFactory(n1, PeersMock{func() []Peer { return []Peer{n2.PublicKey()} }}, "7")
+	bl2 := NewBlockListener(n2, BlockValidatorRejectMock{}, getMesh("TestBlockListener_8"), NewRequestLimiter(0, 0, 0), 1*time.Second, 2)
+	bl2.Peers = PeersMock{func() []Peer { return []Peer{n1.PublicKey()} }}
+	bl1.Start()
+	bl2.Start()
+
+	block := mesh.NewExistingBlock(mesh.BlockID(1003), 1, nil)
+	assert.NoError(t, bl1.BroadcastBlock(block))
+
+	time.Sleep(500 * time.Millisecond)
+	_, err := bl2.GetBlock(block.Id)
+	assert.Error(t, err)
+}
+
+func TestBlockListener_RateLimit(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	limiter := NewRequestLimiter(1, 2, 0)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	// in memory meshes so the block is added on every run
+	layers := mesh.NewMesh(database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase())
+	bl1 := NewBlockListener(n1, BlockValidatorMock{}, layers, limiter, 1*time.Second, 2)
+	bl2 := NewBlockListener(n2, BlockValidatorMock{},
+		mesh.NewMesh(database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase()),
+		NewRequestLimiter(0, 0, 0), 1*time.Second, 2)
+	bl2.Peers = PeersMock{func() []Peer { return []Peer{n1.PublicKey()} }}
+	block := mesh.NewExistingBlock(mesh.BlockID(1004), 1, nil)
+	assert.NoError(t, bl1.AddBlock(block))
+
+	ch, err := sendBlockRequest(bl2.MessageServer, n1.PublicKey(), block.ID())
+	assert.NoError(t, err)
+	assert.NotNil(t, <-ch)
+
+	// the peer spent the rest of its burst on sync requests served through the shared limiter
+	assert.True(t, limiter.allow(n2.PublicKey(), requestCost))
+	ch, err = sendBlockRequest(bl2.MessageServer, n1.PublicKey(), block.ID())
+	assert.NoError(t, err)
+	assert.Nil(t, <-ch, "throttled peer was served a block")
+
+	now = now.Add(time.Second)
+	ch, err = sendBlockRequest(bl2.MessageServer, n1.PublicKey(), block.ID())
+	assert.NoError(t, err)
+	assert.NotNil(t, <-ch)
+}
+
+func TestBlockListener_StopOnClosedGossipChannel(t *testing.T) {
+	sim := service.NewSimulator()
+	bl := NewBlockListener(sim.NewNode(), BlockValidatorMock{},
+		mesh.NewMesh(database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase()),
+		NewRequestLimiter(0, 0, 0), 1*time.Second, 2)
+	newBlocks := make(chan service.GossipMessage)
+	bl.newBlocks = newBlocks
+
+	stopped := make(chan struct{})
+	go func() {
+		bl.run()
+		close(stopped)
+	}()
+	close(newBlocks)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("run didn't stop when the gossip channel was closed")
+	}
+}
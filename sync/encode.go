@@ -0,0 +1,24 @@
+package sync
+
+import (
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spacemeshos/go-spacemesh/sync/pb"
+)
+
+func blockAsPb(block *mesh.Block) *pb.Block {
+	vm := make([]uint32, 0, len(block.BlockVotes))
+	for b := range block.BlockVotes {
+		vm = append(vm, uint32(b))
+	}
+	return &pb.Block{Id: uint32(block.ID()), Layer: uint32(block.Layer()), VisibleMesh: vm, Coinbase: block.Coinbase.Bytes()}
+}
+
+func pbAsBlock(data *pb.Block) *mesh.Block {
+	block := mesh.NewExistingBlock(mesh.BlockID(data.GetId()), mesh.LayerID(data.GetLayer()), nil)
+	for _, b := range data.GetVisibleMesh() {
+		block.BlockVotes[mesh.BlockID(b)] = true
+	}
+	block.Coinbase = common.BytesToAddress(data.GetCoinbase())
+	return block
+}
@@ -0,0 +1,270 @@
+package sync
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spacemeshos/go-spacemesh/sync/pb"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	layerFileSuffix = ".layer"
+	layersDbDir     = "layers"
+	blocksDbDir     = "blocks"
+)
+
+// BlockSource provides the blocks of a layer for synchronisation, it is implemented by the p2p fetcher (Syncer)
+// and by local readers used for offline sync
+type BlockSource interface {
+	GetLayerBlockIDs(index mesh.LayerID) (chan mesh.BlockID, error)
+	FetchBlock(id mesh.BlockID, bv BlockValidator) (*mesh.Block, error)
+}
+
+// LocalBlockSource is a BlockSource backed by local storage
+type LocalBlockSource interface {
+	BlockSource
+	LatestLayer() mesh.LayerID
+	Close()
+}
+
+func layerFileName(index mesh.LayerID) string {
+	return strconv.FormatUint(uint64(index), 10) + layerFileSuffix
+}
+
+// ExportLayers writes layers from to to (inclusive) of the mesh to dir, one file per layer
+func ExportLayers(layers mesh.Mesh, from mesh.LayerID, to mesh.LayerID, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for i := from; i <= to; i++ {
+		layer, err := layers.GetLayer(i)
+		if err != nil {
+			return fmt.Errorf("could not get layer %v, err: %v", i, err)
+		}
+		data := &pb.Layer{Layer: uint32(i), Blocks: make([]*pb.Block, 0, len(layer.Blocks()))}
+		for _, b := range layer.Blocks() {
+			data.Blocks = append(data.Blocks, blockAsPb(b))
+		}
+		payload, err := proto.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, layerFileName(i)), payload, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveSource reads exported layers from a directory or a tar archive of layer files
+type archiveSource struct {
+	readLayer func(name string) ([]byte, error)
+	latest    mesh.LayerID
+	mutex     sync.RWMutex
+	blocks    map[mesh.BlockID]*mesh.Block //blocks of the layers read so far
+}
+
+// NewArchiveSource opens a directory of exported layers, or a tar archive of such a directory
+func NewArchiveSource(path string) (LocalBlockSource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &archiveSource{blocks: make(map[mesh.BlockID]*mesh.Block)}
+	var names []string
+	if info.IsDir() {
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		src.readLayer = func(name string) ([]byte, error) {
+			return ioutil.ReadFile(filepath.Join(path, name))
+		}
+	} else {
+		files, err := readTar(path)
+		if err != nil {
+			return nil, err
+		}
+		for name := range files {
+			names = append(names, name)
+		}
+		src.readLayer = func(name string) ([]byte, error) {
+			if data, ok := files[name]; ok {
+				return data, nil
+			}
+			return nil, errors.New("file " + name + " not found in archive")
+		}
+	}
+
+	found := false
+	for _, name := range names {
+		if !strings.HasSuffix(name, layerFileSuffix) {
+			continue
+		}
+		index, err := strconv.ParseUint(strings.TrimSuffix(name, layerFileSuffix), 10, 32)
+		if err != nil {
+			log.Warning("skipping unknown file ", name, " in ", path)
+			continue
+		}
+		if !found || mesh.LayerID(index) > src.latest {
+			src.latest = mesh.LayerID(index)
+			found = true
+		}
+	}
+	if !found {
+		return nil, errors.New("no exported layers found in " + path)
+	}
+
+	return src, nil
+}
+
+// readTar reads the regular files of a tar archive into memory, keyed by their base name
+func readTar(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[filepath.Base(hdr.Name)] = data
+	}
+}
+
+func (as *archiveSource) LatestLayer() mesh.LayerID {
+	return as.latest
+}
+
+func (as *archiveSource) GetLayerBlockIDs(index mesh.LayerID) (chan mesh.BlockID, error) {
+	payload, err := as.readLayer(layerFileName(index))
+	if err != nil {
+		return nil, err
+	}
+	data := &pb.Layer{}
+	if err := proto.Unmarshal(payload, data); err != nil {
+		return nil, fmt.Errorf("could not unmarshal layer %v, err: %v", index, err)
+	}
+	if mesh.LayerID(data.Layer) != index {
+		return nil, fmt.Errorf("file of layer %v contains layer %v", index, data.Layer)
+	}
+
+	ids := make(map[mesh.BlockID]bool, len(data.Blocks))
+	as.mutex.Lock()
+	for _, b := range data.Blocks {
+		block := pbAsBlock(b)
+		as.blocks[block.ID()] = block
+		ids[block.ID()] = true
+	}
+	as.mutex.Unlock()
+	return keysAsChan(ids), nil
+}
+
+func (as *archiveSource) FetchBlock(id mesh.BlockID, bv BlockValidator) (*mesh.Block, error) {
+	as.mutex.RLock()
+	b, ok := as.blocks[id]
+	as.mutex.RUnlock()
+	if !ok {
+		return nil, errors.New("block not found in archive")
+	}
+	if !bv.ValidateBlock(b) {
+		return nil, errors.New("block is not valid")
+	}
+	return b, nil
+}
+
+func (as *archiveSource) Close() {}
+
+// meshDbSource reads layers from the mesh databases of another node's data directory, opened read-only
+type meshDbSource struct {
+	reader *mesh.DbReader
+	latest mesh.LayerID
+}
+
+// NewMeshDbSource opens the mesh databases in dataDir read-only, the latest layer is the last consecutive
+// layer found in the databases
+func NewMeshDbSource(dataDir string) (LocalBlockSource, error) {
+	layers, err := database.NewReadOnlyLevelDbStore(filepath.Join(dataDir, layersDbDir))
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := database.NewReadOnlyLevelDbStore(filepath.Join(dataDir, blocksDbDir))
+	if err != nil {
+		layers.Close()
+		return nil, err
+	}
+
+	src := &meshDbSource{reader: mesh.NewDbReader(layers, blocks)}
+	if _, err := src.reader.GetLayer(0); err != nil {
+		src.Close()
+		return nil, errors.New("no layers found in " + dataDir)
+	}
+	for {
+		if _, err := src.reader.GetLayer(src.latest + 1); err != nil {
+			break
+		}
+		src.latest++
+	}
+	return src, nil
+}
+
+func (ms *meshDbSource) LatestLayer() mesh.LayerID {
+	return ms.latest
+}
+
+func (ms *meshDbSource) GetLayerBlockIDs(index mesh.LayerID) (chan mesh.BlockID, error) {
+	layer, err := ms.reader.GetLayer(index)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[mesh.BlockID]bool, len(layer.Blocks()))
+	for _, b := range layer.Blocks() {
+		ids[b.ID()] = true
+	}
+	return keysAsChan(ids), nil
+}
+
+func (ms *meshDbSource) FetchBlock(id mesh.BlockID, bv BlockValidator) (*mesh.Block, error) {
+	b, err := ms.reader.GetBlock(id)
+	if err != nil {
+		return nil, err
+	}
+	if !bv.ValidateBlock(b) {
+		return nil, errors.New("block is not valid")
+	}
+	return b, nil
+}
+
+func (ms *meshDbSource) Close() {
+	ms.reader.Close()
+}
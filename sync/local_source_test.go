@@ -0,0 +1,133 @@
+package sync
+
+import (
+	"archive/tar"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createLayers adds layers 0 to count-1 to the mesh with two blocks each and waits until they are stored
+func createLayers(t *testing.T, layers mesh.Mesh, count int) []*mesh.Block {
+	blocks := make([]*mesh.Block, 0, 2*count)
+	for i := 0; i < count; i++ {
+		lid := mesh.LayerID(i)
+		b1 := mesh.NewBlock(true, nil, time.Now(), lid)
+		b2 := mesh.NewBlock(true, nil, time.Now(), lid)
+		assert.NoError(t, layers.AddLayer(mesh.NewExistingLayer(lid, []*mesh.Block{b1, b2})))
+		blocks = append(blocks, b1, b2)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for _, b := range blocks {
+		for _, err := layers.GetBlock(b.ID()); err != nil; _, err = layers.GetBlock(b.ID()) {
+			select {
+			case <-timeout:
+				t.Fatal("timed out waiting for blocks to be stored")
+			default:
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+	return blocks
+}
+
+func testOfflineSync(t *testing.T, source LocalBlockSource, blocks []*mesh.Block, name string) {
+	layers := getMesh(name + "_" + time.Now().String())
+	defer layers.Close()
+	syncer := NewOfflineSync(layers, BlockValidatorMock{}, source, source.LatestLayer(), Configuration{hdist: 0, concurrency: 2, layerSize: 10}, *log.New(name, "", "").Logger)
+	syncer.Synchronise()
+
+	assert.True(t, syncer.IsSynced(), "offline sync did not reach the latest layer of the source")
+	// layer 0 is the starting point of the syncer, all following layers are synced from the source
+	for _, b := range blocks[2:] {
+		_, err := layers.GetBlock(b.ID())
+		assert.NoError(t, err, "block was not synced")
+	}
+}
+
+func TestArchiveSource_Directory(t *testing.T) {
+	src := getMesh("TestArchiveSource_Directory_" + time.Now().String())
+	defer src.Close()
+	blocks := createLayers(t, src, 4)
+
+	dir, err := ioutil.TempDir("", "layers")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ExportLayers(src, 0, 3, dir))
+
+	source, err := NewArchiveSource(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, mesh.LayerID(3), source.LatestLayer())
+
+	testOfflineSync(t, source, blocks, "TestArchiveSource_Directory")
+}
+
+func TestArchiveSource_Tar(t *testing.T) {
+	src := getMesh("TestArchiveSource_Tar_" + time.Now().String())
+	defer src.Close()
+	blocks := createLayers(t, src, 3)
+
+	dir, err := ioutil.TempDir("", "layers")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ExportLayers(src, 0, 2, filepath.Join(dir, "export")))
+
+	archive := filepath.Join(dir, "layers.tar")
+	f, err := os.Create(archive)
+	assert.NoError(t, err)
+	tw := tar.NewWriter(f)
+	files, err := ioutil.ReadDir(filepath.Join(dir, "export"))
+	assert.NoError(t, err)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "export", file.Name()))
+		assert.NoError(t, err)
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "export/" + file.Name(), Mode: 0600, Size: int64(len(data)), Typeflag: tar.TypeReg}))
+		_, err = tw.Write(data)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, f.Close())
+
+	source, err := NewArchiveSource(archive)
+	assert.NoError(t, err)
+	assert.Equal(t, mesh.LayerID(2), source.LatestLayer())
+
+	testOfflineSync(t, source, blocks, "TestArchiveSource_Tar")
+}
+
+func TestArchiveSource_Empty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layers")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	_, err = NewArchiveSource(dir)
+	assert.Error(t, err)
+}
+
+func TestMeshDbSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesh")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	open := func(name string) database.DB {
+		db, err := database.NewLDBDatabase(filepath.Join(dir, name), 0, 0)
+		assert.NoError(t, err)
+		return db
+	}
+	src := mesh.NewMesh(open(layersDbDir), open(blocksDbDir), open("contextually_valid"))
+	blocks := createLayers(t, src, 3)
+	src.Close()
+
+	source, err := NewMeshDbSource(dir)
+	assert.NoError(t, err)
+	defer source.Close()
+	assert.Equal(t, mesh.LayerID(2), source.LatestLayer())
+
+	testOfflineSync(t, source, blocks, "TestMeshDbSource")
+}
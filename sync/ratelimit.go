@@ -0,0 +1,129 @@
+package sync
+
+import (
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/p2p/server"
+	"sync"
+	"time"
+)
+
+const (
+	requestCost         = 1    //tokens charged up front for serving a request
+	responseBytesPerTok = 1024 //response bytes charged as one additional token
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RequestLimiter limits the rate of requests served per peer using token buckets and bounds the
+// number of requests served concurrently. a peer is charged for each request and for the size of its response
+type RequestLimiter struct {
+	rate    float64 //tokens added per second to each peer's bucket, 0 for no limit
+	burst   float64 //max tokens a peer can accumulate
+	workers chan struct{}
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// NewRequestLimiter returns a limiter adding rate tokens per second to each peer's bucket of up to burst tokens and
+// serving up to workers requests concurrently, 0 for no limit
+func NewRequestLimiter(rate int, burst int, workers int) *RequestLimiter {
+	rl := &RequestLimiter{
+		rate:    float64(rate),
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+	if rl.burst < rl.rate {
+		rl.burst = rl.rate
+	}
+	if workers > 0 {
+		rl.workers = make(chan struct{}, workers)
+	}
+	return rl
+}
+
+// refill returns the bucket of peer after adding the tokens it earned since it was last used, must be called under lock
+func (rl *RequestLimiter) refill(peer string) *tokenBucket {
+	now := rl.now()
+	b, ok := rl.buckets[peer]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[peer] = b
+		return b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rl.rate
+	if b.tokens > rl.burst {
+		b.tokens = rl.burst
+	}
+	b.last = now
+	return b
+}
+
+// allow charges peer with cost tokens, returns false if the peer doesn't have enough tokens
+func (rl *RequestLimiter) allow(peer crypto.PublicKey, cost float64) bool {
+	if rl.rate <= 0 {
+		return true
+	}
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	b := rl.refill(peer.String())
+	if b.tokens < cost {
+		return false
+	}
+	b.tokens -= cost
+	return true
+}
+
+// charge deducts cost tokens from peer after a request was served, the balance may become negative
+func (rl *RequestLimiter) charge(peer crypto.PublicKey, cost float64) {
+	if rl.rate <= 0 || cost <= 0 {
+		return
+	}
+	rl.mutex.Lock()
+	rl.refill(peer.String()).tokens -= cost
+	rl.mutex.Unlock()
+}
+
+// acquireWorker reserves a worker without blocking, returns false if all workers are busy
+func (rl *RequestLimiter) acquireWorker() bool {
+	if rl.workers == nil {
+		return true
+	}
+	select {
+	case rl.workers <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (rl *RequestLimiter) releaseWorker() {
+	if rl.workers != nil {
+		<-rl.workers
+	}
+}
+
+// limit wraps handler so that throttled requests are answered with the busy payload instead of being served
+func (rl *RequestLimiter) limit(handler func(msg []byte) []byte, busy []byte) server.PeerMsgHandler {
+	return func(sender crypto.PublicKey, msg []byte) []byte {
+		if !rl.allow(sender, requestCost) {
+			log.Debug("peer ", sender, " exceeded its request rate, reply busy")
+			return busy
+		}
+
+		if !rl.acquireWorker() {
+			log.Debug("all workers are busy, reply busy to peer ", sender)
+			return busy
+		}
+		defer rl.releaseWorker()
+
+		payload := handler(msg)
+		rl.charge(sender, float64(len(payload)/responseBytesPerTok))
+		return payload
+	}
+}
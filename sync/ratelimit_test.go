@@ -0,0 +1,103 @@
+package sync
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spacemeshos/go-spacemesh/sync/pb"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRequestLimiter_Allow(t *testing.T) {
+	rl := NewRequestLimiter(1, 3, 0)
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+	_, a, _ := crypto.GenerateKeyPair()
+	_, b, _ := crypto.GenerateKeyPair()
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, rl.allow(a, requestCost), "request within burst was throttled")
+	}
+	assert.False(t, rl.allow(a, requestCost), "request exceeding burst was allowed")
+	assert.True(t, rl.allow(b, requestCost), "peers should not share a bucket")
+
+	now = now.Add(time.Second)
+	assert.True(t, rl.allow(a, requestCost), "bucket was not refilled")
+	assert.False(t, rl.allow(a, requestCost), "bucket was refilled too much")
+}
+
+func TestRequestLimiter_Charge(t *testing.T) {
+	rl := NewRequestLimiter(1, 2, 0)
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+	_, a, _ := crypto.GenerateKeyPair()
+
+	assert.True(t, rl.allow(a, requestCost))
+	rl.charge(a, 3) // expensive response puts the peer in debt
+	now = now.Add(2 * time.Second)
+	assert.False(t, rl.allow(a, requestCost), "peer in debt was allowed")
+	now = now.Add(time.Second)
+	assert.True(t, rl.allow(a, requestCost))
+}
+
+func TestRequestLimiter_NoLimit(t *testing.T) {
+	rl := NewRequestLimiter(0, 0, 0)
+	_, a, _ := crypto.GenerateKeyPair()
+	for i := 0; i < 1000; i++ {
+		assert.True(t, rl.allow(a, requestCost))
+		assert.True(t, rl.acquireWorker())
+	}
+}
+
+func TestRequestLimiter_Workers(t *testing.T) {
+	rl := NewRequestLimiter(0, 0, 2)
+	assert.True(t, rl.acquireWorker())
+	assert.True(t, rl.acquireWorker())
+	assert.False(t, rl.acquireWorker(), "acquired more workers than the pool size")
+	rl.releaseWorker()
+	assert.True(t, rl.acquireWorker())
+}
+
+func TestRequestLimiter_Limit(t *testing.T) {
+	rl := NewRequestLimiter(1, 1, 1)
+	_, a, _ := crypto.GenerateKeyPair()
+	served := 0
+	handler := rl.limit(func(msg []byte) []byte {
+		served++
+		return []byte("response")
+	}, busyResponse(LAYER_HASH))
+
+	assert.Equal(t, []byte("response"), handler(a, nil))
+	resp := &pb.LayerHashResp{}
+	assert.NoError(t, proto.Unmarshal(handler(a, nil), resp))
+	assert.True(t, resp.Busy, "throttled request did not get a busy reply")
+	assert.Equal(t, 1, served)
+}
+
+func TestSyncProtocol_BusyBlockRequest(t *testing.T) {
+	busyConf := conf
+	busyConf.serveConcurrency = 1
+	syncs, p2ps := SyncMockFactory(2, busyConf, "TestSyncProtocol_BusyBlockRequest_")
+	syncObj1 := syncs[0]
+	defer syncObj1.Close()
+	syncObj2 := syncs[1]
+	defer syncObj2.Close()
+	lid := mesh.LayerID(1)
+	block := mesh.NewExistingBlock(mesh.BlockID(456), lid, nil)
+	syncObj1.AddLayer(mesh.NewExistingLayer(lid, []*mesh.Block{block}))
+
+	ch, err := sendBlockRequest(syncObj2.MessageServer, p2ps[0].Node.PublicKey(), block.ID())
+	assert.NoError(t, err)
+	b := <-ch
+	assert.NotNil(t, b)
+
+	rl := NewRequestLimiter(0, 0, 1)
+	rl.acquireWorker() // all workers are taken
+	syncObj1.RegisterPeerMsgHandler(BLOCK, rl.limit(newBlockRequestHandler(syncObj1.Mesh), busyResponse(BLOCK)))
+	ch, err = sendBlockRequest(syncObj2.MessageServer, p2ps[0].Node.PublicKey(), block.ID())
+	assert.NoError(t, err)
+	b = <-ch
+	assert.Nil(t, b, "busy peer returned a block")
+}
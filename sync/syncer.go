@@ -17,11 +17,14 @@ type BlockValidator interface {
 }
 
 type Configuration struct {
-	hdist          uint32 //dist of consensus layers from newst layer
-	syncInterval   time.Duration
-	concurrency    int //number of workers for sync method
-	layerSize      int
-	requestTimeout time.Duration
+	hdist            uint32 //dist of consensus layers from newst layer
+	syncInterval     time.Duration
+	concurrency      int //number of workers for sync method
+	layerSize        int
+	requestTimeout   time.Duration
+	peerRequestRate  int //requests per second served to a single peer, 0 for no limit
+	peerRequestBurst int //max requests a single peer can send in a burst
+	serveConcurrency int //max number of sync requests served concurrently, 0 for no limit
 }
 
 type Syncer struct {
@@ -31,6 +34,10 @@ type Syncer struct {
 	BlockValidator //todo should not be here
 	Configuration
 	*server.MessageServer
+	source    BlockSource
+	limiter   *RequestLimiter
+	offline   bool   //syncs up to target instead of hdist behind the latest known layer
+	target    uint32 //the last layer an offline sync synchronises
 	SyncLock  uint32
 	startLock uint32
 	forceSync chan bool
@@ -113,14 +120,44 @@ func NewSync(srv server.Service, layers mesh.Mesh, bv BlockValidator, conf Confi
 		exit:           make(chan struct{}),
 	}
 
-	s.RegisterMsgHandler(LAYER_HASH, newLayerHashRequestHandler(layers))
-	s.RegisterMsgHandler(BLOCK, newBlockRequestHandler(layers))
-	s.RegisterMsgHandler(LAYER_IDS, newLayerIdsRequestHandler(layers))
+	s.source = &s
+
+	s.limiter = NewRequestLimiter(conf.peerRequestRate, conf.peerRequestBurst, conf.serveConcurrency)
+	s.RegisterPeerMsgHandler(LAYER_HASH, s.limiter.limit(newLayerHashRequestHandler(layers), busyResponse(LAYER_HASH)))
+	s.RegisterPeerMsgHandler(BLOCK, s.limiter.limit(newBlockRequestHandler(layers), busyResponse(BLOCK)))
+	s.RegisterPeerMsgHandler(LAYER_IDS, s.limiter.limit(newLayerIdsRequestHandler(layers), busyResponse(LAYER_IDS)))
+
+	return &s
+}
+
+// Limiter returns the limiter of the requests the syncer serves, it is shared with the block listener so a peer
+// can't bypass its request rate by sending its requests over the block protocol
+func (s *Syncer) Limiter() *RequestLimiter {
+	return s.limiter
+}
+
+// NewOfflineSync creates a syncer that synchronises layers up to target from a local block source instead of the network
+func NewOfflineSync(layers mesh.Mesh, bv BlockValidator, source LocalBlockSource, target mesh.LayerID, conf Configuration, log logging.Logger) *Syncer {
+	s := Syncer{
+		BlockValidator: bv,
+		Configuration:  conf,
+		Logger:         log,
+		Mesh:           layers,
+		source:         source,
+		offline:        true,
+		target:         uint32(target),
+		forceSync:      make(chan bool),
+		exit:           make(chan struct{}),
+	}
 
 	return &s
 }
 
 func (s *Syncer) maxSyncLayer() uint32 {
+	if s.offline {
+		return s.target
+	}
+
 	if uint32(s.LatestKnownLayer()) < s.hdist {
 		return 0
 	}
@@ -130,7 +167,7 @@ func (s *Syncer) maxSyncLayer() uint32 {
 
 func (s *Syncer) Synchronise() {
 	for i := s.LatestIrreversible(); i < s.maxSyncLayer(); i++ {
-		blockIds, err := s.getLayerBlockIDs(mesh.LayerID(i + 1)) //returns a set of all known blocks in the mesh
+		blockIds, err := s.source.GetLayerBlockIDs(mesh.LayerID(i + 1)) //returns a set of all known blocks in the mesh
 		if err != nil {
 			log.Error("could not get layer block ids: ", err)
 			log.Debug("synchronise failed, local layer index is ", s.LatestIrreversible())
@@ -144,14 +181,10 @@ func (s *Syncer) Synchronise() {
 		for i := 0; i < s.concurrency; i++ {
 			go func() {
 				for id := range blockIds {
-					for _, p := range s.GetPeers() {
-						if bCh, err := sendBlockRequest(s.MessageServer, p, mesh.BlockID(id)); err == nil {
-							b := <-bCh
-							if b != nil && s.ValidateBlock(b) { //some validation testing
-								output <- b
-								break
-							}
-						}
+					if b, err := s.source.FetchBlock(id, s.BlockValidator); err == nil {
+						output <- b
+					} else {
+						log.Error("could not fetch block ", id, " ", err)
 					}
 				}
 				if atomic.AddInt32(&count, -1); atomic.LoadInt32(&count) == 0 { // last one closes the channel
@@ -196,20 +229,31 @@ func sendBlockRequest(msgServ *server.MessageServer, peer Peer, id mesh.BlockID)
 			log.Error("could not unmarshal block data")
 			return
 		}
-		mp := make(map[mesh.BlockID]bool)
-		for _, b := range data.Block.VisibleMesh {
-			mp[mesh.BlockID(b)] = true
+		if data.Busy {
+			log.Debug("peer ", peer, " is busy, block request ", id, " was not served")
+			return
 		}
-
-		block := mesh.NewExistingBlock(mesh.BlockID(data.Block.GetId()), mesh.LayerID(data.Block.GetLayer()), nil)
-		block.BlockVotes = mp
-		ch <- block
+		ch <- pbAsBlock(data.Block)
 	}
 
 	return ch, msgServ.SendRequest(BLOCK, payload, peer, foo)
 }
 
-func (s *Syncer) getLayerBlockIDs(index mesh.LayerID) (chan mesh.BlockID, error) {
+// FetchBlock fetches a block from the first peer that returns a valid block
+func (s *Syncer) FetchBlock(id mesh.BlockID, bv BlockValidator) (*mesh.Block, error) {
+	for _, p := range s.GetPeers() {
+		if bCh, err := sendBlockRequest(s.MessageServer, p, id); err == nil {
+			b := <-bCh
+			if b != nil && bv.ValidateBlock(b) { //some validation testing
+				return b, nil
+			}
+		}
+	}
+	return nil, errors.New("could not get a valid block from any peer")
+}
+
+// GetLayerBlockIDs returns the ids of the blocks of the layer as reported by peers that agree on the layer hash
+func (s *Syncer) GetLayerBlockIDs(index mesh.LayerID) (chan mesh.BlockID, error) {
 
 	m, err := s.getLayerHashes(index)
 
@@ -235,9 +279,13 @@ func (s *Syncer) getIdsForHash(m map[string]Peer, index mesh.LayerID) (chan mesh
 
 	idSet := make(map[mesh.BlockID]bool, s.layerSize) //change uint32 to BlockId
 	timeout := time.After(s.requestTimeout)
+	busyCounter, reqs := 0, reqCounter
 	for reqCounter > 0 {
 		select {
 		case b := <-ch:
+			if b == nil { //peer was busy
+				busyCounter++
+			}
 			for _, id := range b {
 				bid := mesh.BlockID(id)
 				if _, exists := idSet[bid]; !exists {
@@ -255,6 +303,10 @@ func (s *Syncer) getIdsForHash(m map[string]Peer, index mesh.LayerID) (chan mesh
 		}
 	}
 
+	if reqs > 0 && busyCounter == reqs {
+		return nil, errors.New("all peers were busy, could not get block ids")
+	}
+
 	return keysAsChan(idSet), nil
 
 }
@@ -288,7 +340,9 @@ func (s *Syncer) getLayerHashes(index mesh.LayerID) (map[string]Peer, error) {
 		select {
 		// Got a timeout! fail with a timeout error
 		case pair := <-ch:
-			m[string(pair.hash)] = pair.peer
+			if pair.hash != nil {
+				m[string(pair.hash)] = pair.peer
+			}
 			resCounter--
 		case <-timeout:
 			if len(m) > 0 {
@@ -321,6 +375,11 @@ func (s *Syncer) sendLayerHashRequest(peer Peer, layer mesh.LayerID, ch chan pee
 			log.Error("could not unmarshal layer hash response ", err)
 			return
 		}
+		if res.Busy {
+			log.Debug("peer ", peer, " is busy, layer hash request was not served")
+			ch <- peerHashPair{peer: peer}
+			return
+		}
 		ch <- peerHashPair{peer: peer, hash: res.Hash}
 	}
 	return ch, s.SendRequest(LAYER_HASH, payload, peer, foo)
@@ -342,6 +401,14 @@ func (s *Syncer) sendLayerIDsRequest(peer Peer, idx mesh.LayerID, ch chan []uint
 			log.Error("could not unmarshal layer ids response")
 			return
 		}
+		if data.Busy {
+			log.Debug("peer ", peer, " is busy, layer ids request was not served")
+			ch <- nil
+			return
+		}
+		if data.Ids == nil { //nil is reserved for busy peers
+			data.Ids = make([]uint32, 0)
+		}
 		ch <- data.Ids
 	}
 
@@ -362,12 +429,7 @@ func newBlockRequestHandler(layers mesh.Mesh) func(msg []byte) []byte {
 			return nil
 		}
 
-		vm := make([]uint32, 0, len(block.BlockVotes))
-		for b := range block.BlockVotes {
-			vm = append(vm, uint32(b))
-		}
-
-		payload, err := proto.Marshal(&pb.FetchBlockResp{Id: uint32(block.ID()), Block: &pb.Block{Id: uint32(block.ID()), Layer: uint32(block.Layer()), VisibleMesh: vm}})
+		payload, err := proto.Marshal(&pb.FetchBlockResp{Id: uint32(block.ID()), Block: blockAsPb(block)})
 		if err != nil {
 			log.Error("Error marshaling response message (FetchBlockResp), with BlockID: %d, LayerID: %d and err:", block.ID(), block.Layer(), err)
 			return nil
@@ -433,3 +495,25 @@ func newLayerIdsRequestHandler(layers mesh.Mesh) func(msg []byte) []byte {
 		return payload
 	}
 }
+
+// busyResponse returns the reply sent instead of serving a throttled request of type msgType
+func busyResponse(msgType server.MessageType) []byte {
+	var resp proto.Message
+	switch msgType {
+	case BLOCK:
+		resp = &pb.FetchBlockResp{Busy: true}
+	case LAYER_HASH:
+		resp = &pb.LayerHashResp{Busy: true}
+	case LAYER_IDS:
+		resp = &pb.LayerIdsResp{Busy: true}
+	default:
+		return nil
+	}
+
+	payload, err := proto.Marshal(resp)
+	if err != nil {
+		log.Error("Error marshaling busy response message with error:", err)
+		return nil
+	}
+	return payload
+}
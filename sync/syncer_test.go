@@ -3,13 +3,16 @@ package sync
 import (
 	"errors"
 	"fmt"
+	"github.com/gogo/protobuf/proto"
 	"github.com/google/uuid"
+	"github.com/spacemeshos/go-spacemesh/common"
 	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/mesh"
 	"github.com/spacemeshos/go-spacemesh/p2p"
 	"github.com/spacemeshos/go-spacemesh/p2p/server"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/sync/pb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"sync/atomic"
@@ -17,7 +20,7 @@ import (
 	"time"
 )
 
-var conf = Configuration{2, 15 * time.Second, 3, 300, 7000 * time.Millisecond}
+var conf = Configuration{2, 15 * time.Second, 3, 300, 7000 * time.Millisecond, 100, 200, 10}
 
 func SyncMockFactory(number int, conf Configuration, name string) (syncs []*Syncer, p2ps []*service.Node) {
 	nodes := make([]*Syncer, 0, number)
@@ -492,3 +495,18 @@ end:
 	log.Debug("sync 6 ", syncObj6.LatestIrreversible())
 	return
 }
+
+func TestBlockAsPb_Coinbase(t *testing.T) {
+	block := mesh.NewBlock(true, nil, time.Now(), 1)
+	block.Coinbase = common.BytesToAddress([]byte{0x01, 0x02})
+	block.BlockVotes[3] = true
+
+	data, err := proto.Marshal(blockAsPb(block))
+	assert.NoError(t, err)
+	received := &pb.Block{}
+	assert.NoError(t, proto.Unmarshal(data, received))
+
+	decoded := pbAsBlock(received)
+	assert.Equal(t, block.Coinbase, decoded.Coinbase)
+	assert.Equal(t, block.BlockVotes, decoded.BlockVotes)
+}